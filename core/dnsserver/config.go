@@ -43,6 +43,10 @@ type Config struct {
 	// TLSConfig when listening for encrypted connections (gRPC, DNS-over-TLS).
 	TLSConfig *tls.Config
 
+	// TsigSecret holds the TSIG secrets the server verifies requests with, keyed by the
+	// fully qualified, lower cased key name. The secrets are base64 encoded.
+	TsigSecret map[string]string
+
 	// Plugin stack.
 	Plugin []plugin.Plugin
 
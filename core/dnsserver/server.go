@@ -44,6 +44,8 @@ type Server struct {
 	debug        bool               // disable recover()
 	stacktrace   bool               // enable stacktrace in recover error log
 	classChaos   bool               // allow non-INET class queries
+	update       bool               // allow dynamic updates
+	tsigSecret   map[string]string  // TSIG secrets of all zones, see Config.TsigSecret
 }
 
 // NewServer returns a new CoreDNS server and compiles all plugins in to it. By default CH class
@@ -73,6 +75,14 @@ func NewServer(addr string, group []*Config) (*Server, error) {
 		// set the config per zone
 		s.zones[site.Zone] = site
 
+		// copy tsig secrets, the map stays nil when there are none so TSIG isn't verified at all
+		for key, secret := range site.TsigSecret {
+			if s.tsigSecret == nil {
+				s.tsigSecret = make(map[string]string)
+			}
+			s.tsigSecret[key] = secret
+		}
+
 		// compile custom plugin for everything
 		var stack plugin.Handler
 		for i := len(site.Plugin) - 1; i >= 0; i-- {
@@ -92,6 +102,10 @@ func NewServer(addr string, group []*Config) (*Server, error) {
 			if _, ok := EnableChaos[stack.Name()]; ok {
 				s.classChaos = true
 			}
+			// Accept dynamic updates when any of these plugins are loaded.
+			if _, ok := EnableUpdate[stack.Name()]; ok {
+				s.update = true
+			}
 		}
 		site.pluginChain = stack
 	}
@@ -115,7 +129,7 @@ func (s *Server) Serve(l net.Listener) error {
 		ctx := context.WithValue(context.Background(), Key{}, s)
 		ctx = context.WithValue(ctx, LoopKey{}, 0)
 		s.ServeDNS(ctx, w, r)
-	})}
+	}), TsigSecret: s.tsigSecret, MsgAcceptFunc: s.msgAcceptFunc()}
 	s.m.Unlock()
 
 	return s.server[tcp].ActivateAndServe()
@@ -129,7 +143,7 @@ func (s *Server) ServePacket(p net.PacketConn) error {
 		ctx := context.WithValue(context.Background(), Key{}, s)
 		ctx = context.WithValue(ctx, LoopKey{}, 0)
 		s.ServeDNS(ctx, w, r)
-	})}
+	}), TsigSecret: s.tsigSecret, MsgAcceptFunc: s.msgAcceptFunc()}
 	s.m.Unlock()
 
 	return s.server[udp].ActivateAndServe()
@@ -360,5 +374,35 @@ var EnableChaos = map[string]struct{}{
 	"proxy":   {},
 }
 
+// EnableUpdate is a map with plugin names for which we accept dynamic updates (RFC 2136), these
+// are rejected with NOTIMP by default.
+var EnableUpdate = map[string]struct{}{
+	"fdns": {},
+}
+
+// msgAcceptFunc returns the function that rejects malformed messages before they reach ServeDNS.
+func (s *Server) msgAcceptFunc() dns.MsgAcceptFunc {
+	if !s.update {
+		return dns.DefaultMsgAcceptFunc
+	}
+	return acceptUpdate
+}
+
+// acceptUpdate works like dns.DefaultMsgAcceptFunc, but also accepts dynamic updates. Their zone
+// section takes the place of the question section and the other sections may hold any number of
+// records.
+func acceptUpdate(dh dns.Header) dns.MsgAcceptAction {
+	if opcode := int(dh.Bits>>11) & 0xF; opcode != dns.OpcodeUpdate {
+		return dns.DefaultMsgAcceptFunc(dh)
+	}
+	if isResponse := dh.Bits&(1<<15) != 0; isResponse {
+		return dns.MsgIgnore
+	}
+	if dh.Qdcount != 1 {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
 // Quiet mode will not show any informative output on initialization.
 var Quiet bool
@@ -115,3 +115,61 @@ func BenchmarkCoreServeDNS(b *testing.B) {
 		s.ServeDNS(ctx, w, m)
 	}
 }
+
+func TestAcceptUpdate(t *testing.T) {
+	update := new(dns.Msg)
+	update.SetUpdate("example.com.")
+	update.Insert([]dns.RR{test.A("a.example.com. 300 IN A 127.0.0.1"), test.A("b.example.com. 300 IN A 127.0.0.1")})
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	tests := []struct {
+		msg      *dns.Msg
+		response bool
+		accept   dns.MsgAcceptFunc
+		expected dns.MsgAcceptAction
+	}{
+		{update, false, dns.DefaultMsgAcceptFunc, dns.MsgRejectNotImplemented},
+		{update, false, acceptUpdate, dns.MsgAccept},
+		{update, true, acceptUpdate, dns.MsgIgnore},
+		{query, false, acceptUpdate, dns.MsgAccept},
+	}
+	for i, tc := range tests {
+		m := tc.msg.Copy()
+		m.Response = tc.response
+		buf, err := m.Pack()
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		var dh dns.Header
+		dh.Id = m.Id
+		dh.Bits = uint16(buf[2])<<8 | uint16(buf[3])
+		dh.Qdcount = uint16(len(m.Question))
+		dh.Ancount = uint16(len(m.Answer))
+		dh.Nscount = uint16(len(m.Ns))
+		dh.Arcount = uint16(len(m.Extra))
+		if action := tc.accept(dh); action != tc.expected {
+			t.Errorf("Test %d: expected action %d, got %d", i, tc.expected, action)
+		}
+	}
+}
+
+func TestEnableUpdate(t *testing.T) {
+	s, err := NewServer("127.0.0.1:53", []*Config{testConfig("dns", testPlugin{})})
+	if err != nil {
+		t.Fatalf("Expected no error for NewServer, got %s", err)
+	}
+	if s.update {
+		t.Errorf("Expected updates to be rejected without an update plugin")
+	}
+
+	EnableUpdate["testplugin"] = struct{}{}
+	defer delete(EnableUpdate, "testplugin")
+	s, err = NewServer("127.0.0.1:53", []*Config{testConfig("dns", testPlugin{})})
+	if err != nil {
+		t.Fatalf("Expected no error for NewServer, got %s", err)
+	}
+	if !s.update {
+		t.Errorf("Expected updates to be accepted with an update plugin")
+	}
+}
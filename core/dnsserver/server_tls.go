@@ -52,7 +52,7 @@ func (s *ServerTLS) Serve(l net.Listener) error {
 		ctx := context.WithValue(context.Background(), Key{}, s.Server)
 		ctx = context.WithValue(ctx, LoopKey{}, 0)
 		s.ServeDNS(ctx, w, r)
-	})}
+	}), TsigSecret: s.tsigSecret, MsgAcceptFunc: s.msgAcceptFunc()}
 	s.m.Unlock()
 
 	return s.server[tcp].ActivateAndServe()
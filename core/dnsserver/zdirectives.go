@@ -28,11 +28,13 @@ var Directives = []string{
 	"errors",
 	"timescale",
 	"mqtt",
+	"events",
 	"log",
 	"dnstap",
 	"local",
 	"dns64",
 	"acl",
+	"pgacl",
 	"any",
 	"chaos",
 	"loadbalance",
@@ -4,11 +4,13 @@ package cache
 import (
 	"hash/fnv"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/cache"
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/pkg/response"
 	"github.com/coredns/coredns/request"
 
@@ -42,6 +44,10 @@ type Cache struct {
 	staleUpTo   time.Duration
 	verifyStale bool
 
+	// EDNS0 client subnet, the source prefix lengths of the client subnets of queries without one.
+	ecs          bool
+	ecsV4, ecsV6 uint8
+
 	// Testing.
 	now func() time.Time
 }
@@ -62,6 +68,8 @@ func New() *Cache {
 		prefetch:   0,
 		duration:   1 * time.Minute,
 		percentage: 10,
+		ecsV4:      24,
+		ecsV6:      56,
 		now:        time.Now,
 	}
 }
@@ -82,6 +90,40 @@ func key(qname string, m *dns.Msg, t response.Type) (bool, uint64) {
 	return true, hash(qname, m.Question[0].Qtype)
 }
 
+// Remove removes the cached positive and negative responses for qname and qtypes, or for all types when
+// qtypes is empty, including the ones cached for a client subnet.
+func (c *Cache) Remove(qname string, qtypes ...uint16) {
+	qname = strings.ToLower(qname)
+	for _, t := range qtypes {
+		k := hash(qname, t)
+		c.pcache.Remove(k)
+		c.ncache.Remove(k)
+	}
+	if len(qtypes) > 0 && !c.ecs {
+		return
+	}
+	// The responses for all types, or for a client subnet, have keys we can't compute, find them by
+	// their question in a single walk.
+	remove := func(items map[uint64]interface{}, key uint64) bool {
+		i, ok := items[key].(*item)
+		if !ok || !strings.EqualFold(i.Name, qname) {
+			return true
+		}
+		for _, t := range qtypes {
+			if i.QType == t {
+				delete(items, key)
+				return true
+			}
+		}
+		if len(qtypes) == 0 {
+			delete(items, key)
+		}
+		return true
+	}
+	c.pcache.Walk(remove)
+	c.ncache.Walk(remove)
+}
+
 func hash(qname string, qtype uint16) uint64 {
 	h := fnv.New64()
 	h.Write([]byte{byte(qtype >> 8)})
@@ -90,6 +132,42 @@ func hash(qname string, qtype uint16) uint64 {
 	return h.Sum64()
 }
 
+// subnetHash returns the key of the items for qname and qtype that are only valid for the client subnet.
+func subnetHash(qname string, qtype uint16, subnet []byte) uint64 {
+	h := fnv.New64()
+	h.Write([]byte{byte(qtype >> 8)})
+	h.Write([]byte{byte(qtype)})
+	h.Write([]byte(qname))
+	h.Write(subnet)
+	return h.Sum64()
+}
+
+// subnet returns the client subnet of the query in state: its EDNS0 client subnet option or else the
+// client's address, truncated to c.ecsV4 or c.ecsV6. It returns nil when c doesn't cache by client subnet.
+func (c *Cache) subnet(state request.Request) []byte {
+	if !c.ecs {
+		return nil
+	}
+	sub := edns.Subnet(state.Req)
+	if sub == nil {
+		sub = edns.NewSubnet(net.ParseIP(state.IP()), c.ecsV4, c.ecsV6)
+	}
+	if sub == nil {
+		return nil
+	}
+	return append([]byte{byte(sub.Family), sub.SourceNetmask}, sub.Address...)
+}
+
+// keys returns the keys under which the items for the query in state may be stored, the key for its client
+// subnet first.
+func (c *Cache) keys(state request.Request) []uint64 {
+	k := hash(state.Name(), state.QType())
+	if subnet := c.subnet(state); subnet != nil {
+		return []uint64{subnetHash(state.Name(), state.QType(), subnet), k}
+	}
+	return []uint64{k}
+}
+
 func computeTTL(msgTTL, minTTL, maxTTL time.Duration) time.Duration {
 	ttl := msgTTL
 	if ttl < minTTL {
@@ -111,6 +189,8 @@ type ResponseWriter struct {
 	do         bool // When true the original request had the DO bit set.
 	prefetch   bool // When true write nothing back to the client.
 	remoteAddr net.Addr
+
+	scope *edns.SubnetScope // The scope of the client subnet of the reply, as reported by the next plugins.
 }
 
 // newPrefetchResponseWriter returns a Cache ResponseWriter to be used in
@@ -151,6 +231,10 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 
 	// key returns empty string for anything we don't want to cache.
 	hasKey, key := key(w.state.Name(), res, mt)
+	// A reply for a client subnet is only stored for that subnet, a scope of 0 means it is valid for all.
+	if subnet := w.subnet(w.state); hasKey && subnet != nil && w.subnetScope(res) > 0 {
+		key = subnetHash(w.state.Name(), w.state.QType(), subnet)
+	}
 
 	msgTTL := dnsutil.MinimalTTL(res, mt)
 	var duration time.Duration
@@ -192,6 +276,18 @@ func (w *ResponseWriter) WriteMsg(res *dns.Msg) error {
 	return w.ResponseWriter.WriteMsg(res)
 }
 
+// subnetScope returns the scope prefix length of the client subnet of the reply res. That is the scope a
+// next plugin reported, or else the one in res. Without either, res isn't specific to the client subnet.
+func (w *ResponseWriter) subnetScope(res *dns.Msg) uint8 {
+	if w.scope != nil && w.scope.Set {
+		return w.scope.Prefix
+	}
+	if sub := edns.Subnet(res); sub != nil {
+		return sub.SourceScope
+	}
+	return 0
+}
+
 func (w *ResponseWriter) set(m *dns.Msg, key uint64, mt response.Type, duration time.Duration) {
 	// duration is expected > 0
 	// and key is valid
@@ -3,11 +3,13 @@ package cache
 import (
 	"context"
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
 	"github.com/coredns/coredns/plugin/pkg/response"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
@@ -258,6 +260,38 @@ func TestCacheZeroTTL(t *testing.T) {
 	}
 }
 
+func TestCacheRemove(t *testing.T) {
+	c := New()
+	c.Next = ttlBackend(60)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	if c.pcache.Len() != 1 {
+		t.Fatalf("Expected the response to be cached, got %d items", c.pcache.Len())
+	}
+
+	c.Remove("Example.ORG.", dns.TypeAAAA)
+	if c.pcache.Len() != 1 {
+		t.Errorf("Expected the response for another type to stay cached")
+	}
+	c.Remove("Example.ORG.", dns.TypeA)
+	if c.pcache.Len() != 0 {
+		t.Errorf("Expected the response to be removed, got %d items", c.pcache.Len())
+	}
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeMX} {
+		req.SetQuestion("example.org.", qtype)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	}
+	req.SetQuestion("example.net.", dns.TypeA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
+	c.Remove("Example.ORG.")
+	if c.pcache.Len() != 1 {
+		t.Errorf("Expected the responses for all types of the name to be removed, got %d items", c.pcache.Len())
+	}
+}
+
 func TestServeFromStaleCache(t *testing.T) {
 	c := New()
 	c.Next = ttlBackend(60)
@@ -464,6 +498,95 @@ func TestNegativeStaleMaskingPositiveCache(t *testing.T) {
 	}
 }
 
+func TestCacheECS(t *testing.T) {
+	c := New()
+	c.ecs = true
+	var queries int
+
+	tests := []struct {
+		remote  string
+		sub     string // client subnet in the query
+		scope   uint8  // scope reported by the backend
+		inReply bool   // report the scope in the reply instead of the context
+		queries int    // queries to the backend after this one
+	}{
+		{"10.240.0.1", "", 24, false, 1},
+		{"10.240.0.9", "", 24, false, 1},           // same /24, from the cache
+		{"10.241.0.1", "", 24, false, 2},           // other /24
+		{"10.242.0.1", "10.240.0.0", 24, false, 2}, // the client subnet of the query takes precedence
+		{"2001:db8::1", "", 24, true, 3},           // scope from the reply
+		{"2001:db8::2", "", 24, true, 3},           // same /56
+		{"2001:db8:1::1", "", 24, true, 4},         // other /56
+		{"10.243.0.1", "", 0, false, 5},            // scope 0, valid for all clients
+		{"10.244.0.1", "", 0, false, 5},            // from the cache
+	}
+	for i, tc := range tests {
+		scope := tc.scope
+		inReply := tc.inReply
+		c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+			queries++
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = []dns.RR{test.A(fmt.Sprintf("example.org. 300 IN A 127.0.0.%d", queries))}
+			if inReply {
+				m.SetEdns0(4096, false)
+				m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: 56, SourceScope: scope, Address: net.ParseIP("2001:db8::")})
+			} else if s := edns.SubnetScopeFromContext(ctx); s != nil {
+				s.Set, s.Prefix = true, scope
+			}
+			w.WriteMsg(m)
+			return dns.RcodeSuccess, nil
+		})
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		if tc.sub != "" {
+			req.SetEdns0(4096, false)
+			req.IsEdns0().Option = append(req.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP(tc.sub).To4()})
+		}
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{RemoteIP: tc.remote}, req)
+		if queries != tc.queries {
+			t.Errorf("Test %d: expected %d queries to the backend, got %d", i, tc.queries, queries)
+		}
+	}
+}
+
+func TestCacheRemoveECS(t *testing.T) {
+	c := New()
+	c.ecs = true
+	c.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A("example.org. 300 IN A 127.0.0.1")}
+		if s := edns.SubnetScopeFromContext(ctx); s != nil {
+			s.Set, s.Prefix = true, 24
+		}
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	for _, remote := range []string{"10.240.0.1", "10.241.0.1"} {
+		req := new(dns.Msg)
+		req.SetQuestion("example.org.", dns.TypeA)
+		c.ServeDNS(context.TODO(), &test.ResponseWriter{RemoteIP: remote}, req)
+	}
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeAAAA)
+	c.ServeDNS(context.TODO(), &test.ResponseWriter{RemoteIP: "10.240.0.1"}, req)
+	if c.pcache.Len() != 3 {
+		t.Fatalf("Expected a response per subnet and type to be cached, got %d items", c.pcache.Len())
+	}
+
+	c.Remove("Example.ORG.", dns.TypeA)
+	if c.pcache.Len() != 1 {
+		t.Errorf("Expected the responses for both subnets to be removed, got %d items", c.pcache.Len())
+	}
+	c.Remove("Example.ORG.")
+	if c.pcache.Len() != 0 {
+		t.Errorf("Expected the responses for all types to be removed, got %d items", c.pcache.Len())
+	}
+}
+
 func BenchmarkCacheResponse(b *testing.B) {
 	c := New()
 	c.prefetch = 1
@@ -188,6 +188,26 @@ func cacheParse(c *caddy.Controller) (*Cache, error) {
 					}
 					ca.verifyStale = mode == "verify"
 				}
+			case "ecs":
+				args := c.RemainingArgs()
+				if len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				ca.ecs = true
+				if len(args) > 0 {
+					n, err := strconv.ParseUint(args[0], 10, 8)
+					if err != nil || n > 32 {
+						return nil, fmt.Errorf("invalid IPv4 source prefix length: %s", args[0])
+					}
+					ca.ecsV4 = uint8(n)
+				}
+				if len(args) > 1 {
+					n, err := strconv.ParseUint(args[1], 10, 8)
+					if err != nil || n > 128 {
+						return nil, fmt.Errorf("invalid IPv6 source prefix length: %s", args[1])
+					}
+					ca.ecsV6 = uint8(n)
+				}
 			default:
 				return nil, c.ArgErr()
 			}
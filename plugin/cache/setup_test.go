@@ -155,3 +155,38 @@ func TestServeStale(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupECS(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		ecsV4     uint8
+		ecsV6     uint8
+	}{
+		{"ecs", false, 24, 56},
+		{"ecs 16", false, 16, 56},
+		{"ecs 32 64", false, 32, 64},
+		// fails
+		{"ecs 33", true, 0, 0},
+		{"ecs 24 129", true, 0, 0},
+		{"ecs aa", true, 0, 0},
+		{"ecs 24 56 1", true, 0, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", fmt.Sprintf("cache {\n%s\n}", test.input))
+		ca, err := cacheParse(c)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+			continue
+		} else if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found error: %v", i, err)
+			continue
+		}
+		if test.shouldErr && err != nil {
+			continue
+		}
+		if !ca.ecs || ca.ecsV4 != test.ecsV4 || ca.ecsV6 != test.ecsV6 {
+			t.Errorf("Test %v: Expected ecs with %d and %d but found: %t with %d and %d", i, test.ecsV4, test.ecsV6, ca.ecs, ca.ecsV4, ca.ecsV6)
+		}
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/coredns/coredns/request"
@@ -31,22 +33,33 @@ func ParseKeyFile(pubFile, privFile string) (*DNSKEY, error) {
 		return nil, e
 	}
 	defer f.Close()
-	k, e := dns.ReadRR(f, pubFile)
+
+	g, e := os.Open(filepath.Clean(privFile))
 	if e != nil {
 		return nil, e
 	}
+	defer g.Close()
+
+	return parseKey(f, pubFile, g, privFile)
+}
+
+// ParseKey reads a DNSSEC key from the contents of a public and a private keyfile as
+// generated by dnssec-keygen or other utilities.
+func ParseKey(pub, priv string) (*DNSKEY, error) {
+	return parseKey(strings.NewReader(pub), "", strings.NewReader(priv), "")
+}
 
-	f, e = os.Open(filepath.Clean(privFile))
+func parseKey(pub io.Reader, pubFile string, priv io.Reader, privFile string) (*DNSKEY, error) {
+	k, e := dns.ReadRR(pub, pubFile)
 	if e != nil {
 		return nil, e
 	}
-	defer f.Close()
 
 	dk, ok := k.(*dns.DNSKEY)
 	if !ok {
 		return nil, errors.New("no public key found")
 	}
-	p, e := dk.ReadPrivateKey(f, privFile)
+	p, e := dk.ReadPrivateKey(priv, privFile)
 	if e != nil {
 		return nil, e
 	}
@@ -93,3 +106,17 @@ func (k DNSKEY) isZSK() bool {
 func (k DNSKEY) isKSK() bool {
 	return k.K.Flags&(1<<8) == (1<<8) && k.K.Flags&1 == 1
 }
+
+// SplitKeys returns true if keys holds both KSKs and ZSKs, in which case the DNSKEY RRSet is
+// only signed with the KSKs and all other RRSets only with the ZSKs.
+func SplitKeys(keys []*DNSKEY) bool {
+	zsk, ksk := 0, 0
+	for _, k := range keys {
+		if k.isKSK() {
+			ksk++
+		} else if k.isZSK() {
+			zsk++
+		}
+	}
+	return zsk > 0 && ksk > 0
+}
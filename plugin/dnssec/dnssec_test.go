@@ -206,3 +206,23 @@ Publish: 20160423211746
 Activate: 20160423211746
 `
 )
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey(pubKey1, privKey1)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %v", err)
+	}
+	if key.K.Header().Name != "example.org." {
+		t.Errorf("Expected key for example.org., got %s", key.K.Header().Name)
+	}
+	if !key.isKSK() {
+		t.Errorf("Expected key to be a KSK")
+	}
+	if SplitKeys([]*DNSKEY{key}) {
+		t.Errorf("Expected a single KSK not to result in split keys")
+	}
+
+	if _, err := ParseKey(pubKey1, "Private-key-format: v1.3\n"); err == nil {
+		t.Errorf("Expected error for missing private key")
+	}
+}
@@ -83,15 +83,7 @@ func dnssecParse(c *caddy.Controller) ([]string, []*DNSKEY, int, bool, error) {
 		}
 	}
 	// Check if we have both KSKs and ZSKs.
-	zsk, ksk := 0, 0
-	for _, k := range keys {
-		if k.isKSK() {
-			ksk++
-		} else if k.isZSK() {
-			zsk++
-		}
-	}
-	splitkeys := zsk > 0 && ksk > 0
+	splitkeys := SplitKeys(keys)
 
 	// Check if each keys owner name can actually sign the zones we want them to sign.
 	for _, k := range keys {
@@ -0,0 +1,127 @@
+package dnstap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/dnstap/msg"
+	"github.com/coredns/coredns/plugin/pkg/event"
+
+	tap "github.com/dnstap/golang-dnstap"
+)
+
+func init() { event.RegisterSink("dnstap", newSink) }
+
+// sink is the event.Sink that writes the query and the response of every event to a dnstap
+// endpoint, as CLIENT_QUERY and CLIENT_RESPONSE messages. It connects when the first batch is
+// written, and reconnects after an error.
+type sink struct {
+	proto    string
+	endpoint string
+	full     bool // Include the raw messages.
+	enc      *encoder
+}
+
+// newSink returns the sink for the arguments "ENDPOINT [full]", the endpoint is like the
+// endpoint of the dnstap plugin.
+func newSink(args []string, _ event.Encoding) (event.Sink, string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, "", fmt.Errorf("dnstap sink needs an endpoint")
+	}
+	s := &sink{proto: "unix", endpoint: strings.TrimPrefix(args[0], "unix://")}
+	if strings.HasPrefix(args[0], "tcp://") {
+		u, err := url.Parse(args[0])
+		if err != nil {
+			return nil, "", err
+		}
+		s.proto, s.endpoint = "tcp", u.Host
+	}
+	if len(args) == 2 {
+		if args[1] != "full" {
+			return nil, "", fmt.Errorf("unknown dnstap sink option '%s'", args[1])
+		}
+		s.full = true
+	}
+	return s, args[0], nil
+}
+
+// Fields implements event.Fielder, the dnstap messages need the messages and addresses.
+func (s *sink) Fields() event.Fields { return event.Messages }
+
+// Export implements event.Sink.
+func (s *sink) Export(_ context.Context, events []*event.Event) error {
+	if s.enc == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		for _, m := range s.messages(e) {
+			t := tap.Dnstap_MESSAGE
+			if err := s.enc.writeMsg(&tap.Dnstap{Type: &t, Message: m}); err != nil {
+				s.Close()
+				return err
+			}
+		}
+	}
+	if err := s.enc.flush(); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// messages returns the dnstap messages of e.
+func (s *sink) messages(e *event.Event) []*tap.Message {
+	q := new(tap.Message)
+	msg.SetType(q, tap.Message_CLIENT_QUERY)
+	msg.SetQueryTime(q, e.Time)
+	msg.SetQueryAddress(q, e.RemoteAddr)
+	if s.full && e.Query != nil {
+		q.QueryMessage, _ = e.Query.Pack()
+	}
+	if e.Response == nil {
+		return []*tap.Message{q}
+	}
+
+	r := new(tap.Message)
+	msg.SetType(r, tap.Message_CLIENT_RESPONSE)
+	msg.SetQueryTime(r, e.Time)
+	msg.SetResponseTime(r, e.Time.Add(time.Duration(e.DurationUS)*time.Microsecond))
+	msg.SetQueryAddress(r, e.RemoteAddr)
+	if s.full {
+		r.ResponseMessage, _ = e.Response.Pack()
+	}
+	return []*tap.Message{q, r}
+}
+
+func (s *sink) dial() error {
+	conn, err := net.DialTimeout(s.proto, s.endpoint, tcpTimeout)
+	if err != nil {
+		return err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetWriteBuffer(tcpWriteBufSize)
+		tcpConn.SetNoDelay(false)
+	}
+	if s.enc, err = newEncoder(conn, tcpTimeout); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Close implements io.Closer.
+func (s *sink) Close() error {
+	if s.enc == nil {
+		return nil
+	}
+	s.enc.flush()
+	err := s.enc.close()
+	s.enc = nil
+	return err
+}
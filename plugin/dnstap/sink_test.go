@@ -0,0 +1,97 @@
+package dnstap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+	"github.com/coredns/coredns/plugin/pkg/reuseport"
+
+	tap "github.com/dnstap/golang-dnstap"
+	fs "github.com/farsightsec/golang-framestream"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSink(t *testing.T) {
+	l, err := reuseport.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Cannot start listener: %s", err)
+	}
+	defer l.Close()
+
+	types := make(chan tap.Message_Type, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec, err := fs.NewDecoder(conn, &fs.DecoderOptions{ContentType: []byte("protobuf:dnstap.Dnstap"), Bidirectional: true})
+		if err != nil {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			buf, err := dec.Decode()
+			if err != nil {
+				return
+			}
+			d := new(tap.Dnstap)
+			if proto.Unmarshal(buf, d) == nil {
+				types <- d.Message.GetType()
+			}
+		}
+	}()
+
+	s, _, err := newSink([]string{"tcp://" + l.Addr().String(), "full"}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.org.", dns.TypeA)
+	r := new(dns.Msg)
+	r.SetReply(q)
+	e := &event.Event{Time: time.Now(), Query: q, Response: r, RemoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}}
+	if err := s.Export(context.TODO(), []*event.Event{e}); err != nil {
+		t.Fatal(err)
+	}
+	s.(*sink).Close()
+
+	for _, expected := range []tap.Message_Type{tap.Message_CLIENT_QUERY, tap.Message_CLIENT_RESPONSE} {
+		select {
+		case got := <-types:
+			if got != expected {
+				t.Errorf("Expected a %s message, got %s", expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a %s message", expected)
+		}
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		args      []string
+		shouldErr bool
+		proto     string
+		endpoint  string
+	}{
+		{[]string{"/tmp/dnstap.sock"}, false, "unix", "/tmp/dnstap.sock"},
+		{[]string{"unix:///tmp/dnstap.sock", "full"}, false, "unix", "/tmp/dnstap.sock"},
+		{[]string{"tcp://127.0.0.1:6000"}, false, "tcp", "127.0.0.1:6000"},
+		{nil, true, "", ""},
+		{[]string{"tcp://127.0.0.1:6000", "bogus"}, true, "", ""},
+	}
+	for i, tc := range tests {
+		s, _, err := newSink(tc.args, event.JSON)
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if err == nil && (s.(*sink).proto != tc.proto || s.(*sink).endpoint != tc.endpoint) {
+			t.Errorf("Test %d: expected %s %s, got %s %s", i, tc.proto, tc.endpoint, s.(*sink).proto, s.(*sink).endpoint)
+		}
+	}
+}
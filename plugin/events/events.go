@@ -0,0 +1,10 @@
+// Package events implements a plugin that exports an event for every query to one or more sinks,
+// like a file, a syslog server or a NATS server. The events are captured and queued by the event
+// package, every sink registered with event.RegisterSink can be used.
+package events
+
+import (
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+)
+
+var log = clog.NewWithPlugin("events")
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func init() { event.RegisterSink("file", newFileSink) }
+
+// fileSink is the event.Sink that appends the events to a file, as JSON lines or as a stream of
+// protobuf Batch messages. The file is opened when the first batch is written.
+type fileSink struct {
+	path string
+	enc  event.Encoding
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// newFileSink returns the sink for the argument "PATH".
+func newFileSink(args []string, enc event.Encoding) (event.Sink, string, error) {
+	if len(args) != 1 {
+		return nil, "", fmt.Errorf("file sink needs a path")
+	}
+	return &fileSink{path: args[0], enc: enc}, args[0], nil
+}
+
+// Export implements event.Sink.
+func (s *fileSink) Export(_ context.Context, events []*event.Event) error {
+	if s.f == nil {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		s.f, s.w = f, bufio.NewWriter(f)
+	}
+	for _, e := range events {
+		b, err := s.enc.Encode(e)
+		if err != nil {
+			log.Errorf("Failed to encode event: %s", err)
+			continue
+		}
+		s.w.Write(b)
+		s.w.Write(s.enc.Separator())
+	}
+	return s.w.Flush()
+}
+
+// Close closes the file.
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f, s.w = nil, nil
+	return err
+}
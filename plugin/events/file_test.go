@@ -0,0 +1,37 @@
+package events
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	s, _, err := newFileSink([]string{path}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Export(context.TODO(), []*event.Event{{QName: "a."}, {QName: "b."}}); err != nil {
+		t.Fatal(err)
+	}
+	s.(*fileSink).Close()
+	// Exporting again appends to the file.
+	if err := s.Export(context.TODO(), []*event.Event{{QName: "c."}}); err != nil {
+		t.Fatal(err)
+	}
+	s.(*fileSink).Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"qname":"c."`) {
+		t.Errorf("Expected 3 JSON lines, got %q", b)
+	}
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func init() { event.RegisterSink("nats", newNATSSink) }
+
+const natsTimeout = 5 * time.Second
+
+// natsSink is the event.Sink that publishes every event as a message to a subject on a NATS
+// server. It speaks the NATS client protocol: after a batch is published it waits for a PONG to
+// its PING, so the server has processed the batch when Export returns. It connects when the first
+// batch is published, and reconnects after an error.
+type natsSink struct {
+	url     *url.URL
+	subject string
+	enc     event.Encoding
+
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// newNATSSink returns the sink for the arguments "URL SUBJECT". URL is like
+// nats://[USER:PASSWORD@]HOST[:PORT], or tls:// to connect with TLS. A user without a password is
+// used as the token.
+func newNATSSink(args []string, enc event.Encoding) (event.Sink, string, error) {
+	if len(args) != 2 {
+		return nil, "", fmt.Errorf("nats sink needs a url and a subject")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, "", fmt.Errorf("nats sink url must be nats:// or tls://, found %s", args[0])
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if args[1] == "" || strings.ContainsAny(args[1], " \t\r\n") {
+		return nil, "", fmt.Errorf("nats subject provided is invalid: %q", args[1])
+	}
+	// Don't log the credentials.
+	dest := url.URL{Scheme: u.Scheme, Host: u.Host}
+	return &natsSink{url: u, subject: args[1], enc: enc}, dest.String(), nil
+}
+
+// Export implements event.Sink.
+func (s *natsSink) Export(_ context.Context, events []*event.Event) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(natsTimeout))
+	for _, e := range events {
+		b, err := s.enc.Encode(e)
+		if err != nil {
+			log.Errorf("Failed to encode event: %s", err)
+			continue
+		}
+		fmt.Fprintf(s.w, "PUB %s %d\r\n", s.subject, len(b))
+		s.w.Write(b)
+		s.w.WriteString("\r\n")
+	}
+	if err := s.ping(); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+// connect connects to the server, and sends the CONNECT message.
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.url.Host, natsTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.conn.SetDeadline(time.Now().Add(natsTimeout))
+	s.r, s.w = bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	// The server greets with INFO, the upgrade to TLS follows it.
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting: %q", strings.TrimSpace(line))
+	}
+	if s.url.Scheme == "tls" {
+		tc := tls.Client(conn, &tls.Config{ServerName: s.url.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		s.conn = tc
+		s.r, s.w = bufio.NewReader(tc), bufio.NewWriter(tc)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     caddy.AppName,
+		"lang":     "go",
+		"version":  caddy.AppVersion,
+		"protocol": 1,
+	}
+	if u := s.url.User; u != nil {
+		if p, ok := u.Password(); ok {
+			opts["user"], opts["pass"] = u.Username(), p
+		} else {
+			opts["auth_token"] = u.Username()
+		}
+	}
+	b, _ := json.Marshal(opts)
+	fmt.Fprintf(s.w, "CONNECT %s\r\n", b)
+	return s.ping()
+}
+
+// ping sends what is buffered followed by a PING, and waits for the PONG.
+func (s *natsSink) ping() error {
+	s.w.WriteString("PING\r\n")
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			s.w.WriteString("PONG\r\n")
+			if err := s.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// INFO and +OK need no reply.
+	}
+}
+
+// Close closes the connection.
+func (s *natsSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r, s.w = nil, nil, nil
+	return err
+}
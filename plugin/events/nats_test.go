@@ -0,0 +1,132 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+// natsServer accepts a single client, and sends the payloads it publishes to msgs. It replies to
+// CONNECT with err, when err isn't empty.
+func natsServer(l net.Listener, err string, msgs chan<- string) {
+	conn, e := l.Accept()
+	if e != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+	r := bufio.NewReader(conn)
+	for {
+		line, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			if err != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", err)
+				return
+			}
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			var (
+				subject string
+				n       int
+			)
+			fmt.Sscanf(line, "PUB %s %d", &subject, &n)
+			payload := make([]byte, n+2)
+			if _, e := io.ReadFull(r, payload); e != nil {
+				return
+			}
+			msgs <- subject + " " + string(payload[:n])
+		}
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	msgs := make(chan string, 2)
+	go natsServer(l, "", msgs)
+
+	s, dest, err := newNATSSink([]string{"nats://user:secret@" + l.Addr().String(), "dns.events"}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dest, "secret") {
+		t.Errorf("Expected the destination without credentials, got %s", dest)
+	}
+	defer s.(*natsSink).Close()
+	if err := s.Export(context.TODO(), []*event.Event{{QName: "a."}, {QName: "b."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server has processed both messages when Export returns.
+	for _, name := range []string{"a.", "b."} {
+		select {
+		case msg := <-msgs:
+			if !strings.HasPrefix(msg, "dns.events {") || !strings.Contains(msg, `"qname":"`+name+`"`) {
+				t.Errorf("Expected the event for %s, got %q", name, msg)
+			}
+		default:
+			t.Fatalf("Expected the event for %s", name)
+		}
+	}
+}
+
+func TestNATSSinkError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go natsServer(l, "Authorization Violation", nil)
+
+	s, _, err := newNATSSink([]string{"nats://" + l.Addr().String(), "dns.events"}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Export(context.TODO(), []*event.Event{{QName: "a."}})
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected an authorization error, got %v", err)
+	}
+	if s.(*natsSink).conn != nil {
+		t.Error("Expected the connection to be closed after an error")
+	}
+}
+
+func TestNewNATSSink(t *testing.T) {
+	tests := []struct {
+		args         []string
+		shouldErr    bool
+		expectedDest string
+	}{
+		{[]string{"nats://localhost", "dns"}, false, "nats://localhost:4222"},
+		{[]string{"tls://token@nats.example.org:4443", "dns.>"}, false, "tls://nats.example.org:4443"},
+		{[]string{"nats://localhost"}, true, ""},
+		{[]string{"http://localhost", "dns"}, true, ""},
+		{[]string{"nats://localhost", "dns events"}, true, ""},
+	}
+	for i, tc := range tests {
+		_, dest, err := newNATSSink(tc.args, event.JSON)
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if dest != tc.expectedDest {
+			t.Errorf("Test %d: expected destination %s, got %s", i, tc.expectedDest, dest)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func init() { plugin.Register("events", setup) }
+
+const (
+	defaultQueueSize     = 10000
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+// config holds an events directive parsed from the Corefile.
+type config struct {
+	sink        event.Sink
+	name        string // Name of the sink.
+	destination string
+	exporter    event.Config
+}
+
+func setup(c *caddy.Controller) error {
+	cfgs, err := eventsParse(c)
+	if err != nil {
+		return plugin.Error("events", err)
+	}
+
+	hostname, _ := os.Hostname()
+	h := event.Handler{Plugin: "events", Hostname: hostname}
+	for _, cfg := range cfgs {
+		x := event.NewExporter(cfg.name, cfg.destination, cfg.sink, cfg.exporter)
+		h.Exporters = append(h.Exporters, x)
+		c.OnStartup(func() error {
+			x.Start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			x.Stop()
+			return nil
+		})
+	}
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		h.Next = next
+		return h
+	})
+
+	return nil
+}
+
+func eventsParse(c *caddy.Controller) ([]*config, error) {
+	var cfgs []*config
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return nil, c.ArgErr()
+		}
+		f, ok := event.LookupSink(args[0])
+		if !ok {
+			return nil, c.Errf("unknown sink '%s', expected one of: %s", args[0], strings.Join(event.SinkNames(), ", "))
+		}
+
+		cfg := &config{
+			name: args[0],
+			exporter: event.Config{
+				QueueSize:     defaultQueueSize,
+				BatchSize:     defaultBatchSize,
+				FlushInterval: defaultFlushInterval,
+			},
+		}
+		var enc event.Encoding
+		for c.NextBlock() {
+			if ok, err := cfg.exporter.Parse(c); ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switch c.Val() {
+			case "fields":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, a := range args {
+					f, err := event.FieldsFromString(a)
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					cfg.exporter.Fields |= f
+				}
+			case "encoding":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				e, err := event.EncodingFromString(args[0])
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				enc = e
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+
+		// The sink is created after the block, it needs the encoding.
+		s, dest, err := f(args[1:], enc)
+		if err != nil {
+			return nil, c.Err(err.Error())
+		}
+		cfg.sink, cfg.destination = s, dest
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
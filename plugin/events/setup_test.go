@@ -0,0 +1,92 @@
+package events
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func TestEventsParse(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedErr   string
+		expectedSinks []string
+		expectedBatch int
+	}{
+		{`events file /tmp/events.json`, false, "", []string{"file"}, defaultBatchSize},
+		{`events file /tmp/events.pb {
+			encoding protobuf
+			fields edns answers
+			rule example.org type A AAAA sample 0.5
+			batch 10
+		}`, false, "", []string{"file"}, 10},
+		{`events file /tmp/events.json
+		events syslog udp://localhost`, false, "", []string{"file", "syslog"}, defaultBatchSize},
+		{`events nats nats://localhost dns.events`, false, "", []string{"nats"}, defaultBatchSize},
+		// fails
+		{`events`, true, "Wrong argument count", nil, 0},
+		{`events bogus`, true, "unknown sink 'bogus'", nil, 0},
+		{`events file`, true, "file sink needs a path", nil, 0},
+		{`events syslog udp://localhost {
+			encoding protobuf
+		}`, true, "only supports the json encoding", nil, 0},
+		{`events file /tmp/events.json {
+			encoding xml
+		}`, true, "unknown encoding", nil, 0},
+		{`events file /tmp/events.json {
+			fields bogus
+		}`, true, "unknown field", nil, 0},
+		{`events file /tmp/events.json {
+			queue -1
+		}`, true, "queue size provided is invalid", nil, 0},
+		{`events file /tmp/events.json {
+			bogus
+		}`, true, "unknown property", nil, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfgs, err := eventsParse(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if len(cfgs) != len(test.expectedSinks) {
+			t.Errorf("Test %d: expected %d sinks, got %d", i, len(test.expectedSinks), len(cfgs))
+			continue
+		}
+		for j, cfg := range cfgs {
+			if cfg.name != test.expectedSinks[j] {
+				t.Errorf("Test %d: expected sink %s, got %s", i, test.expectedSinks[j], cfg.name)
+			}
+			if cfg.exporter.BatchSize != test.expectedBatch {
+				t.Errorf("Test %d: expected batch size %d, got %d", i, test.expectedBatch, cfg.exporter.BatchSize)
+			}
+		}
+	}
+}
+
+func TestEventsParseEncoding(t *testing.T) {
+	c := caddy.NewTestController("dns", `events file /tmp/events.pb {
+		encoding protobuf
+	}`)
+	cfgs, err := eventsParse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := cfgs[0].sink.(*fileSink).enc; enc != event.Protobuf {
+		t.Errorf("Expected the sink to encode protobuf, got %s", enc)
+	}
+}
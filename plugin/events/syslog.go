@@ -0,0 +1,115 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func init() { event.RegisterSink("syslog", newSyslogSink) }
+
+const (
+	defaultSyslogTag = "coredns"
+	syslogPriority   = 3*8 + 6 // Facility daemon, severity info.
+	syslogTimeout    = 5 * time.Second
+)
+
+// syslogSink is the event.Sink that sends every event as a JSON encoded RFC 5424 syslog message,
+// over UDP, TCP or a unix datagram socket. Over TCP the messages are framed with octet counting, as
+// in RFC 6587. It connects when the first batch is sent, and reconnects after an error.
+type syslogSink struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	conn     net.Conn
+}
+
+// newSyslogSink returns the sink for the arguments "ADDRESS [TAG]". ADDRESS is like
+// udp://HOST[:PORT], tcp://HOST[:PORT] or unix:///dev/log.
+func newSyslogSink(args []string, enc event.Encoding) (event.Sink, string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, "", fmt.Errorf("syslog sink needs an address")
+	}
+	if enc != event.JSON {
+		return nil, "", fmt.Errorf("syslog sink only supports the json encoding")
+	}
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	s := &syslogSink{network: u.Scheme, address: u.Host, tag: defaultSyslogTag}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Port() == "" {
+			s.address = net.JoinHostPort(u.Host, "514")
+		}
+	case "unix":
+		s.network, s.address = "unixgram", u.Path
+	default:
+		return nil, "", fmt.Errorf("syslog sink address must be udp://, tcp:// or unix://, found %s", args[0])
+	}
+	if len(args) == 2 {
+		s.tag = args[1]
+	}
+	s.hostname, _ = os.Hostname()
+	return s, args[0], nil
+}
+
+// Export implements event.Sink.
+func (s *syslogSink) Export(_ context.Context, events []*event.Event) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	for _, e := range events {
+		b, err := event.JSON.Encode(e)
+		if err != nil {
+			log.Errorf("Failed to encode event: %s", err)
+			continue
+		}
+		if _, err := s.conn.Write(s.message(e.Time, b)); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// message returns the syslog message for an event encoded as msg.
+func (s *syslogSink) message(t time.Time, msg []byte) []byte {
+	b := []byte(fmt.Sprintf("<%d>1 %s %s %s %d - - ", syslogPriority, t.UTC().Format(time.RFC3339Nano), nilValue(s.hostname), s.tag, os.Getpid()))
+	b = append(b, msg...)
+	if s.network == "tcp" {
+		b = append([]byte(strconv.Itoa(len(b))+" "), b...)
+	}
+	return b
+}
+
+// Close closes the connection.
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// nilValue returns s, or the syslog NILVALUE when s is empty.
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func TestSyslogSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, _, err := newSyslogSink([]string{"udp://" + pc.LocalAddr().String(), "dns"}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*syslogSink).Close()
+	if err := s.Export(context.TODO(), []*event.Event{{QName: "a."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<30>1 ") || !strings.Contains(msg, " dns ") || !strings.HasSuffix(msg, `"qname":"a.","qtype":"","rcode":"","duration_us":0,"ns_hostname":""}`) {
+		t.Errorf("Unexpected syslog message %q", msg)
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('<')
+		lines <- line
+	}()
+
+	s, _, err := newSyslogSink([]string{"tcp://" + l.Addr().String()}, event.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*syslogSink).Close()
+	if err := s.Export(context.TODO(), []*event.Event{{QName: "a."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message is framed with its length.
+	select {
+	case line := <-lines:
+		if line == "" || line[0] < '1' || line[0] > '9' || !strings.HasSuffix(line, " <") {
+			t.Errorf("Expected an octet counted message, got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a syslog message")
+	}
+}
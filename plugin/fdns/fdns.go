@@ -2,180 +2,95 @@ package fdns
 
 import (
 	"context"
-	"log"
-	"net"
-	"strconv"
-	"strings"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
-	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/joeguo/tldextract"
 	"github.com/miekg/dns"
 )
 
 const Name = "fdns"
-const GET_RECORDS_SQL = "SELECT content,type,ttl FROM records WHERE name = $1 AND (type = $2 OR type = 'CNAME')"
-const GET_ANYTYPE_SQL = "SELECT count(*) FROM records WHERE name = $1"
-const GET_SOA_SQL = "SELECT content,type,ttl FROM records WHERE name = $1 AND type = 'SOA'"
-const GET_ZONE_SQL = "SELECT count(id) FROM zones WHERE id = $1"
+
+var log = clog.NewWithPlugin(Name)
 
 type FDNSBackend struct {
-	Pool       *pgxpool.Pool
-	TldExtract *tldextract.TLDExtract
-	Debug      bool
-	Next       plugin.Handler
+	Zones *Zones
+	Keys  map[string]Key // TSIG keys allowed to send dynamic updates, by name.
+	Fall  fall.F
+	Debug bool // Log every query at the info level, instead of only when the debug plugin is enabled.
+	Next  plugin.Handler
 }
 
 func (b FDNSBackend) Name() string { return Name }
 
 func (b FDNSBackend) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if r.Opcode == dns.OpcodeUpdate {
+		return b.serveUpdate(ctx, w, r)
+	}
+
 	state := request.Request{W: w, Req: r}
+	server := metrics.WithServer(ctx)
 	a := new(dns.Msg)
 	a.SetReply(r)
 	a.Compress = true
 	a.Authoritative = true
 
-	// Extract the zone and ensure we're authoritative for it
-	extracted := b.TldExtract.Extract(strings.ToLower(strings.TrimRight(state.QName(), ".")))
-	zone := extracted.Root + "." + extracted.Tld + "."
-	var count int
-	b.Pool.QueryRow(context.Background(), GET_ZONE_SQL, zone).Scan(&count)
-	if count == 0 {
-		log.Println("[fdns] not authoritative for", zone)
+	// Find the most specific zone qname belongs to and ensure we're authoritative for it
+	zone := plugin.Zones(b.Zones.Names()).Matches(state.Name())
+	z := b.Zones.Zones(zone)
+	requestCount.WithLabelValues(server, zone).Inc()
+	if z == nil {
+		if b.Fall.Through(state.Name()) {
+			return plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
+		}
+		debugf(b.Debug, "Not authoritative for %s", state.Name())
 		a.Rcode = dns.RcodeRefused
+		responseCount.WithLabelValues(server, zone, dns.RcodeToString[a.Rcode]).Inc()
 		return dns.RcodeRefused, w.WriteMsg(a)
 	}
 
-	qName := state.QName()
-	if !strings.HasSuffix(qName, ".") {
-		qName += "."
-	}
-
-	rows, err := b.Pool.Query(context.Background(), GET_RECORDS_SQL, strings.ToLower(qName), dns.TypeToString[state.QType()])
-	if err != nil {
-		log.Print("[fdns]", err)
-		a.Rcode = dns.RcodeServerFailure
-		return dns.RcodeServerFailure, w.WriteMsg(a)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		row, err := rows.Values()
-		if err != nil {
-			log.Print("[fdns]", err)
-			continue
-		}
-		aType := dns.StringToType[row[1].(string)]
-
-		hdr := dns.RR_Header{Name: qName, Rrtype: aType, Class: state.QClass(), Ttl: uint32(row[2].(int32))}
-
-		log.Println("[fdns]", hdr.Name, dns.TypeToString[aType], row[0].(string))
-		var rr dns.RR
-		switch aType {
-		case dns.TypeA:
-			rr = &dns.A{Hdr: hdr, A: net.ParseIP(row[0].(string))}
-		case dns.TypeAAAA:
-			rr = &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(row[0].(string))}
-		case dns.TypeCNAME:
-			rr = &dns.CNAME{Hdr: hdr, Target: row[0].(string)}
-		case dns.TypeNS:
-			rr = &dns.NS{Hdr: hdr, Ns: row[0].(string)}
-		case dns.TypeTXT:
-			rr = &dns.TXT{Hdr: hdr, Txt: strings.Split(row[0].(string), " ")}
-		case dns.TypeSOA:
-			rr = &dns.SOA{Hdr: hdr}
-			if !parseSOA(rr.(*dns.SOA), row[0].(string)) {
-				rr = nil
-			}
-		}
-
-		if rr != nil {
-			a.Answer = append(a.Answer, rr)
+	answer, ns, extra, result := z.Lookup(ctx, state, state.Name())
+	a.Answer, a.Ns, a.Extra = answer, ns, extra
+
+	switch result {
+	case Success:
+	case Delegation:
+		a.Authoritative = false
+	case ServerFailure:
+		// A CNAME chain that could not be completed is still worth returning.
+		if len(a.Answer) == 0 {
+			responseCount.WithLabelValues(server, zone, dns.RcodeToString[dns.RcodeServerFailure]).Inc()
+			return dns.RcodeServerFailure, nil
 		}
-	}
-	if rows.Err() != nil {
-		log.Print("[fdns]", rows.Err())
 		a.Rcode = dns.RcodeServerFailure
-		return dns.RcodeServerFailure, w.WriteMsg(a)
-	}
-
-	if len(a.Answer) == 0 {
-		var count int
-		b.Pool.QueryRow(context.Background(), GET_ANYTYPE_SQL, state.QName()).Scan(&count)
-		if count == 0 {
-			a.Rcode = dns.RcodeNameError
+	case NoData:
+		// The name exists, so it's ours to answer, even when falling through.
+	case NameError:
+		// Only a name that doesn't exist at all falls through. A CNAME pointing to a name that
+		// doesn't exist is answered here, as the CNAME itself does exist.
+		if len(a.Answer) == 0 && b.Fall.Through(state.Name()) {
+			return plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
 		}
+		a.Rcode = dns.RcodeNameError
+	}
 
-		code, err := plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
-		if err != nil && err.Error() == "plugin/fdns: no next plugin found" {
-			rows, err = b.Pool.Query(context.Background(), GET_SOA_SQL, zone)
-			if err != nil {
-				log.Print("[fdns]", err)
-				return dns.RcodeServerFailure, err
-			}
-			defer rows.Close()
-			for rows.Next() {
-				row, err := rows.Values()
-				if err != nil {
-					log.Print("[fdns]", err)
-					return dns.RcodeServerFailure, err
-				}
-
-				hdr := dns.RR_Header{Name: qName, Rrtype: dns.TypeSOA, Class: state.QClass(), Ttl: uint32(row[2].(int32))}
-
-				var rr dns.RR
-				rr = &dns.SOA{Hdr: hdr}
-				if !parseSOA(rr.(*dns.SOA), row[0].(string)) {
-					rr = nil
-				}
-
-				if rr != nil {
-					a.Ns = append(a.Ns, rr)
-				} else {
-					log.Print("[fdns]", "failed to parse SOA")
-					return dns.RcodeServerFailure, nil
-				}
-			}
-		} else {
-			return code, err
-		}
+	if z.Signed() && state.Do() && result != Delegation {
+		a = z.Sign(a, server)
 	}
+	debugf(b.Debug, "%s %s %s: %s with %d answers", zone, state.Name(), state.Type(), dns.RcodeToString[a.Rcode], len(a.Answer))
+	responseCount.WithLabelValues(server, zone, dns.RcodeToString[a.Rcode]).Inc()
 
 	return dns.RcodeSuccess, w.WriteMsg(a)
 }
 
-func parseSOA(rr *dns.SOA, line string) bool {
-	splites := strings.Split(line, " ")
-	if len(splites) < 7 {
-		return false
-	}
-	rr.Ns = splites[0]
-	rr.Mbox = splites[1]
-	if i, err := strconv.Atoi(splites[2]); err != nil {
-		return false
-	} else {
-		rr.Serial = uint32(i)
-	}
-	if i, err := strconv.Atoi(splites[3]); err != nil {
-		return false
-	} else {
-		rr.Refresh = uint32(i)
-	}
-	if i, err := strconv.Atoi(splites[4]); err != nil {
-		return false
-	} else {
-		rr.Retry = uint32(i)
-	}
-	if i, err := strconv.Atoi(splites[5]); err != nil {
-		return false
-	} else {
-		rr.Expire = uint32(i)
-	}
-	if i, err := strconv.Atoi(splites[6]); err != nil {
-		return false
-	} else {
-		rr.Minttl = uint32(i)
+// debugf logs a query, update or reload. When debug is true it is logged at the info level, so it
+// shows up without the debug plugin, otherwise at the debug level.
+func debugf(debug bool, format string, v ...interface{}) {
+	if debug {
+		log.Infof(format, v...)
+		return
 	}
-	return true
+	log.Debugf(format, v...)
 }
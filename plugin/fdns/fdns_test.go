@@ -0,0 +1,122 @@
+package fdns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSZoneSelection(t *testing.T) {
+	parent := newTestZone(t)
+	child := parseTestZone(t, "sub.example.org.", `
+sub.example.org.	300	IN	SOA	ns.sub.example.org. hostmaster.sub.example.org. 1 7200 3600 1209600 60
+sub.example.org.	300	IN	NS	ns.sub.example.org.
+www.sub.example.org.	300	IN	A	192.0.2.10
+`)
+	reverse := parseTestZone(t, "2.0.192.in-addr.arpa.", `
+2.0.192.in-addr.arpa.	300	IN	SOA	ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 60
+1.2.0.192.in-addr.arpa.	300	IN	PTR	ns1.example.org.
+`)
+
+	zones := &Zones{}
+	zones.Add(parent)
+	zones.Add(child)
+	zones.Add(reverse)
+	b := FDNSBackend{Zones: zones, Next: test.ErrorHandler()}
+
+	tests := []struct {
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer string
+	}{
+		{"www.sub.example.org.", dns.TypeA, dns.RcodeSuccess, "192.0.2.10"},
+		{"web.example.org.", dns.TypeA, dns.RcodeSuccess, "192.0.2.3"},
+		{"1.2.0.192.in-addr.arpa.", dns.TypePTR, dns.RcodeSuccess, "ns1.example.org."},
+		{"www.example.net.", dns.TypeA, dns.RcodeRefused, ""},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		b.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+			continue
+		}
+		if tc.answer == "" {
+			continue
+		}
+		if len(rec.Msg.Answer) != 1 {
+			t.Errorf("Test %d: expected 1 answer, got %d", i, len(rec.Msg.Answer))
+			continue
+		}
+		var got string
+		switch x := rec.Msg.Answer[0].(type) {
+		case *dns.A:
+			got = x.A.String()
+		case *dns.PTR:
+			got = x.Ptr
+		}
+		if got != tc.answer {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.answer, got)
+		}
+	}
+}
+
+func TestServeDNSNegative(t *testing.T) {
+	zones := &Zones{}
+	zones.Add(newTestZone(t))
+	// The next plugin answers with an rcode no negative answer of ours has.
+	b := FDNSBackend{Zones: zones, Fall: fall.Root, Next: test.NextHandler(dns.RcodeNotImplemented, nil)}
+
+	soa := test.SOA("example.org.	60	IN	SOA	ns1.example.org. hostmaster.example.org. 2022060100 7200 3600 1209600 60")
+	tests := []struct {
+		tc   test.Case
+		next bool
+	}{
+		{test.Case{Qname: "example.org.", Qtype: dns.TypeTXT, Ns: []dns.RR{soa}}, false},
+		{test.Case{Qname: "ent.example.org.", Qtype: dns.TypeA, Ns: []dns.RR{soa}}, false},
+		{test.Case{Qname: "host.wild.example.org.", Qtype: dns.TypeAAAA, Ns: []dns.RR{soa}}, false},
+		{test.Case{Qname: "dangling.example.org.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError,
+			Answer: []dns.RR{test.CNAME("dangling.example.org.	300	IN	CNAME	gone.example.org.")}, Ns: []dns.RR{soa}}, false},
+		{test.Case{Qname: "nope.example.org.", Qtype: dns.TypeA}, true},
+	}
+
+	for i, tc := range tests {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, _ := b.ServeDNS(context.TODO(), rec, tc.tc.Msg())
+		if tc.next {
+			if rcode != dns.RcodeNotImplemented {
+				t.Errorf("Test %d: expected to fall through, got rcode %d", i, rcode)
+			}
+			continue
+		}
+		if rec.Msg == nil {
+			t.Errorf("Test %d: expected an answer, got none", i)
+			continue
+		}
+		if !rec.Msg.Authoritative {
+			t.Errorf("Test %d: expected AA to be set", i)
+		}
+		if err := test.SortAndCheck(rec.Msg, tc.tc); err != nil {
+			t.Errorf("Test %d: %s", i, err)
+		}
+	}
+
+	// Without fallthrough a name that doesn't exist gets an NXDOMAIN with the SOA.
+	b.Fall = fall.F{}
+	tc := test.Case{Qname: "nope.example.org.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError, Ns: []dns.RR{soa}}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	b.ServeDNS(context.TODO(), rec, tc.Msg())
+	if err := test.SortAndCheck(rec.Msg, tc); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,59 @@
+package fdns
+
+import (
+	"context"
+	"time"
+)
+
+// Channel is the PostgreSQL notification channel fdns listens on. The payload of a notification
+// is the owner name of the changed record or the origin of the changed zone, see schema.sql.
+const Channel = "fdns"
+
+// listenRetry is the time to wait before reconnecting after the listening connection failed.
+var listenRetry = 5 * time.Second
+
+// Listen waits for change notifications on Channel and refreshes the affected zones, until ctx
+// is canceled. When the connection is lost all zones are reloaded after reconnecting, because
+// notifications sent in the meantime are lost.
+func (z *Zones) Listen(ctx context.Context) {
+	for {
+		err := z.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		errorCount.WithLabelValues("", opListen).Inc()
+		log.Errorf("Listening for changes failed: %s", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetry):
+		}
+
+		if err := z.Load(ctx); err != nil {
+			log.Errorf("Failed to reload zones: %s", err)
+		}
+	}
+}
+
+func (z *Zones) listen(ctx context.Context) error {
+	conn, err := z.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := z.Refresh(ctx, n.Payload); err != nil {
+			log.Errorf("Failed to refresh zones for %q: %s", n.Payload, err)
+		}
+	}
+}
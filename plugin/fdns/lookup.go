@@ -0,0 +1,198 @@
+package fdns
+
+import (
+	"context"
+	"strings"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/file/tree"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Result is the result of a Lookup
+type Result int
+
+const (
+	// Success is a successful lookup.
+	Success Result = iota
+	// NameError indicates a nameerror
+	NameError
+	// Delegation indicates the lookup resulted in a delegation.
+	Delegation
+	// NoData indicates the lookup resulted in a NODATA.
+	NoData
+	// ServerFailure indicates a server failure during the lookup.
+	ServerFailure
+)
+
+// Lookup looks up qname and the query type in z, following the same rules as the file plugin:
+// in-zone CNAMEs are chased, NS records below the apex result in a referral, wildcards are expanded
+// and empty-non-terminals get a NODATA response.
+// Three sets of records are returned, one for the answer, one for authority and one for the additional section.
+func (z *Zone) Lookup(ctx context.Context, state request.Request, qname string) ([]dns.RR, []dns.RR, []dns.RR, Result) {
+	qtype := state.QType()
+	qname = strings.ToLower(qname)
+
+	loop, _ := ctx.Value(dnsserver.LoopKey{}).(int)
+	if loop > 8 {
+		return nil, nil, nil, ServerFailure
+	}
+
+	apex, _ := z.Search(z.origin)
+	if apex == nil || len(apex.Type(dns.TypeSOA)) == 0 {
+		return nil, nil, nil, ServerFailure
+	}
+	soa, ns := apex.Type(dns.TypeSOA), apex.Type(dns.TypeNS)
+
+	if qname == z.origin {
+		switch qtype {
+		case dns.TypeSOA:
+			return soa, ns, nil, Success
+		case dns.TypeNS:
+			return ns, nil, z.additional(ns), Success
+		}
+	}
+
+	var (
+		found          = qname == z.origin
+		elem, wildElem = apex, (*tree.Elem)(nil)
+	)
+
+	// Walk the names from the apex towards qname, looking for delegations on the way. When a
+	// name does not exist, we remember the wildcard that covers it, the deepest one wins.
+	for _, name := range namesBelow(z.origin, qname) {
+		elem, found = z.Search(name)
+		if !found {
+			if wild, ok := z.Search(replaceWithAsteriskLabel(name)); ok {
+				wildElem = wild
+			}
+			continue
+		}
+
+		if nsrrs := elem.Type(dns.TypeNS); nsrrs != nil {
+			// A DS query for the delegated name itself is answered from this zone.
+			if qtype == dns.TypeDS && name == qname {
+				continue
+			}
+			return nil, nsrrs, z.additional(nsrrs), Delegation
+		}
+	}
+
+	// Found entire name.
+	if found {
+		if rrs := elem.Type(dns.TypeCNAME); len(rrs) > 0 && qtype != dns.TypeCNAME {
+			return z.chase(ctx, state, rrs, ns, loop)
+		}
+
+		rrs := elem.Type(qtype)
+		if len(rrs) == 0 {
+			return nil, negativeSOA(soa), nil, NoData
+		}
+		return rrs, ns, z.additional(rrs), Success
+	}
+
+	// Found wildcard.
+	if wildElem != nil {
+		if rrs := wildElem.TypeForWildcard(dns.TypeCNAME, qname); len(rrs) > 0 && qtype != dns.TypeCNAME {
+			return z.chase(ctx, state, rrs, ns, loop)
+		}
+
+		rrs := wildElem.TypeForWildcard(qtype, qname)
+		if len(rrs) == 0 {
+			return nil, negativeSOA(soa), nil, NoData
+		}
+		return rrs, ns, z.additional(rrs), Success
+	}
+
+	// If a longer name does exist, qname is an empty-non-terminal and we return NODATA.
+	if x, found := z.Next(qname); found && dns.IsSubDomain(qname, x.Name()) {
+		return nil, negativeSOA(soa), nil, NoData
+	}
+	return nil, negativeSOA(soa), nil, NameError
+}
+
+// negativeSOA returns the SOA record for the authority section of a negative answer. Its TTL is the
+// minimum of the SOA record's TTL and its MINIMUM field, as caches use it for the negative
+// answer's TTL (RFC 2308, section 3).
+func negativeSOA(soa []dns.RR) []dns.RR {
+	s := soa[0].(*dns.SOA)
+	if s.Hdr.Ttl <= s.Minttl {
+		return soa
+	}
+	s = dns.Copy(s).(*dns.SOA)
+	s.Hdr.Ttl = s.Minttl
+	return []dns.RR{s}
+}
+
+// chase follows the CNAME in rrs when its target is in z, and appends the records found for
+// the target to the answer. CNAMEs pointing outside the zone are left for the resolver to follow.
+func (z *Zone) chase(ctx context.Context, state request.Request, rrs, ns []dns.RR, loop int) ([]dns.RR, []dns.RR, []dns.RR, Result) {
+	target := strings.ToLower(rrs[0].(*dns.CNAME).Target)
+	if !dns.IsSubDomain(z.origin, target) {
+		return rrs, ns, nil, Success
+	}
+
+	ctx = context.WithValue(ctx, dnsserver.LoopKey{}, loop+1)
+	answer, auth, extra, result := z.Lookup(ctx, state, target)
+	switch result {
+	case Delegation:
+		// The target lives in a delegated zone, the resolver will have to follow it from there.
+		return rrs, ns, nil, Success
+	case ServerFailure:
+		return rrs, ns, nil, ServerFailure
+	}
+	return append(append([]dns.RR(nil), rrs...), answer...), auth, extra, result
+}
+
+// additional returns the A and AAAA records for in-zone targets of the records in answer.
+func (z *Zone) additional(answer []dns.RR) []dns.RR {
+	var extra []dns.RR
+	seen := map[string]struct{}{}
+	for _, rr := range answer {
+		for _, name := range additionalNames(rr) {
+			name = strings.ToLower(name)
+			if _, ok := seen[name]; ok || !dns.IsSubDomain(z.origin, name) {
+				continue
+			}
+			seen[name] = struct{}{}
+
+			elem, found := z.Search(name)
+			if !found {
+				continue
+			}
+			extra = append(extra, elem.Type(dns.TypeA)...)
+			extra = append(extra, elem.Type(dns.TypeAAAA)...)
+		}
+	}
+	return extra
+}
+
+// namesBelow returns the names between zone (exclusive) and qname (inclusive), shortest first.
+func namesBelow(zone, qname string) []string {
+	if !dns.IsSubDomain(zone, qname) {
+		return nil
+	}
+	var names []string
+	for _, i := range dns.Split(qname) {
+		if len(qname[i:]) <= len(zone) {
+			break
+		}
+		names = append(names, qname[i:])
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return names
+}
+
+// replaceWithAsteriskLabel replaces the left most label with '*'.
+func replaceWithAsteriskLabel(qname string) (wildcard string) {
+	i, shot := dns.NextLabel(qname, 0)
+	if shot {
+		return ""
+	}
+
+	return "*." + qname[i:]
+}
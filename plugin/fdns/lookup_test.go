@@ -0,0 +1,123 @@
+package fdns
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestNamesBelow(t *testing.T) {
+	tests := []struct {
+		zone     string
+		qname    string
+		expected []string
+	}{
+		{"example.org.", "example.org.", nil},
+		{"example.org.", "www.example.org.", []string{"www.example.org."}},
+		{"example.org.", "a.b.example.org.", []string{"b.example.org.", "a.b.example.org."}},
+		{"example.org.", "www.example.net.", nil},
+		{"org.", "a.example.org.", []string{"example.org.", "a.example.org."}},
+	}
+
+	for i, tc := range tests {
+		got := namesBelow(tc.zone, tc.qname)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.expected, got)
+		}
+	}
+}
+
+const dbExampleOrg = `
+example.org.		300	IN	SOA	ns1.example.org. hostmaster.example.org. 2022060100 7200 3600 1209600 60
+example.org.		300	IN	NS	ns1.example.org.
+example.org.		300	IN	MX	10 mail.example.org.
+ns1.example.org.	300	IN	A	192.0.2.1
+mail.example.org.	300	IN	A	192.0.2.2
+www.example.org.	300	IN	CNAME	web.example.org.
+web.example.org.	300	IN	A	192.0.2.3
+ext.example.org.	300	IN	CNAME	www.example.net.
+dangling.example.org.	300	IN	CNAME	gone.example.org.
+*.wild.example.org.	300	IN	A	192.0.2.4
+a.b.ent.example.org.	300	IN	A	192.0.2.5
+sub.example.org.	300	IN	NS	ns.sub.example.org.
+ns.sub.example.org.	300	IN	A	192.0.2.6
+sub.example.org.	300	IN	DS	12345 13 2 0123456789ABCDEF
+`
+
+func newTestZone(t *testing.T) *Zone { return parseTestZone(t, "example.org.", dbExampleOrg) }
+
+func parseTestZone(t *testing.T, origin, db string) *Zone {
+	z := NewZone(origin)
+	zp := dns.NewZoneParser(strings.NewReader(db), z.origin, "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		z.Insert(rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("Expected no error when reading zone, got %q", err)
+	}
+	return z
+}
+
+func TestLookup(t *testing.T) {
+	z := newTestZone(t)
+
+	tests := []struct {
+		qname  string
+		qtype  uint16
+		result Result
+		answer int
+		ns     int
+		extra  int
+	}{
+		{"example.org.", dns.TypeSOA, Success, 1, 1, 0},
+		{"example.org.", dns.TypeNS, Success, 1, 0, 1},
+		{"example.org.", dns.TypeMX, Success, 1, 1, 1},
+		{"example.org.", dns.TypeTXT, NoData, 0, 1, 0},
+		{"www.example.org.", dns.TypeA, Success, 2, 1, 0},
+		{"www.example.org.", dns.TypeCNAME, Success, 1, 1, 0},
+		{"www.example.org.", dns.TypeAAAA, NoData, 1, 1, 0},
+		{"ext.example.org.", dns.TypeA, Success, 1, 1, 0},
+		{"dangling.example.org.", dns.TypeA, NameError, 1, 1, 0},
+		{"host.wild.example.org.", dns.TypeA, Success, 1, 1, 0},
+		{"host.wild.example.org.", dns.TypeAAAA, NoData, 0, 1, 0},
+		{"b.ent.example.org.", dns.TypeA, NoData, 0, 1, 0},
+		{"ent.example.org.", dns.TypeA, NoData, 0, 1, 0},
+		{"nope.example.org.", dns.TypeA, NameError, 0, 1, 0},
+		{"sub.example.org.", dns.TypeA, Delegation, 0, 1, 1},
+		{"www.sub.example.org.", dns.TypeA, Delegation, 0, 1, 1},
+		{"sub.example.org.", dns.TypeDS, Success, 1, 1, 0},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		state := request.Request{W: &test.ResponseWriter{}, Req: m}
+
+		answer, ns, extra, result := z.Lookup(context.TODO(), state, tc.qname)
+		if result != tc.result {
+			t.Errorf("Test %d: expected result %d, got %d", i, tc.result, result)
+		}
+		if len(answer) != tc.answer || len(ns) != tc.ns || len(extra) != tc.extra {
+			t.Errorf("Test %d: expected %d/%d/%d records, got %d/%d/%d", i, tc.answer, tc.ns, tc.extra, len(answer), len(ns), len(extra))
+		}
+	}
+}
+
+func TestLookupWildcardOwner(t *testing.T) {
+	z := newTestZone(t)
+
+	m := new(dns.Msg)
+	m.SetQuestion("host.wild.example.org.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: m}
+
+	answer, _, _, _ := z.Lookup(context.TODO(), state, "host.wild.example.org.")
+	if len(answer) != 1 || answer[0].Header().Name != "host.wild.example.org." {
+		t.Errorf("Expected synthesized answer for host.wild.example.org., got %v", answer)
+	}
+}
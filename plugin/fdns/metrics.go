@@ -0,0 +1,139 @@
+package fdns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount is the number of queries for a zone, zone is empty for queries outside all zones.
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fdns",
+		Name:      "requests_total",
+		Help:      "Counter of requests per zone.",
+	}, []string{"server", "zone"})
+	// responseCount is the number of responses fdns wrote itself, by rcode.
+	responseCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fdns",
+		Name:      "responses_total",
+		Help:      "Counter of responses per zone and rcode.",
+	}, []string{"server", "zone", "rcode"})
+	// errorCount is the number of failed database operations.
+	errorCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fdns",
+		Name:      "errors_total",
+		Help:      "Counter of failed database operations per zone.",
+	}, []string{"zone", "operation"})
+	// dbDuration is the time database operations take.
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fdns",
+		Name:      "database_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time database operations took.",
+	}, []string{"operation"})
+)
+
+// The operations in the errorCount and dbDuration metrics.
+const (
+	opLoad   = "load"
+	opListen = "listen"
+	opIxfr   = "ixfr"
+	opUpdate = "update"
+)
+
+// observe records the duration of a database operation that started at start.
+func observe(operation string, start time.Time) {
+	dbDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// pools collects the statistics of the connection pools of all fdns instances.
+var pools = &poolCollector{pools: make(map[*pgxpool.Pool]string)}
+
+func init() { prometheus.MustRegister(pools) }
+
+var (
+	poolConns = prometheus.NewDesc(prometheus.BuildFQName(plugin.Namespace, "fdns", "pool_connections"),
+		"Number of connections in the pool by state.", []string{"database", "state"}, nil)
+	poolAcquires = prometheus.NewDesc(prometheus.BuildFQName(plugin.Namespace, "fdns", "pool_acquires_total"),
+		"Counter of connections acquired from the pool.", []string{"database"}, nil)
+	poolEmptyAcquires = prometheus.NewDesc(prometheus.BuildFQName(plugin.Namespace, "fdns", "pool_empty_acquires_total"),
+		"Counter of acquires that had to wait for a connection.", []string{"database"}, nil)
+	poolAcquireWait = prometheus.NewDesc(prometheus.BuildFQName(plugin.Namespace, "fdns", "pool_acquire_wait_seconds_total"),
+		"Total time spent waiting for a connection from the pool.", []string{"database"}, nil)
+)
+
+// poolCollector is a prometheus.Collector that reports pgxpool statistics. Pools are labeled with
+// the database they connect to, the statistics of pools connecting to the same database are added.
+type poolCollector struct {
+	sync.Mutex
+	pools map[*pgxpool.Pool]string
+}
+
+func (c *poolCollector) add(p *pgxpool.Pool, database string) {
+	c.Lock()
+	defer c.Unlock()
+	c.pools[p] = database
+}
+
+func (c *poolCollector) remove(p *pgxpool.Pool) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.pools, p)
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolAcquireWait
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	type stats struct {
+		acquired, idle, constructing, total, max float64
+		acquires, emptyAcquires, wait            float64
+	}
+	dbs := make(map[string]*stats)
+
+	c.Lock()
+	for p, database := range c.pools {
+		s := dbs[database]
+		if s == nil {
+			s = &stats{}
+			dbs[database] = s
+		}
+		st := p.Stat()
+		s.acquired += float64(st.AcquiredConns())
+		s.idle += float64(st.IdleConns())
+		s.constructing += float64(st.ConstructingConns())
+		s.total += float64(st.TotalConns())
+		s.max += float64(st.MaxConns())
+		s.acquires += float64(st.AcquireCount())
+		s.emptyAcquires += float64(st.EmptyAcquireCount())
+		s.wait += st.AcquireDuration().Seconds()
+	}
+	c.Unlock()
+
+	for database, s := range dbs {
+		ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, s.acquired, database, "acquired")
+		ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, s.idle, database, "idle")
+		ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, s.constructing, database, "constructing")
+		ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, s.total, database, "total")
+		ch <- prometheus.MustNewConstMetric(poolConns, prometheus.GaugeValue, s.max, database, "max")
+		ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, s.acquires, database)
+		ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, s.emptyAcquires, database)
+		ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, s.wait, database)
+	}
+}
@@ -0,0 +1,40 @@
+package fdns
+
+import (
+	"sync"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// named holds the connection pools of the fdns instances that were given a name with the pool
+// property, so other plugins can share them instead of opening their own connections.
+var named = struct {
+	sync.Mutex
+	pools map[string]*pgxpool.Pool
+}{pools: make(map[string]*pgxpool.Pool)}
+
+// Pool returns the connection pool of the fdns instance named name, or nil when there is none.
+// Pools are added while the Corefile is set up, so they can be looked up from startup functions.
+// The pool is closed when the fdns instance shuts down, it must not be closed by the caller.
+func Pool(name string) *pgxpool.Pool {
+	named.Lock()
+	defer named.Unlock()
+	return named.pools[name]
+}
+
+// addPool makes p available as name. After a reload, the pool of the new instance replaces the
+// pool of the old one.
+func addPool(name string, p *pgxpool.Pool) {
+	named.Lock()
+	defer named.Unlock()
+	named.pools[name] = p
+}
+
+// removePool removes p, unless name was already taken over by another pool.
+func removePool(name string, p *pgxpool.Pool) {
+	named.Lock()
+	defer named.Unlock()
+	if named.pools[name] == p {
+		delete(named.pools, name)
+	}
+}
@@ -0,0 +1,27 @@
+package fdns
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func TestPool(t *testing.T) {
+	// The pools are only compared, they are never connected.
+	old, p := new(pgxpool.Pool), new(pgxpool.Pool)
+
+	addPool("test", old)
+	if Pool("test") != old {
+		t.Fatal("Expected the added pool")
+	}
+	// A reload adds the new pool before the old instance removes its pool.
+	addPool("test", p)
+	removePool("test", old)
+	if Pool("test") != p {
+		t.Fatal("Expected the pool of the new instance to remain")
+	}
+	removePool("test", p)
+	if Pool("test") != nil {
+		t.Error("Expected no pool after it was removed")
+	}
+}
@@ -0,0 +1,20 @@
+package fdns
+
+import (
+	"context"
+	"time"
+)
+
+// readyTimeout is the time the database has to answer the readiness check.
+const readyTimeout = time.Second
+
+// Ready implements the ready.Readiness interface, fdns is ready when the database can be reached.
+// The zones have already been loaded in setup.
+func (b FDNSBackend) Ready() bool {
+	if b.Zones == nil || b.Zones.pool == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	return b.Zones.pool.Ping(ctx) == nil
+}
@@ -0,0 +1,50 @@
+package fdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// newRR builds a resource record from a row of the records table. The content column holds the
+// RDATA in presentation format, exactly as it would appear in a zone file after the type, i.e.
+// "10 mail.example.org." for an MX record. Relative names in content are completed with origin.
+func newRR(origin, name, typ string, ttl uint32, content string) (dns.RR, error) {
+	if _, ok := dns.StringToType[strings.ToUpper(typ)]; !ok {
+		return nil, fmt.Errorf("unknown record type %q for %s", typ, name)
+	}
+
+	line := fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(name), ttl, strings.ToUpper(typ), content)
+	zp := dns.NewZoneParser(strings.NewReader(line), dns.Fqdn(origin), "")
+	rr, ok := zp.Next()
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if !ok || rr == nil {
+		return nil, fmt.Errorf("no record parsed for %s %s", name, typ)
+	}
+	return rr, nil
+}
+
+// additionalNames returns the names whose address records should be added to the additional
+// section for rr, as described in RFC 1034 section 3.6.2 and RFC 2782.
+func additionalNames(rr dns.RR) []string {
+	switch x := rr.(type) {
+	case *dns.MX:
+		return []string{x.Mx}
+	case *dns.SRV:
+		return []string{x.Target}
+	case *dns.NS:
+		return []string{x.Ns}
+	case *dns.SVCB:
+		if x.Target != "." {
+			return []string{x.Target}
+		}
+	case *dns.HTTPS:
+		if x.Target != "." {
+			return []string{x.Target}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package fdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewRR(t *testing.T) {
+	tests := []struct {
+		typ      string
+		content  string
+		expected string
+	}{
+		{"A", "127.0.0.1", "www.example.org.\t300\tIN\tA\t127.0.0.1"},
+		{"AAAA", "::1", "www.example.org.\t300\tIN\tAAAA\t::1"},
+		{"CNAME", "example.org.", "www.example.org.\t300\tIN\tCNAME\texample.org."},
+		{"cname", "other", "www.example.org.\t300\tIN\tCNAME\tother.example.org."},
+		{"TXT", "hello world", "www.example.org.\t300\tIN\tTXT\t\"hello\" \"world\""},
+		{"TXT", `"v=spf1 -all"`, "www.example.org.\t300\tIN\tTXT\t\"v=spf1 -all\""},
+		{"MX", "10 mail.example.org.", "www.example.org.\t300\tIN\tMX\t10 mail.example.org."},
+		{"SRV", "10 20 5060 sip", "www.example.org.\t300\tIN\tSRV\t10 20 5060 sip.example.org."},
+		{"CAA", `0 issue "letsencrypt.org"`, "www.example.org.\t300\tIN\tCAA\t0 issue \"letsencrypt.org\""},
+		{"PTR", "host.example.net.", "www.example.org.\t300\tIN\tPTR\thost.example.net."},
+		{"SSHFP", "4 2 123456789abcdef67890123456789abcdef67890123456789abcdef123456789", "www.example.org.\t300\tIN\tSSHFP\t4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789"},
+		{"TLSA", "3 1 1 0123456789abcdef", "www.example.org.\t300\tIN\tTLSA\t3 1 1 0123456789abcdef"},
+		{"HTTPS", "1 . alpn=h2,h3", "www.example.org.\t300\tIN\tHTTPS\t1 . alpn=\"h2,h3\""},
+		{"SOA", "ns1.example.org. hostmaster.example.org. 2022060100 7200 3600 1209600 3600", "www.example.org.\t300\tIN\tSOA\tns1.example.org. hostmaster.example.org. 2022060100 7200 3600 1209600 3600"},
+	}
+
+	for i, tc := range tests {
+		rr, err := newRR("example.org.", "www.example.org.", tc.typ, 300, tc.content)
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if rr.String() != tc.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, tc.expected, rr.String())
+		}
+	}
+}
+
+func TestNewRRError(t *testing.T) {
+	tests := []struct {
+		typ     string
+		content string
+	}{
+		{"A", "not-an-address"},
+		{"MX", "mail.example.org."},
+		{"BOGUS", "1"},
+		{"CAA", `x issue "letsencrypt.org"`},
+	}
+
+	for i, tc := range tests {
+		if _, err := newRR("example.org.", "www.example.org.", tc.typ, 300, tc.content); err == nil {
+			t.Errorf("Test %d: expected error for %s %q, got none", i, tc.typ, tc.content)
+		}
+	}
+}
+
+func TestAdditionalNames(t *testing.T) {
+	tests := []struct {
+		rr       string
+		expected string
+	}{
+		{"example.org. 300 IN MX 10 mail.example.org.", "mail.example.org."},
+		{"_sip._udp.example.org. 300 IN SRV 10 20 5060 sip.example.org.", "sip.example.org."},
+		{"example.org. 300 IN HTTPS 1 svc.example.org.", "svc.example.org."},
+		{"example.org. 300 IN HTTPS 1 . alpn=h2", ""},
+		{"example.org. 300 IN A 127.0.0.1", ""},
+	}
+
+	for i, tc := range tests {
+		rr, _ := dns.NewRR(tc.rr)
+		names := additionalNames(rr)
+		if tc.expected == "" {
+			if len(names) != 0 {
+				t.Errorf("Test %d: expected no names, got %v", i, names)
+			}
+			continue
+		}
+		if len(names) != 1 || names[0] != tc.expected {
+			t.Errorf("Test %d: expected %s, got %v", i, tc.expected, names)
+		}
+	}
+}
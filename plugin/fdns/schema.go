@@ -0,0 +1,129 @@
+package fdns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Schema holds the names of the tables and columns fdns reads from. Table names may be
+// qualified with a schema name, i.e. "dns.records".
+type Schema struct {
+	ZonesTable   string
+	RecordsTable string
+	KeysTable    string
+	ChangesTable string
+
+	ZoneColumn    string // Origin of a zone in the zones table.
+	NameColumn    string // Owner name of a record in the records table.
+	TypeColumn    string // Type of a record.
+	ContentColumn string // RDATA of a record in presentation format.
+	TTLColumn     string // TTL of a record, when NULL the default TTL is used.
+
+	TTL uint32 // Default TTL.
+}
+
+// DefaultSchema returns the schema created by schema.sql.
+func DefaultSchema() Schema {
+	return Schema{
+		ZonesTable:    "zones",
+		RecordsTable:  "records",
+		KeysTable:     "keys",
+		ChangesTable:  "changes",
+		ZoneColumn:    "id",
+		NameColumn:    "name",
+		TypeColumn:    "type",
+		ContentColumn: "content",
+		TTLColumn:     "ttl",
+		TTL:           3600,
+	}
+}
+
+// zonesSQL returns the query for the origins of all zones.
+func (s Schema) zonesSQL() string {
+	return fmt.Sprintf("SELECT %s FROM %s", ident(s.ZoneColumn), table(s.ZonesTable))
+}
+
+// recordsSQL returns the query for all records of zone $1, $2 is the zone with a leading dot.
+func (s Schema) recordsSQL() string {
+	name := ident(s.NameColumn)
+	return fmt.Sprintf("SELECT %s,%s,%s,COALESCE(%s,%d) FROM %s WHERE %s = $1 OR right(%s, length($2)) = $2",
+		name, ident(s.ContentColumn), ident(s.TypeColumn), ident(s.TTLColumn), s.TTL, table(s.RecordsTable), name, name)
+}
+
+// keysSQL returns the query for the DNSSEC keys of zone $1.
+func (s Schema) keysSQL() string {
+	return fmt.Sprintf("SELECT public,private FROM %s WHERE zone = $1", table(s.KeysTable))
+}
+
+// changesSQL returns the query for the changes to zone $1 after serial $2.
+func (s Schema) changesSQL() string {
+	return fmt.Sprintf("SELECT serial,op,name,type,ttl,content FROM %s WHERE zone = $1 AND serial > $2 ORDER BY id", table(s.ChangesTable))
+}
+
+// lockSOASQL returns the statement that locks the SOA record of zone $1.
+func (s Schema) lockSOASQL() string {
+	return fmt.Sprintf("SELECT 1 FROM %s WHERE %s = $1 AND %s = 'SOA' FOR UPDATE",
+		table(s.RecordsTable), ident(s.NameColumn), ident(s.TypeColumn))
+}
+
+// lockRecordsSQL returns the query that locks and returns the records owned by the names in $1,
+// prefixed with the ctid of their row.
+func (s Schema) lockRecordsSQL() string {
+	return fmt.Sprintf("SELECT ctid::text,%s,%s,%s,COALESCE(%s,%d) FROM %s WHERE %s = ANY($1) FOR UPDATE",
+		ident(s.NameColumn), ident(s.ContentColumn), ident(s.TypeColumn), ident(s.TTLColumn), s.TTL, table(s.RecordsTable), ident(s.NameColumn))
+}
+
+// deleteSQL returns the statement that deletes the record with ctid $1.
+func (s Schema) deleteSQL() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE ctid = $1::tid", table(s.RecordsTable))
+}
+
+// insertSQL returns the statement that inserts a record with name $1, type $2, content $3 and TTL $4.
+func (s Schema) insertSQL() string {
+	return fmt.Sprintf("INSERT INTO %s (%s,%s,%s,%s) VALUES ($1,$2,$3,$4)", table(s.RecordsTable),
+		ident(s.NameColumn), ident(s.TypeColumn), ident(s.ContentColumn), ident(s.TTLColumn))
+}
+
+// These are the names the queries of a Schema are prepared under, see Prepare.
+const (
+	stmtZones       = "fdns_zones"
+	stmtRecords     = "fdns_records"
+	stmtKeys        = "fdns_keys"
+	stmtChanges     = "fdns_changes"
+	stmtLockSOA     = "fdns_lock_soa"
+	stmtLockRecords = "fdns_lock_records"
+	stmtDelete      = "fdns_delete"
+	stmtInsert      = "fdns_insert"
+)
+
+// statements returns the queries of s by the name they are prepared under.
+func (s Schema) statements() map[string]string {
+	return map[string]string{
+		stmtZones:       s.zonesSQL(),
+		stmtRecords:     s.recordsSQL(),
+		stmtKeys:        s.keysSQL(),
+		stmtChanges:     s.changesSQL(),
+		stmtLockSOA:     s.lockSOASQL(),
+		stmtLockRecords: s.lockRecordsSQL(),
+		stmtDelete:      s.deleteSQL(),
+		stmtInsert:      s.insertSQL(),
+	}
+}
+
+// Prepare prepares the queries of s on conn, it is the AfterConnect hook of the pool. The queries
+// are run by the name of their prepared statement.
+func (s Schema) Prepare(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range s.statements() {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return fmt.Errorf("failed to prepare %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func ident(name string) string { return pgx.Identifier{name}.Sanitize() }
+
+func table(name string) string { return pgx.Identifier(strings.Split(name, ".")).Sanitize() }
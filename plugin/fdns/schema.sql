@@ -0,0 +1,106 @@
+CREATE TABLE IF NOT EXISTS zones (
+    id TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS records (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    content TEXT NOT NULL,
+    ttl INTEGER NOT NULL DEFAULT 3600
+);
+
+CREATE INDEX IF NOT EXISTS records_name_idx ON records (name);
+
+-- DNSSEC keys, as written by dnssec-keygen to the .key and .private files.
+CREATE TABLE IF NOT EXISTS keys (
+    id SERIAL PRIMARY KEY,
+    zone TEXT NOT NULL REFERENCES zones (id) ON DELETE CASCADE,
+    public TEXT NOT NULL,
+    private TEXT NOT NULL
+);
+
+-- Tell fdns which names changed, so it can reload the zones holding them.
+CREATE OR REPLACE FUNCTION fdns_notify() RETURNS trigger AS $$
+DECLARE
+    changed TEXT;
+BEGIN
+    IF TG_TABLE_NAME = 'zones' THEN
+        changed := COALESCE(NEW.id, OLD.id);
+    ELSIF TG_TABLE_NAME = 'keys' THEN
+        changed := COALESCE(NEW.zone, OLD.zone);
+    ELSE
+        changed := COALESCE(NEW.name, OLD.name);
+        IF TG_OP = 'UPDATE' AND NEW.name <> OLD.name THEN
+            PERFORM pg_notify('fdns', OLD.name);
+        END IF;
+    END IF;
+    PERFORM pg_notify('fdns', changed);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS records_notify ON records;
+CREATE TRIGGER records_notify AFTER INSERT OR UPDATE OR DELETE ON records
+    FOR EACH ROW EXECUTE FUNCTION fdns_notify();
+
+DROP TRIGGER IF EXISTS zones_notify ON zones;
+CREATE TRIGGER zones_notify AFTER INSERT OR UPDATE OR DELETE ON zones
+    FOR EACH ROW EXECUTE FUNCTION fdns_notify();
+
+DROP TRIGGER IF EXISTS keys_notify ON keys;
+CREATE TRIGGER keys_notify AFTER INSERT OR UPDATE OR DELETE ON keys
+    FOR EACH ROW EXECUTE FUNCTION fdns_notify();
+
+-- Every change to a record, used for incremental zone transfers (IXFR). A change belongs to the
+-- version of the zone with the SOA serial the changing transaction commits with, so every
+-- transaction that changes records should also increase the serial.
+CREATE TABLE IF NOT EXISTS changes (
+    id BIGSERIAL PRIMARY KEY,
+    zone TEXT NOT NULL,
+    serial BIGINT NOT NULL,
+    op TEXT NOT NULL CHECK (op IN ('add', 'del')),
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    ttl INTEGER NOT NULL,
+    content TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS changes_zone_serial_idx ON changes (zone, serial);
+
+CREATE OR REPLACE FUNCTION fdns_log_change() RETURNS trigger AS $$
+DECLARE
+    owner TEXT := COALESCE(NEW.name, OLD.name);
+    z TEXT;
+    s BIGINT;
+BEGIN
+    -- A record belongs to the zone with the longest origin that is a suffix of its name.
+    SELECT id INTO z FROM zones
+        WHERE owner = id OR right(owner, length(id) + 1) = '.' || id
+        ORDER BY length(id) DESC LIMIT 1;
+    IF z IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    -- This trigger is deferred to the end of the transaction, so this is the serial it commits with.
+    SELECT (regexp_split_to_array(trim(content), '\s+'))[3]::BIGINT INTO s
+        FROM records WHERE name = z AND type = 'SOA';
+    IF s IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO changes (zone, serial, op, name, type, ttl, content)
+            VALUES (z, s, 'del', OLD.name, OLD.type, OLD.ttl, OLD.content);
+    END IF;
+    IF TG_OP IN ('UPDATE', 'INSERT') THEN
+        INSERT INTO changes (zone, serial, op, name, type, ttl, content)
+            VALUES (z, s, 'add', NEW.name, NEW.type, NEW.ttl, NEW.content);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS records_log_change ON records;
+CREATE CONSTRAINT TRIGGER records_log_change AFTER INSERT OR UPDATE OR DELETE ON records
+    DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION fdns_log_change();
@@ -0,0 +1,31 @@
+package fdns
+
+import "testing"
+
+func TestSchemaSQL(t *testing.T) {
+	s := DefaultSchema()
+	if got, expected := s.zonesSQL(), `SELECT "id" FROM "zones"`; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got, expected := s.recordsSQL(), `SELECT "name","content","type",COALESCE("ttl",3600) FROM "records" WHERE "name" = $1 OR right("name", length($2)) = $2`; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	s.RecordsTable = "dns.rr"
+	s.ContentColumn = "rdata"
+	s.TTL = 60
+	if got, expected := s.recordsSQL(), `SELECT "name","rdata","type",COALESCE("ttl",60) FROM "dns"."rr" WHERE "name" = $1 OR right("name", length($2)) = $2`; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestSchemaStatements(t *testing.T) {
+	s := DefaultSchema()
+	stmts := s.statements()
+	if len(stmts) != 8 {
+		t.Errorf("Expected 8 statements, got %d", len(stmts))
+	}
+	if stmts[stmtRecords] != s.recordsSQL() || stmts[stmtInsert] != s.insertSQL() {
+		t.Errorf("Expected the statements to be prepared with the queries of the schema")
+	}
+}
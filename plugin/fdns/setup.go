@@ -2,13 +2,20 @@ package fdns
 
 import (
 	"context"
-	"log"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/transfer"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/joeguo/tldextract"
+
+	"github.com/miekg/dns"
 )
 
 func init() {
@@ -18,29 +25,78 @@ func init() {
 	})
 }
 
-func setup(c *caddy.Controller) error {
-	backend := FDNSBackend{}
-	c.Next()
+// config holds everything parsed from the Corefile.
+type config struct {
+	pool    *pgxpool.Config
+	name    string // Name other plugins can share the pool with, empty when it isn't shared.
+	schema  Schema
+	timeout time.Duration
+	fall    fall.F
+	keys    map[string]Key
+	debug   bool
+}
 
-	if !c.NextArg() {
-		return plugin.Error("fdns", c.ArgErr())
+func setup(c *caddy.Controller) error {
+	cfg, err := fdnsParse(c)
+	if err != nil {
+		return plugin.Error("fdns", err)
 	}
-	connString := c.Val()
-	log.Println("[fdns] connecting to", connString)
+	log.Infof("Connecting to %s", database(cfg.pool))
 
-	dbPool, err := pgxpool.Connect(context.Background(), connString)
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(cfg.timeout))
+	dbPool, err := pgxpool.ConnectConfig(ctx, cfg.pool)
+	cancel()
 	if err != nil {
-		return err
+		return plugin.Error("fdns", err)
 	}
-	backend.Pool = dbPool
 
-	extract, _ := tldextract.New("/tmp/tld.cache", false)
-	backend.TldExtract = extract
+	backend := FDNSBackend{Keys: cfg.keys, Fall: cfg.fall, Debug: cfg.debug}
+	backend.Zones = NewZones(dbPool, cfg.timeout)
+	backend.Zones.debug = cfg.debug
+	if err := backend.Zones.Load(context.Background()); err != nil {
+		dbPool.Close()
+		return plugin.Error("fdns", err)
+	}
+
+	// Share the pool right away, the plugins that use it look it up when they start.
+	if cfg.name != "" {
+		addPool(cfg.name, dbPool)
+	}
 
-	if c.NextArg() {
-		return plugin.Error("fdns", c.ArgErr())
+	// The server verifies the TSIG signatures of updates.
+	if len(cfg.keys) > 0 {
+		sc := dnsserver.GetConfig(c)
+		if sc.TsigSecret == nil {
+			sc.TsigSecret = make(map[string]string)
+		}
+		for name, k := range cfg.keys {
+			sc.TsigSecret[name] = k.Secret
+		}
 	}
 
+	ctx, cancel = context.WithCancel(context.Background())
+	c.OnStartup(func() error {
+		t := dnsserver.GetConfig(c).Handler("transfer")
+		if t != nil {
+			backend.Zones.transfer = t.(*transfer.Transfer)
+		}
+		return nil
+	})
+	c.OnStartup(func() error {
+		pools.add(dbPool, database(cfg.pool))
+		go backend.Zones.Listen(ctx)
+		return nil
+	})
+	c.OnShutdown(func() error {
+		cancel()
+		if cfg.name != "" {
+			removePool(cfg.name, dbPool)
+		}
+		pools.remove(dbPool)
+		dbPool.Close()
+		return nil
+	})
+
 	dnsserver.
 		GetConfig(c).
 		AddPlugin(func(next plugin.Handler) plugin.Handler {
@@ -50,3 +106,191 @@ func setup(c *caddy.Controller) error {
 
 	return nil
 }
+
+func fdnsParse(c *caddy.Controller) (*config, error) {
+	cfg := &config{schema: DefaultSchema(), timeout: defaultTimeout}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		var err error
+		if cfg.pool, err = pgxpool.ParseConfig(args[0]); err != nil {
+			return nil, err
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "fallthrough":
+				cfg.fall.SetZonesFromArgs(c.RemainingArgs())
+			case "ttl":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				ttl, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if ttl < 0 || ttl > 2147483647 {
+					return nil, c.Errf("ttl provided is invalid: %d", ttl)
+				}
+				cfg.schema.TTL = uint32(ttl)
+			case "max_conns", "min_conns":
+				opt := c.Val()
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if n < 0 || (opt == "max_conns" && n == 0) {
+					return nil, c.Errf("%s provided is invalid: %d", opt, n)
+				}
+				if opt == "max_conns" {
+					cfg.pool.MaxConns = int32(n)
+				} else {
+					cfg.pool.MinConns = int32(n)
+				}
+			case "pool":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				cfg.name = args[0]
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d < 0 {
+					return nil, c.Errf("timeout provided is invalid: %s", d)
+				}
+				cfg.timeout = d
+			case "table":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "zones":
+					cfg.schema.ZonesTable = args[1]
+				case "records":
+					cfg.schema.RecordsTable = args[1]
+				case "keys":
+					cfg.schema.KeysTable = args[1]
+				case "changes":
+					cfg.schema.ChangesTable = args[1]
+				default:
+					return nil, c.Errf("unknown table '%s'", args[0])
+				}
+			case "column":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "zone":
+					cfg.schema.ZoneColumn = args[1]
+				case "name":
+					cfg.schema.NameColumn = args[1]
+				case "type":
+					cfg.schema.TypeColumn = args[1]
+				case "content":
+					cfg.schema.ContentColumn = args[1]
+				case "ttl":
+					cfg.schema.TTLColumn = args[1]
+				default:
+					return nil, c.Errf("unknown column '%s'", args[0])
+				}
+			case "update":
+				args := c.RemainingArgs()
+				if len(args) < 3 {
+					return nil, c.ArgErr()
+				}
+				k, err := parseKey(args)
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				if _, ok := cfg.keys[k.Name]; ok {
+					return nil, c.Errf("key '%s' is defined more than once", k.Name)
+				}
+				if cfg.keys == nil {
+					cfg.keys = make(map[string]Key)
+				}
+				cfg.keys[k.Name] = k
+			case "debug":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				cfg.debug = true
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	if cfg.pool.MinConns > cfg.pool.MaxConns {
+		return nil, c.Errf("min_conns (%d) is larger than max_conns (%d)", cfg.pool.MinConns, cfg.pool.MaxConns)
+	}
+	cfg.pool.AfterConnect = cfg.schema.Prepare
+	return cfg, nil
+}
+
+// algorithms maps the TSIG algorithms that can be used in the Corefile to their names.
+var algorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// parseKey parses the arguments of the update property: NAME ALGORITHM SECRET [ZONES...].
+func parseKey(args []string) (Key, error) {
+	k := Key{Name: dns.Fqdn(strings.ToLower(args[0]))}
+	if _, ok := dns.IsDomainName(k.Name); !ok {
+		return k, fmt.Errorf("invalid key name '%s'", args[0])
+	}
+	var ok bool
+	if k.Algorithm, ok = algorithms[strings.ToLower(strings.TrimSuffix(args[1], "."))]; !ok {
+		return k, fmt.Errorf("unknown TSIG algorithm '%s'", args[1])
+	}
+	if _, err := base64.StdEncoding.DecodeString(args[2]); err != nil {
+		return k, fmt.Errorf("invalid secret for key '%s': %s", args[0], err)
+	}
+	k.Secret = args[2]
+	for _, z := range args[3:] {
+		k.Zones = append(k.Zones, plugin.Host(z).NormalizeExact()...)
+	}
+	return k, nil
+}
+
+// database returns the host and database p connects to, without any credentials.
+func database(p *pgxpool.Config) string {
+	return fmt.Sprintf("%s:%d/%s", p.ConnConfig.Host, p.ConnConfig.Port, p.ConnConfig.Database)
+}
+
+// connectTimeout returns the time allowed for connecting to the database.
+func connectTimeout(timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// defaultTimeout is the default timeout for loading zones from the database.
+const defaultTimeout = 30 * time.Second
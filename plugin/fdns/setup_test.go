@@ -0,0 +1,146 @@
+package fdns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
+)
+
+func TestFdnsParse(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedErr   string
+		expectedTTL   uint32
+		expectedTable string
+		expectedMax   int32
+		expectedTO    time.Duration
+		expectedFall  bool
+		expectedDebug bool
+	}{
+		{`fdns postgres://localhost/dns`, false, "", 3600, "records", 0, defaultTimeout, false, false},
+		{`fdns postgres://localhost/dns {
+			ttl 60
+			table records dns.rr
+			max_conns 8
+			min_conns 2
+			timeout 5s
+			fallthrough
+			debug
+		}`, false, "", 60, "dns.rr", 8, 5 * time.Second, true, true},
+		{`fdns postgres://localhost/dns {
+			column content rdata
+			column ttl expire
+		}`, false, "", 3600, "records", 0, defaultTimeout, false, false},
+		{`fdns postgres://localhost/dns {
+			pool zones
+		}`, false, "", 3600, "records", 0, defaultTimeout, false, false},
+		// fails
+		{`fdns`, true, "Wrong argument count", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			pool
+		}`, true, "Wrong argument count", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns extra`, true, "Wrong argument count", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			ttl -1
+		}`, true, "ttl provided is invalid", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			table bogus x
+		}`, true, "unknown table", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			column bogus x
+		}`, true, "unknown column", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			max_conns 2
+			min_conns 4
+		}`, true, "larger than max_conns", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			max_conns 0
+		}`, true, "max_conns provided is invalid", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			timeout soon
+		}`, true, "invalid duration", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns {
+			bogus
+		}`, true, "unknown property", 0, "", 0, 0, false, false},
+		{`fdns postgres://localhost/dns
+		fdns postgres://localhost/dns`, true, "this plugin", 0, "", 0, 0, false, false},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := fdnsParse(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+
+		if cfg.schema.TTL != test.expectedTTL {
+			t.Errorf("Test %d: expected ttl %d, got %d", i, test.expectedTTL, cfg.schema.TTL)
+		}
+		if cfg.schema.RecordsTable != test.expectedTable {
+			t.Errorf("Test %d: expected records table %s, got %s", i, test.expectedTable, cfg.schema.RecordsTable)
+		}
+		if test.expectedMax != 0 && cfg.pool.MaxConns != test.expectedMax {
+			t.Errorf("Test %d: expected max_conns %d, got %d", i, test.expectedMax, cfg.pool.MaxConns)
+		}
+		if cfg.timeout != test.expectedTO {
+			t.Errorf("Test %d: expected timeout %s, got %s", i, test.expectedTO, cfg.timeout)
+		}
+		if cfg.fall.Through("example.org.") != test.expectedFall {
+			t.Errorf("Test %d: expected fallthrough %t", i, test.expectedFall)
+		}
+		if cfg.pool.AfterConnect == nil {
+			t.Errorf("Test %d: expected the statements to be prepared after connecting", i)
+		}
+		if cfg.debug != test.expectedDebug {
+			t.Errorf("Test %d: expected debug %t", i, test.expectedDebug)
+		}
+	}
+}
+
+func TestFdnsParseUpdate(t *testing.T) {
+	c := caddy.NewTestController("dns", `fdns postgres://localhost/dns {
+		update DDNS. hmac-sha256 c2VjcmV0 example.org
+		update certbot hmac-sha512 c2VjcmV0
+	}`)
+	cfg, err := fdnsParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if k := cfg.keys["ddns."]; k.Algorithm != dns.HmacSHA256 || k.Secret != "c2VjcmV0" || len(k.Zones) != 1 || k.Zones[0] != "example.org." {
+		t.Errorf("Unexpected key ddns.: %+v", k)
+	}
+	if k := cfg.keys["certbot."]; k.Algorithm != dns.HmacSHA512 || len(k.Zones) != 0 {
+		t.Errorf("Unexpected key certbot.: %+v", k)
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{`update ddns. hmac-sha256`, "Wrong argument count"},
+		{`update ddns. hmac-md4 c2VjcmV0`, "unknown TSIG algorithm"},
+		{`update ddns. hmac-sha256 !secret!`, "invalid secret"},
+		{"update ddns. hmac-sha256 c2VjcmV0\nupdate DDNS hmac-sha1 c2VjcmV0", "more than once"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "fdns postgres://localhost/dns {\n"+test.input+"\n}")
+		if _, err := fdnsParse(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
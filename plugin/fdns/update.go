@@ -0,0 +1,460 @@
+package fdns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a TSIG key that is allowed to send dynamic updates.
+type Key struct {
+	Name      string   // Name of the key, fully qualified and lower cased.
+	Algorithm string   // TSIG algorithm, i.e. dns.HmacSHA256.
+	Secret    string   // Base64 encoded secret.
+	Zones     []string // Zones the key may update, all zones when empty.
+}
+
+// allows returns true if k may update zone.
+func (k Key) allows(zone string) bool {
+	if len(k.Zones) == 0 {
+		return true
+	}
+	for _, z := range k.Zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// serveUpdate handles a dynamic update (RFC 2136). Updates must be signed with one of the keys in
+// b.Keys, the prerequisites and updates are applied to the records table in a single transaction
+// that also increases the serial of the zone.
+func (b FDNSBackend) serveUpdate(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	a := new(dns.Msg)
+	a.SetReply(r)
+
+	zone := strings.ToLower(state.Name())
+	k, verified := b.verify(w, r)
+	if verified {
+		t := r.IsTsig()
+		a.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+
+	rcode := b.authorize(r, zone, k, verified)
+	if rcode == dns.RcodeSuccess {
+		rcode = prescan(zone, r.Ns)
+	}
+	if rcode == dns.RcodeSuccess {
+		var (
+			changed bool
+			err     error
+		)
+		rcode, changed, err = b.Zones.update(ctx, zone, r.Answer, r.Ns)
+		if err != nil {
+			errorCount.WithLabelValues(zone, opUpdate).Inc()
+			log.Errorf("Update of %s failed: %s", zone, err)
+			rcode = dns.RcodeServerFailure
+		}
+		if changed {
+			if err := b.Zones.Refresh(context.Background(), zone); err != nil {
+				log.Errorf("Failed to refresh zones for %q: %s", zone, err)
+			}
+		}
+	}
+	debugf(b.Debug, "Update %s: %s", zone, dns.RcodeToString[rcode])
+
+	a.Rcode = rcode
+	return dns.RcodeSuccess, w.WriteMsg(a)
+}
+
+// verify returns the key update r is signed with and true, or false when r isn't signed with one
+// of the keys in b.Keys. The server has already verified the signature with the secrets
+// registered in setup.
+func (b FDNSBackend) verify(w dns.ResponseWriter, r *dns.Msg) (Key, bool) {
+	t := r.IsTsig()
+	if t == nil {
+		return Key{}, false
+	}
+	k, ok := b.Keys[strings.ToLower(t.Hdr.Name)]
+	if !ok || !strings.EqualFold(t.Algorithm, k.Algorithm) || w.TsigStatus() != nil {
+		return Key{}, false
+	}
+	return k, true
+}
+
+// authorize checks the zone and key of update r and returns the rcode to reply with when it may
+// not be applied, or dns.RcodeSuccess when it may.
+func (b FDNSBackend) authorize(r *dns.Msg, zone string, k Key, verified bool) int {
+	switch {
+	case r.Question[0].Qtype != dns.TypeSOA:
+		return dns.RcodeFormatError
+	case b.Zones.Zones(zone) == nil:
+		return dns.RcodeNotAuth
+	case r.IsTsig() == nil:
+		return dns.RcodeRefused
+	case !verified:
+		return dns.RcodeNotAuth
+	case !k.allows(zone):
+		return dns.RcodeRefused
+	}
+	return dns.RcodeSuccess
+}
+
+// prescan checks the update section of an update for zone, see RFC 2136 section 3.4.1.
+func prescan(zone string, updates []dns.RR) int {
+	for _, rr := range updates {
+		h := rr.Header()
+		if !dns.IsSubDomain(zone, strings.ToLower(h.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassINET:
+			if h.Rrtype == dns.TypeANY || metaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 || metaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 || h.Rrtype == dns.TypeANY || metaType(h.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// metaType returns true for the query types that can't be stored in a zone, other than ANY.
+func metaType(t uint16) bool {
+	switch t {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB:
+		return true
+	}
+	return false
+}
+
+// prerequisites checks the prerequisite section of an update for zone against rrs, which must
+// hold all records owned by the names in the prerequisites. See RFC 2136 section 3.2.
+func prerequisites(zone string, prereqs, rrs []dns.RR) int {
+	var values []dns.RR
+	for _, rr := range prereqs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(owned(rrs, name, dns.TypeANY)) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if len(owned(rrs, name, h.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY {
+				if len(owned(rrs, name, dns.TypeANY)) > 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if len(owned(rrs, name, h.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			values = append(values, rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	// Value dependent prerequisites: the RRsets must match exactly, ignoring the TTLs.
+	for _, rr := range values {
+		h := rr.Header()
+		want := owned(values, strings.ToLower(h.Name), h.Rrtype)
+		have := owned(rrs, strings.ToLower(h.Name), h.Rrtype)
+		if !sameRRset(want, have) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// owned returns the records in rrs with owner name and type t, TypeANY matches all types.
+func owned(rrs []dns.RR, name string, t uint16) []dns.RR {
+	var o []dns.RR
+	for _, rr := range rrs {
+		h := rr.Header()
+		if strings.EqualFold(h.Name, name) && (t == dns.TypeANY || h.Rrtype == t) {
+			o = append(o, rr)
+		}
+	}
+	return o
+}
+
+// sameRRset returns true if a and b hold the same records, ignoring duplicates and TTLs.
+func sameRRset(a, b []dns.RR) bool {
+	return subset(a, b) && subset(b, a)
+}
+
+func subset(a, b []dns.RR) bool {
+	for _, x := range a {
+		if !containsDuplicate(b, x) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsDuplicate(rrs []dns.RR, rr dns.RR) bool {
+	for _, r := range rrs {
+		if dns.IsDuplicate(r, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies the update section of an update for zone to rrs and returns the result, see
+// RFC 2136 section 3.4.2. Records from rrs are never modified: a record that changes is replaced
+// by a copy, so the records to delete and insert can be found by comparing the pointers.
+func apply(zone string, rrs, updates []dns.RR) []dns.RR {
+	rrs = append([]dns.RR(nil), rrs...)
+	for _, u := range updates {
+		h := u.Header()
+		name := strings.ToLower(h.Name)
+		apex := name == zone
+
+		switch h.Class {
+		case dns.ClassINET:
+			rrs = add(zone, rrs, u)
+
+		case dns.ClassANY:
+			rrs = filter(rrs, func(rr dns.RR) bool {
+				t := rr.Header().Rrtype
+				if !strings.EqualFold(rr.Header().Name, name) || apex && (t == dns.TypeSOA || t == dns.TypeNS) {
+					return true
+				}
+				return h.Rrtype != dns.TypeANY && h.Rrtype != t
+			})
+
+		case dns.ClassNONE:
+			if h.Rrtype == dns.TypeSOA {
+				continue
+			}
+			if apex && h.Rrtype == dns.TypeNS && len(owned(rrs, name, dns.TypeNS)) <= 1 {
+				continue // never delete the last NS record of the zone
+			}
+			del := dns.Copy(u)
+			del.Header().Class = dns.ClassINET
+			rrs = filter(rrs, func(rr dns.RR) bool { return !dns.IsDuplicate(rr, del) })
+		}
+	}
+	return rrs
+}
+
+// add adds u to rrs, or replaces the record it updates.
+func add(zone string, rrs []dns.RR, u dns.RR) []dns.RR {
+	u = dns.Copy(u)
+	u.Header().Name = strings.ToLower(u.Header().Name)
+	t := u.Header().Rrtype
+	if t == dns.TypeSOA && u.Header().Name != zone {
+		return rrs
+	}
+
+	for i, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, u.Header().Name) {
+			continue
+		}
+		rt := rr.Header().Rrtype
+		switch {
+		case (t == dns.TypeCNAME) != (rt == dns.TypeCNAME):
+			// A CNAME can't coexist with other data, whatever was there first wins.
+			return rrs
+		case t == rt && t == dns.TypeSOA:
+			if !serialGreater(u.(*dns.SOA).Serial, rr.(*dns.SOA).Serial) {
+				return rrs
+			}
+			rrs[i] = u
+			return rrs
+		case t == rt && t == dns.TypeCNAME:
+			if !dns.IsDuplicate(rr, u) || rr.Header().Ttl != u.Header().Ttl {
+				rrs[i] = u
+			}
+			return rrs
+		case dns.IsDuplicate(rr, u):
+			if rr.Header().Ttl != u.Header().Ttl {
+				rrs[i] = u
+			}
+			return rrs
+		}
+	}
+	return append(rrs, u)
+}
+
+func filter(rrs []dns.RR, keep func(dns.RR) bool) []dns.RR {
+	var f []dns.RR
+	for _, rr := range rrs {
+		if keep(rr) {
+			f = append(f, rr)
+		}
+	}
+	return f
+}
+
+// serialGreater returns true if serial a is greater than b, see RFC 1982.
+func serialGreater(a, b uint32) bool { return a != b && int32(a-b) > 0 }
+
+// diff returns the records from have that are missing from want and the records from want that
+// are missing from have. Records are compared by identity, see apply.
+func diff(have, want []dns.RR) (del, add []dns.RR) {
+	for _, rr := range have {
+		if !contains(want, rr) {
+			del = append(del, rr)
+		}
+	}
+	for _, rr := range want {
+		if !contains(have, rr) {
+			add = append(add, rr)
+		}
+	}
+	return del, add
+}
+
+func contains(rrs []dns.RR, rr dns.RR) bool {
+	for _, r := range rrs {
+		if r == rr {
+			return true
+		}
+	}
+	return false
+}
+
+// bumpSerial increases the serial of zone's SOA record in want, unless the update already did.
+// Have holds the records before the update.
+func bumpSerial(zone string, have, want []dns.RR) []dns.RR {
+	for i, rr := range want {
+		soa, ok := rr.(*dns.SOA)
+		if !ok || soa.Hdr.Name != zone {
+			continue
+		}
+		if !contains(have, rr) {
+			return want // replaced by the update
+		}
+		soa = dns.Copy(soa).(*dns.SOA)
+		soa.Serial++
+		want[i] = soa
+		return want
+	}
+	return want
+}
+
+// update applies the prerequisites and updates of an update for zone to the records table. It
+// returns the rcode for the reply and whether the zone was changed.
+func (z *Zones) update(ctx context.Context, zone string, prereqs, updates []dns.RR) (int, bool, error) {
+	if z.pool == nil {
+		return dns.RcodeServerFailure, false, fmt.Errorf("no database")
+	}
+	defer observe(opUpdate, time.Now())
+	ctx, cancel := z.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := z.pool.Begin(ctx)
+	if err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the SOA record serializes the updates of a zone.
+	if _, err := tx.Exec(ctx, stmtLockSOA, zone); err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+
+	names := []string{zone}
+	for _, rr := range prereqs {
+		names = append(names, strings.ToLower(rr.Header().Name))
+	}
+	for _, rr := range updates {
+		names = append(names, strings.ToLower(rr.Header().Name))
+	}
+	ctids, have, err := z.lockRecords(ctx, tx, zone, names)
+	if err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+
+	if rcode := prerequisites(zone, prereqs, have); rcode != dns.RcodeSuccess {
+		return rcode, false, nil
+	}
+	want := apply(zone, have, updates)
+	del, add := diff(have, want)
+	if len(del) == 0 && len(add) == 0 {
+		return dns.RcodeSuccess, false, nil
+	}
+	del, add = diff(have, bumpSerial(zone, have, want))
+
+	for _, rr := range del {
+		if _, err := tx.Exec(ctx, stmtDelete, ctids[rr]); err != nil {
+			return dns.RcodeServerFailure, false, err
+		}
+	}
+	for _, rr := range add {
+		h := rr.Header()
+		content := strings.TrimPrefix(rr.String(), h.String())
+		if _, err := tx.Exec(ctx, stmtInsert, h.Name, dns.Type(h.Rrtype).String(), content, int32(h.Ttl)); err != nil {
+			return dns.RcodeServerFailure, false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return dns.RcodeServerFailure, false, err
+	}
+	return dns.RcodeSuccess, true, nil
+}
+
+// lockRecords reads the records owned by names and locks them until tx ends. Records are returned with
+// the ctid of their row, which identifies it without relying on a primary key.
+func (z *Zones) lockRecords(ctx context.Context, tx pgx.Tx, zone string, names []string) (map[dns.RR]string, []dns.RR, error) {
+	rows, err := tx.Query(ctx, stmtLockRecords, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ctids := make(map[dns.RR]string)
+	var rrs []dns.RR
+	for rows.Next() {
+		var (
+			ctid, name, content, typ string
+			ttl                      int32
+		)
+		if err := rows.Scan(&ctid, &name, &content, &typ, &ttl); err != nil {
+			return nil, nil, err
+		}
+		rr, err := newRR(zone, strings.ToLower(name), typ, uint32(ttl), content)
+		if err != nil {
+			log.Warningf("Skipping record in %s: %s", zone, err)
+			continue
+		}
+		ctids[rr] = ctid
+		rrs = append(rrs, rr)
+	}
+	return ctids, rrs, rows.Err()
+}
@@ -0,0 +1,237 @@
+package fdns
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func newTestRRs(t *testing.T, rrs ...string) []dns.RR {
+	var r []dns.RR
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, rr)
+	}
+	return r
+}
+
+func TestServeUpdate(t *testing.T) {
+	zones := &Zones{}
+	zones.Add(newTestZone(t))
+	zones.Add(parseTestZone(t, "example.net.", "example.net. 300 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 60\n"))
+	b := FDNSBackend{Zones: zones, Keys: map[string]Key{
+		"ddns.": {Name: "ddns.", Algorithm: dns.HmacSHA256, Secret: "c2VjcmV0", Zones: []string{"example.org."}},
+	}}
+	insert := newTestRRs(t, "host.example.org. 300 IN A 192.0.2.100")
+
+	tests := []struct {
+		zone   string
+		key    string
+		alg    string
+		insert []dns.RR
+		rcode  int
+	}{
+		{"example.org.", "", "", insert, dns.RcodeRefused},
+		{"example.org.", "other.", dns.HmacSHA256, insert, dns.RcodeNotAuth},
+		{"example.org.", "ddns.", dns.HmacSHA512, insert, dns.RcodeNotAuth},
+		{"example.com.", "ddns.", dns.HmacSHA256, insert, dns.RcodeNotAuth},
+		{"example.net.", "ddns.", dns.HmacSHA256, nil, dns.RcodeRefused},
+		{"example.org.", "ddns.", dns.HmacSHA256, newTestRRs(t, "host.example.net. 300 IN A 192.0.2.100"), dns.RcodeNotZone},
+		// Everything checks out, but there is no database to update.
+		{"example.org.", "ddns.", dns.HmacSHA256, insert, dns.RcodeServerFailure},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetUpdate(tc.zone)
+		m.Insert(tc.insert)
+		if tc.key != "" {
+			m.SetTsig(tc.key, tc.alg, 300, time.Now().Unix())
+		}
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := b.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Test %d: expected no error, got %s", i, err)
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+		if signed := rec.Msg.IsTsig() != nil; signed != (tc.key == "ddns." && tc.alg == dns.HmacSHA256) {
+			t.Errorf("Test %d: expected reply to be signed only with a valid key, signed: %t", i, signed)
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.Opcode = dns.OpcodeUpdate
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	b.ServeDNS(context.TODO(), rec, m)
+	if rec.Msg.Rcode != dns.RcodeFormatError {
+		t.Errorf("Expected rcode FORMERR for a zone section without SOA, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+}
+
+func TestPrescan(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert(newTestRRs(t, "a.example.org. 300 IN A 192.0.2.1"))
+	m.RemoveRRset(newTestRRs(t, "b.example.org. 300 IN A 192.0.2.1"))
+	m.RemoveName(newTestRRs(t, "c.example.org. 300 IN A 192.0.2.1"))
+	m.Remove(newTestRRs(t, "d.example.org. 300 IN A 192.0.2.1"))
+	if rcode := prescan("example.org.", m.Ns); rcode != dns.RcodeSuccess {
+		t.Errorf("Expected NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+
+	notZone := newTestRRs(t, "a.example.net. 300 IN A 192.0.2.1")
+	if rcode := prescan("example.org.", notZone); rcode != dns.RcodeNotZone {
+		t.Errorf("Expected NOTZONE, got %s", dns.RcodeToString[rcode])
+	}
+
+	bad := []dns.RR{
+		&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeANY, Class: dns.ClassINET}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeA, Class: dns.ClassANY, Ttl: 300}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeAXFR, Class: dns.ClassANY}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeANY, Class: dns.ClassNONE}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: "a.example.org.", Rrtype: dns.TypeA, Class: dns.ClassCHAOS}},
+	}
+	for i, rr := range bad {
+		if rcode := prescan("example.org.", []dns.RR{rr}); rcode != dns.RcodeFormatError {
+			t.Errorf("Test %d: expected FORMERR, got %s", i, dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestPrerequisites(t *testing.T) {
+	zone := newTestRRs(t,
+		"example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 60",
+		"www.example.org. 300 IN A 192.0.2.1",
+		"www.example.org. 300 IN A 192.0.2.2",
+	)
+	www := newTestRRs(t, "www.example.org. 60 IN A 192.0.2.1", "www.example.org. 60 IN A 192.0.2.2")
+	mail := newTestRRs(t, "mail.example.org. 60 IN A 192.0.2.1")
+
+	tests := []struct {
+		prereq func(m *dns.Msg)
+		rcode  int
+	}{
+		{func(m *dns.Msg) { m.NameUsed(www) }, dns.RcodeSuccess},
+		{func(m *dns.Msg) { m.NameUsed(mail) }, dns.RcodeNameError},
+		{func(m *dns.Msg) { m.NameNotUsed(mail) }, dns.RcodeSuccess},
+		{func(m *dns.Msg) { m.NameNotUsed(www) }, dns.RcodeYXDomain},
+		{func(m *dns.Msg) { m.RRsetUsed(www) }, dns.RcodeSuccess},
+		{func(m *dns.Msg) { m.RRsetUsed(mail) }, dns.RcodeNXRrset},
+		{func(m *dns.Msg) { m.RRsetNotUsed(mail) }, dns.RcodeSuccess},
+		{func(m *dns.Msg) { m.RRsetNotUsed(www) }, dns.RcodeYXRrset},
+		{func(m *dns.Msg) { m.Used(www) }, dns.RcodeSuccess},
+		{func(m *dns.Msg) { m.Used(www[:1]) }, dns.RcodeNXRrset},
+		{func(m *dns.Msg) { m.Used(mail) }, dns.RcodeNXRrset},
+		{func(m *dns.Msg) { m.NameUsed(newTestRRs(t, "www.example.net. 60 IN A 192.0.2.1")) }, dns.RcodeNotZone},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		tc.prereq(m)
+		if rcode := prerequisites("example.org.", m.Answer, zone); rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	zone := newTestRRs(t,
+		"example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 60",
+		"example.org. 300 IN NS ns1.example.org.",
+		"www.example.org. 300 IN A 192.0.2.1",
+		"www.example.org. 300 IN A 192.0.2.2",
+		"www.example.org. 300 IN TXT \"hello\"",
+		"alias.example.org. 300 IN CNAME www.example.org.",
+	)
+
+	tests := []struct {
+		update   func(m *dns.Msg)
+		expected []string // Records added by the update, starting with the new SOA record.
+		deleted  int
+	}{
+		{func(m *dns.Msg) {}, nil, 0},
+		{
+			func(m *dns.Msg) { m.Insert(newTestRRs(t, "mail.example.org. 300 IN A 192.0.2.3")) },
+			[]string{"serial 2", "mail.example.org.\t300\tIN\tA\t192.0.2.3"}, 1,
+		},
+		// Already there.
+		{func(m *dns.Msg) { m.Insert(newTestRRs(t, "www.example.org. 300 IN A 192.0.2.1")) }, nil, 0},
+		// New TTL.
+		{
+			func(m *dns.Msg) { m.Insert(newTestRRs(t, "www.example.org. 60 IN A 192.0.2.1")) },
+			[]string{"serial 2", "www.example.org.\t60\tIN\tA\t192.0.2.1"}, 2,
+		},
+		// CNAME and other data.
+		{func(m *dns.Msg) { m.Insert(newTestRRs(t, "alias.example.org. 300 IN A 192.0.2.1")) }, nil, 0},
+		{func(m *dns.Msg) { m.Insert(newTestRRs(t, "www.example.org. 300 IN CNAME example.org.")) }, nil, 0},
+		{
+			func(m *dns.Msg) { m.Insert(newTestRRs(t, "alias.example.org. 300 IN CNAME example.org.")) },
+			[]string{"serial 2", "alias.example.org.\t300\tIN\tCNAME\texample.org."}, 2,
+		},
+		{func(m *dns.Msg) { m.RemoveRRset(newTestRRs(t, "www.example.org. 300 IN A 192.0.2.1")) }, []string{"serial 2"}, 3},
+		{func(m *dns.Msg) { m.RemoveName(newTestRRs(t, "www.example.org. 300 IN A 192.0.2.1")) }, []string{"serial 2"}, 4},
+		{func(m *dns.Msg) { m.Remove(newTestRRs(t, "www.example.org. 300 IN A 192.0.2.2")) }, []string{"serial 2"}, 2},
+		// The SOA and NS records at the apex stay.
+		{func(m *dns.Msg) { m.RemoveName(newTestRRs(t, "example.org. 300 IN A 192.0.2.1")) }, nil, 0},
+		{func(m *dns.Msg) { m.Remove(newTestRRs(t, "example.org. 300 IN NS ns1.example.org.")) }, nil, 0},
+		// A new serial replaces the increment, an older one is ignored.
+		{
+			func(m *dns.Msg) {
+				m.Insert(newTestRRs(t, "example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. 10 7200 3600 1209600 60"))
+			},
+			[]string{"serial 10"}, 1,
+		},
+		{
+			func(m *dns.Msg) {
+				m.Insert(newTestRRs(t, "example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. 0 7200 3600 1209600 60"))
+			},
+			nil, 0,
+		},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		tc.update(m)
+
+		want := apply("example.org.", zone, m.Ns)
+		if del, add := diff(zone, want); len(del) == 0 && len(add) == 0 {
+			if tc.expected != nil {
+				t.Errorf("Test %d: expected changes, got none", i)
+			}
+			continue
+		}
+		del, add := diff(zone, bumpSerial("example.org.", zone, want))
+		if len(del) != tc.deleted {
+			t.Errorf("Test %d: expected %d deleted records, got %d", i, tc.deleted, len(del))
+		}
+		if len(add) != len(tc.expected) {
+			t.Errorf("Test %d: expected %d added records, got %d: %v", i, len(tc.expected), len(add), add)
+			continue
+		}
+		for j, rr := range add {
+			if soa, ok := rr.(*dns.SOA); ok {
+				if got := "serial " + strconv.FormatUint(uint64(soa.Serial), 10); got != tc.expected[j] {
+					t.Errorf("Test %d: expected %s, got %s", i, tc.expected[j], got)
+				}
+				continue
+			}
+			if rr.String() != tc.expected[j] {
+				t.Errorf("Test %d: expected %s, got %s", i, tc.expected[j], rr)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package fdns
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coredns/coredns/plugin/file/tree"
+	"github.com/coredns/coredns/plugin/transfer"
+
+	"github.com/miekg/dns"
+)
+
+// errNoHistory is returned when the changes table does not hold all versions of a zone that are
+// needed for an incremental transfer.
+var errNoHistory = errors.New("no complete change history")
+
+// errSigned is returned for transfers of signed zones. These are signed on-the-fly with NSEC black
+// lies, which leaves no NSEC chain or signatures to transfer.
+var errSigned = errors.New("transfer of a signed zone is not supported")
+
+// Transfer implements the transfer.Transferer interface.
+func (b FDNSBackend) Transfer(zone string, serial uint32) (<-chan []dns.RR, error) {
+	z := b.Zones.Zones(zone)
+	if z == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	soa := z.SOA()
+	if soa == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+	// A secondary would get an unsigned copy of a zone that is served signed.
+	if z.Signed() {
+		return nil, errSigned
+	}
+
+	var incr []dns.RR
+	if serial != 0 && serial < soa.Serial && b.Zones.pool != nil {
+		var err error
+		if incr, err = b.Zones.ixfr(context.Background(), soa, serial); err != nil {
+			errorCount.WithLabelValues(zone, opIxfr).Inc()
+			log.Warningf("Incremental transfer of %s from serial %d failed, falling back to full transfer: %s", zone, serial, err)
+		}
+	}
+	return z.Transfer(serial, incr)
+}
+
+// Transfer transfers z in the returned channel. When incr is not nil, it holds an incremental
+// transfer from serial that is sent instead of the full zone.
+func (z *Zone) Transfer(serial uint32, incr []dns.RR) (<-chan []dns.RR, error) {
+	soa := z.SOA()
+	if soa == nil {
+		return nil, transfer.ErrNotAuthoritative
+	}
+
+	ch := make(chan []dns.RR)
+	go func() {
+		defer close(ch)
+
+		if serial != 0 && serial >= soa.Serial { // ixfr fallback, only send SOA
+			ch <- []dns.RR{soa}
+			return
+		}
+		if incr != nil {
+			ch <- incr
+			return
+		}
+
+		ch <- []dns.RR{soa}
+		z.Walk(func(e *tree.Elem, rrsets map[uint16][]dns.RR) error {
+			for t, rrs := range rrsets {
+				if t != dns.TypeSOA {
+					ch <- rrs
+				}
+			}
+			return nil
+		})
+		ch <- []dns.RR{soa}
+	}()
+
+	return ch, nil
+}
+
+// SOA returns the SOA record of z, or nil when it has none.
+func (z *Zone) SOA() *dns.SOA {
+	apex, found := z.Search(z.origin)
+	if !found {
+		return nil
+	}
+	if soa := apex.Type(dns.TypeSOA); len(soa) > 0 {
+		return soa[0].(*dns.SOA)
+	}
+	return nil
+}
+
+// change is a row from the changes table: a single record that was deleted or added in the
+// version of the zone with SOA serial Serial.
+type change struct {
+	Serial uint32
+	Delete bool
+	RR     dns.RR
+}
+
+// ixfr reads the changes to the zone of soa since serial from the changes table and returns them
+// in the format of an IXFR response, see ixfr.
+func (z *Zones) ixfr(ctx context.Context, soa *dns.SOA, serial uint32) ([]dns.RR, error) {
+	defer observe(opIxfr, time.Now())
+	ctx, cancel := z.withTimeout(ctx)
+	defer cancel()
+
+	origin := soa.Header().Name
+	rows, err := z.pool.Query(ctx, stmtChanges, origin, int64(serial))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []change
+	for rows.Next() {
+		var (
+			s                      int64
+			op, name, typ, content string
+			ttl                    int32
+		)
+		if err := rows.Scan(&s, &op, &name, &typ, &ttl, &content); err != nil {
+			return nil, err
+		}
+		rr, err := newRR(origin, name, typ, uint32(ttl), content)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change{Serial: uint32(s), Delete: op == "del", RR: rr})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ixfr(soa, serial, changes)
+}
+
+// ixfr turns changes, ordered by the time they were made, into the records of an IXFR response
+// (RFC 1995) that brings a secondary from serial to the serial of soa. Every version must change
+// the SOA record, as the deleted and added SOA records delimit the differences sequences.
+func ixfr(soa *dns.SOA, serial uint32, changes []change) ([]dns.RR, error) {
+	// Skip the changes that made the versions up to and including serial.
+	for len(changes) > 0 && changes[0].Serial <= serial {
+		changes = changes[1:]
+	}
+
+	rrs := []dns.RR{soa}
+	from := serial
+	for len(changes) > 0 {
+		version := changes[0].Serial
+		var (
+			oldSOA, newSOA *dns.SOA
+			del, add       []dns.RR
+		)
+		for len(changes) > 0 && changes[0].Serial == version {
+			c := changes[0]
+			changes = changes[1:]
+
+			if s, ok := c.RR.(*dns.SOA); ok {
+				if c.Delete {
+					oldSOA = s
+				} else {
+					newSOA = s
+				}
+				continue
+			}
+			if c.Delete {
+				del = append(del, c.RR)
+			} else {
+				add = append(add, c.RR)
+			}
+		}
+
+		if oldSOA == nil || newSOA == nil || oldSOA.Serial != from || newSOA.Serial != version {
+			return nil, errNoHistory
+		}
+		rrs = append(rrs, oldSOA)
+		rrs = append(rrs, del...)
+		rrs = append(rrs, newSOA)
+		rrs = append(rrs, add...)
+		from = version
+	}
+
+	if from != soa.Serial {
+		return nil, errNoHistory
+	}
+	return append(rrs, soa), nil
+}
@@ -0,0 +1,98 @@
+package fdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTransferAXFR(t *testing.T) {
+	z := newTestZone(t)
+	ch, err := z.Transfer(0, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	var rrs []dns.RR
+	for r := range ch {
+		rrs = append(rrs, r...)
+	}
+	// All 14 records of the zone, with the SOA record sent twice.
+	if len(rrs) != 15 {
+		t.Fatalf("Expected %d records, got %d", 15, len(rrs))
+	}
+	if rrs[0].Header().Rrtype != dns.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("Expected transfer to start and end with the SOA record")
+	}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			t.Errorf("Expected only two SOA records in transfer")
+		}
+	}
+}
+
+func TestTransferUpToDate(t *testing.T) {
+	z := newTestZone(t)
+	ch, _ := z.Transfer(2022060100, nil)
+
+	var rrs []dns.RR
+	for r := range ch {
+		rrs = append(rrs, r...)
+	}
+	if len(rrs) != 1 || rrs[0].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("Expected a single SOA record, got %v", rrs)
+	}
+}
+
+func TestIxfr(t *testing.T) {
+	soa := func(serial string) dns.RR {
+		rr, _ := dns.NewRR("example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. " + serial + " 7200 3600 1209600 60")
+		return rr
+	}
+	a := func(s string) dns.RR { rr, _ := dns.NewRR(s); return rr }
+
+	changes := []change{
+		{Serial: 1, Delete: true, RR: soa("0")},
+		{Serial: 1, RR: soa("1")},
+		{Serial: 2, Delete: true, RR: a("a.example.org. 300 IN A 192.0.2.1")},
+		{Serial: 2, Delete: true, RR: soa("1")},
+		{Serial: 2, RR: soa("2")},
+		{Serial: 2, RR: a("a.example.org. 300 IN A 192.0.2.2")},
+		{Serial: 3, Delete: true, RR: soa("2")},
+		{Serial: 3, RR: soa("3")},
+		{Serial: 3, RR: a("b.example.org. 300 IN A 192.0.2.3")},
+	}
+	current := soa("3").(*dns.SOA)
+
+	rrs, err := ixfr(current, 1, changes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	expected := []string{
+		"SOA 3", "SOA 1", "A 192.0.2.1", "SOA 2", "A 192.0.2.2", "SOA 2", "SOA 3", "A 192.0.2.3", "SOA 3",
+	}
+	if len(rrs) != len(expected) {
+		t.Fatalf("Expected %d records, got %d: %v", len(expected), len(rrs), rrs)
+	}
+	for i, rr := range rrs {
+		var got string
+		switch x := rr.(type) {
+		case *dns.SOA:
+			got = "SOA " + string(rune('0'+x.Serial))
+		case *dns.A:
+			got = "A " + x.A.String()
+		}
+		if got != expected[i] {
+			t.Errorf("Record %d: expected %s, got %s", i, expected[i], got)
+		}
+	}
+
+	// The changes that made version 1 are gone, so there is no history from 0.
+	if _, err := ixfr(current, 0, changes[2:]); err == nil {
+		t.Errorf("Expected error for incomplete history")
+	}
+	// Changes made after the last SOA change are not part of a version.
+	if _, err := ixfr(soa("4").(*dns.SOA), 1, changes); err == nil {
+		t.Errorf("Expected error when history does not reach the current serial")
+	}
+}
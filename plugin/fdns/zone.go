@@ -0,0 +1,384 @@
+package fdns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/file/tree"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/transfer"
+	"github.com/coredns/coredns/request"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/miekg/dns"
+)
+
+// defaultSigCap is the capacity of the signature cache.
+const defaultSigCap = 10000
+
+// Zone is a zone loaded from the records table. A Zone is never modified after it has been
+// loaded, changes in the database result in a new Zone replacing the old one in Zones.
+type Zone struct {
+	origin string
+	*tree.Tree
+
+	keys   []*dnssec.DNSKEY // Keys from the keys table, when not empty the zone is signed.
+	signer *dnssec.Dnssec
+}
+
+// NewZone returns a new, empty zone.
+func NewZone(origin string) *Zone {
+	return &Zone{origin: dns.Fqdn(strings.ToLower(origin)), Tree: &tree.Tree{}}
+}
+
+// Origin returns the origin of z.
+func (z *Zone) Origin() string { return z.origin }
+
+// AddKey adds k to z and inserts its DNSKEY record at the apex.
+func (z *Zone) AddKey(k *dnssec.DNSKEY) {
+	z.keys = append(z.keys, k)
+	z.Insert(k.K)
+}
+
+// Signed returns true if z has keys to sign responses with.
+func (z *Zone) Signed() bool { return z.signer != nil }
+
+// Sign signs the response in m using the zone's keys, see dnssec.Dnssec.Sign.
+func (z *Zone) Sign(m *dns.Msg, server string) *dns.Msg {
+	// The sections share their backing arrays with the zone's tree, copy them before
+	// signatures get appended.
+	m.Answer = append([]dns.RR(nil), m.Answer...)
+	m.Ns = append([]dns.RR(nil), m.Ns...)
+	m.Extra = append([]dns.RR(nil), m.Extra...)
+
+	state := request.Request{Req: m, Zone: z.origin}
+	return z.signer.Sign(state, time.Now().UTC(), server)
+}
+
+// sign sets up signing for z with the keys added to it, signatures are cached in c.
+func (z *Zone) sign(c *cache.Cache) {
+	if len(z.keys) == 0 {
+		return
+	}
+	d := dnssec.New([]string{z.origin}, z.keys, dnssec.SplitKeys(z.keys), nil, c)
+	z.signer = &d
+}
+
+// Zones maps zone names to a *Zone. This keeps track of what zones we have loaded at
+// any one time.
+type Zones struct {
+	Z     map[string]*Zone // A map mapping zone (origin) to the Zone's data.
+	names []string         // All the keys from the map Z as a string slice.
+
+	pool     *pgxpool.Pool
+	timeout  time.Duration      // Timeout for loading the zones, zero means no timeout.
+	sigCache *cache.Cache       // Signature cache shared by all signed zones.
+	transfer *transfer.Transfer // Sends notifies when the serial of a zone changes.
+	debug    bool               // Log every reload at the info level.
+
+	sync.RWMutex
+}
+
+// Names returns the names from z.
+func (z *Zones) Names() []string {
+	z.RLock()
+	n := z.names
+	z.RUnlock()
+	return n
+}
+
+// Zones returns a zone with origin name from z, nil when not found.
+func (z *Zones) Zones(name string) *Zone {
+	z.RLock()
+	zo := z.Z[name]
+	z.RUnlock()
+	return zo
+}
+
+// Add adds zo to z, replacing any zone with the same origin.
+func (z *Zones) Add(zo *Zone) {
+	z.Lock()
+	defer z.Unlock()
+
+	if z.Z == nil {
+		z.Z = make(map[string]*Zone)
+	}
+	if _, ok := z.Z[zo.origin]; !ok {
+		z.names = append(z.names, zo.origin)
+	}
+	z.Z[zo.origin] = zo
+}
+
+// Remove removes the zone named name from z.
+func (z *Zones) Remove(name string) {
+	z.Lock()
+	defer z.Unlock()
+
+	delete(z.Z, name)
+	names := make([]string, 0, len(z.names))
+	for _, n := range z.names {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	z.names = names
+}
+
+// signatureCache returns the signature cache of z, creating it when needed.
+func (z *Zones) signatureCache() *cache.Cache {
+	z.Lock()
+	defer z.Unlock()
+	if z.sigCache == nil {
+		z.sigCache = cache.New(defaultSigCap)
+	}
+	return z.sigCache
+}
+
+// NewZones returns a new Zones that loads zones from the database in pool. The pool must have the
+// queries of a Schema prepared on its connections, by using Schema.Prepare as its AfterConnect hook.
+func NewZones(pool *pgxpool.Pool, timeout time.Duration) *Zones {
+	return &Zones{pool: pool, timeout: timeout}
+}
+
+// withTimeout returns ctx with the timeout of z applied.
+func (z *Zones) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if z.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, z.timeout)
+}
+
+// loadZone reads all records for origin from the database into a new Zone. Names is the list of all
+// zones, the records of the zones below origin are left out, see cut.
+func (zs *Zones) loadZone(ctx context.Context, origin string, names []string) (*Zone, error) {
+	defer observe(opLoad, time.Now())
+	z := NewZone(origin)
+
+	rows, err := zs.pool.Query(ctx, stmtRecords, z.origin, "."+z.origin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rrs []dns.RR
+	for rows.Next() {
+		var (
+			name, content, typ string
+			ttl                int32
+		)
+		if err := rows.Scan(&name, &content, &typ, &ttl); err != nil {
+			return nil, err
+		}
+		rr, err := newRR(z.origin, strings.ToLower(name), typ, uint32(ttl), content)
+		if err != nil {
+			log.Warningf("Skipping record in %s: %s", z.origin, err)
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, rr := range cut(z.origin, rrs, names) {
+		z.Insert(rr)
+	}
+
+	keys, err := zs.pool.Query(ctx, stmtKeys, z.origin)
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Close()
+
+	for keys.Next() {
+		var pub, priv string
+		if err := keys.Scan(&pub, &priv); err != nil {
+			return nil, err
+		}
+		k, err := dnssec.ParseKey(pub, priv)
+		if err != nil {
+			log.Warningf("Skipping key for %s: %s", z.origin, err)
+			continue
+		}
+		if strings.ToLower(k.K.Header().Name) != z.origin {
+			log.Warningf("Skipping key for %s: owner is %s", z.origin, k.K.Header().Name)
+			continue
+		}
+		z.AddKey(k)
+	}
+	return z, keys.Err()
+}
+
+// cut returns the records in rrs that belong to zone origin. The records table holds the records of
+// all zones, so rrs also has the records of the zones in names that are below origin. These are left
+// out, except for the NS records at the apex of the highest of them, which delegate it, their glue,
+// and the records of the parent side of the cut, see parentSide. The parent side records at the apex
+// of origin itself are left out for the same reason.
+func cut(origin string, rrs []dns.RR, names []string) []dns.RR {
+	var below []string
+	for _, n := range names {
+		if n != origin && dns.IsSubDomain(origin, n) {
+			below = append(below, n)
+		}
+	}
+	// child returns the highest zone below origin that name is in, or an empty string.
+	child := func(name string) string {
+		c := ""
+		for _, n := range below {
+			if dns.IsSubDomain(n, name) && (c == "" || len(n) < len(c)) {
+				c = n
+			}
+		}
+		return c
+	}
+
+	var (
+		own  []dns.RR
+		glue = map[string]bool{}
+	)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		switch c := child(hdr.Name); {
+		case c == "":
+			if hdr.Name != origin || !parentSide(rr) {
+				own = append(own, rr)
+			}
+		case c == hdr.Name && hdr.Rrtype == dns.TypeNS:
+			own = append(own, rr)
+			glue[strings.ToLower(rr.(*dns.NS).Ns)] = true
+		case c == hdr.Name && parentSide(rr):
+			own = append(own, rr)
+		}
+	}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if (hdr.Rrtype == dns.TypeA || hdr.Rrtype == dns.TypeAAAA) && glue[hdr.Name] && child(hdr.Name) != "" {
+			own = append(own, rr)
+		}
+	}
+	return own
+}
+
+// parentSide returns true if rr, owned by the apex of a zone, belongs to the parent zone: the DS
+// records, and the NSEC record of the parent and the signatures the parent has over them (RFC 4035,
+// section 2.4).
+func parentSide(rr dns.RR) bool {
+	switch rr := rr.(type) {
+	case *dns.DS:
+		return true
+	case *dns.NSEC:
+		// The NSEC record of the parent at a cut has no SOA in its bitmap, the one of the child has.
+		for _, t := range rr.TypeBitMap {
+			if t == dns.TypeSOA {
+				return false
+			}
+		}
+		return true
+	case *dns.RRSIG:
+		// The parent signs with the keys of its own apex, the child with the keys of this one.
+		return rr.TypeCovered == dns.TypeDS || (rr.TypeCovered == dns.TypeNSEC && !strings.EqualFold(rr.SignerName, rr.Hdr.Name))
+	}
+	return false
+}
+
+// zoneNames returns the origins of all zones listed in the zones table.
+func (z *Zones) zoneNames(ctx context.Context) ([]string, error) {
+	rows, err := z.pool.Query(ctx, stmtZones)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, dns.Fqdn(strings.ToLower(name)))
+	}
+	return names, rows.Err()
+}
+
+// Load (re)loads every zone listed in the zones table and drops zones that are no longer listed.
+func (z *Zones) Load(ctx context.Context) error {
+	ctx, cancel := z.withTimeout(ctx)
+	defer cancel()
+
+	names, err := z.zoneNames(ctx)
+	if err != nil {
+		errorCount.WithLabelValues("", opLoad).Inc()
+		return err
+	}
+	return z.sync(ctx, names, func(string) bool { return true })
+}
+
+// Refresh reloads the zones that name belongs to. Name is the owner name of a changed record or
+// the origin of a changed zone. Zones added to or removed from the zones table are picked up too.
+func (z *Zones) Refresh(ctx context.Context, name string) error {
+	ctx, cancel := z.withTimeout(ctx)
+	defer cancel()
+
+	names, err := z.zoneNames(ctx)
+	if err != nil {
+		errorCount.WithLabelValues("", opLoad).Inc()
+		return err
+	}
+	name = dns.Fqdn(strings.ToLower(name))
+	return z.sync(ctx, names, func(origin string) bool {
+		return dns.IsSubDomain(origin, name) || z.Zones(origin) == nil
+	})
+}
+
+// sync makes the zones in z match names, loading each zone for which reload returns true. A zone that
+// fails to load keeps its current version, the other zones are still loaded.
+func (z *Zones) sync(ctx context.Context, names []string, reload func(string) bool) error {
+	listed := make(map[string]struct{}, len(names))
+	var errs []string
+	for _, origin := range names {
+		listed[origin] = struct{}{}
+		if !reload(origin) {
+			continue
+		}
+		zo, err := z.loadZone(ctx, origin, names)
+		if err != nil {
+			errorCount.WithLabelValues(origin, opLoad).Inc()
+			errs = append(errs, origin+": "+err.Error())
+			continue
+		}
+		zo.sign(z.signatureCache())
+		debugf(z.debug, "Loaded %s with %d names", origin, zo.Len())
+
+		old := z.Zones(origin)
+		z.Add(zo)
+		if old != nil && !zo.Signed() && serialChanged(old, zo) {
+			go func(origin string) {
+				if err := z.transfer.Notify(origin); err != nil {
+					log.Errorf("Failed to send notifies for %s: %s", origin, err)
+				}
+			}(origin)
+		}
+	}
+
+	for _, origin := range z.Names() {
+		if _, ok := listed[origin]; !ok {
+			z.Remove(origin)
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to load zones: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// serialChanged returns true when the SOA serials of a and b differ.
+func serialChanged(a, b *Zone) bool {
+	sa, sb := a.SOA(), b.SOA()
+	if sa == nil || sb == nil {
+		return sa != sb
+	}
+	return sa.Serial != sb.Serial
+}
@@ -0,0 +1,250 @@
+package fdns
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/dnssec"
+	"github.com/coredns/coredns/plugin/pkg/cache"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+func TestZonesAddRemove(t *testing.T) {
+	z := &Zones{}
+	z.Add(NewZone("example.org."))
+	z.Add(NewZone("example.net"))
+	z.Add(NewZone("Example.ORG."))
+
+	if names := z.Names(); !reflect.DeepEqual(names, []string{"example.org.", "example.net."}) {
+		t.Errorf("Expected two zones, got %v", names)
+	}
+	if z.Zones("example.net.") == nil {
+		t.Errorf("Expected to find example.net.")
+	}
+
+	z.Remove("example.org.")
+	if names := z.Names(); !reflect.DeepEqual(names, []string{"example.net."}) {
+		t.Errorf("Expected only example.net., got %v", names)
+	}
+	if z.Zones("example.org.") != nil {
+		t.Errorf("Expected example.org. to be removed")
+	}
+}
+
+func TestZoneSign(t *testing.T) {
+	z := newTestZone(t)
+	k, err := dnssec.ParseKey(pubKey, privKey)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %s", err)
+	}
+	z.AddKey(k)
+	z.sign(cache.New(defaultSigCap))
+
+	zones := &Zones{}
+	zones.Add(z)
+	b := FDNSBackend{Zones: zones}
+
+	if _, err := b.Transfer("example.org.", 0); err != errSigned {
+		t.Errorf("Expected the transfer of a signed zone to be refused, got %v", err)
+	}
+
+	tests := []struct {
+		qname string
+		qtype uint16
+		do    bool
+		rcode int
+		sigs  int // RRSIGs in the answer and authority section
+	}{
+		{"web.example.org.", dns.TypeA, true, dns.RcodeSuccess, 2},
+		{"web.example.org.", dns.TypeA, false, dns.RcodeSuccess, 0},
+		{"example.org.", dns.TypeDNSKEY, true, dns.RcodeSuccess, 2},
+		{"nope.example.org.", dns.TypeA, true, dns.RcodeSuccess, 2}, // black lies turn NXDOMAIN into NODATA
+		{"nope.example.org.", dns.TypeA, false, dns.RcodeNameError, 0},
+	}
+
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		if tc.do {
+			m.SetEdns0(4096, true)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		b.ServeDNS(context.TODO(), rec, m)
+
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.rcode, rec.Msg.Rcode)
+		}
+		sigs := 0
+		for _, rr := range append(rec.Msg.Answer, rec.Msg.Ns...) {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				sigs++
+			}
+		}
+		if sigs != tc.sigs {
+			t.Errorf("Test %d: expected %d signatures, got %d", i, tc.sigs, sigs)
+		}
+	}
+
+	// Signing must not leak into the zone data.
+	if elem, _ := z.Search("web.example.org."); len(elem.Type(dns.TypeRRSIG)) != 0 {
+		t.Errorf("Expected no signatures in the zone")
+	}
+}
+
+const (
+	pubKey  = `example.org. IN DNSKEY 257 3 13 tVRWNSGpHZbCi7Pr7OmbADVUO3MxJ0Lb8Lk3o/HBHqCxf5K/J50lFqRa 98lkdAIiFOVRy8LyMvjwmxZKwB5MNw==`
+	privKey = `Private-key-format: v1.3
+Algorithm: 13 (ECDSAP256SHA256)
+PrivateKey: i8j4OfDGT8CQt24SDwLz2hg9yx4qKOEOh1LvbAuSp1c=
+Created: 20160423211746
+Publish: 20160423211746
+Activate: 20160423211746
+`
+)
+
+func TestCut(t *testing.T) {
+	var rrs []dns.RR
+	for _, s := range []string{
+		"example.org. 3600 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600",
+		"example.org. 3600 IN NS ns1.example.org.",
+		"ns1.example.org. 3600 IN A 192.0.2.1",
+		"www.example.org. 3600 IN A 192.0.2.2",
+		// The child zone, served from the same table.
+		"sub.example.org. 3600 IN SOA ns1.sub.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600",
+		"sub.example.org. 3600 IN NS ns1.sub.example.org.",
+		"ns1.sub.example.org. 3600 IN A 192.0.2.3",
+		"www.sub.example.org. 3600 IN A 192.0.2.4",
+		"sub.example.org. 3600 IN MX 10 mail.sub.example.org.",
+		// A zone below the child.
+		"deep.sub.example.org. 3600 IN NS ns1.deep.sub.example.org.",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	names := []string{"example.org.", "sub.example.org.", "deep.sub.example.org.", "example.net."}
+
+	parent := NewZone("example.org.")
+	for _, rr := range cut("example.org.", rrs, names) {
+		parent.Insert(rr)
+	}
+	child := NewZone("sub.example.org.")
+	for _, rr := range cut("sub.example.org.", rrs, names) {
+		child.Insert(rr)
+	}
+
+	ch, _ := parent.Transfer(0, nil)
+	var axfr []string
+	for r := range ch {
+		for _, rr := range r {
+			axfr = append(axfr, rr.Header().Name+" "+dns.TypeToString[rr.Header().Rrtype])
+		}
+	}
+	// The parent has its own records and the delegation to the child with its glue.
+	if len(axfr) != 7 {
+		t.Errorf("Expected 7 records in the transfer of the parent, got %d: %v", len(axfr), axfr)
+	}
+	for _, rr := range axfr {
+		if rr == "www.sub.example.org. A" || rr == "sub.example.org. SOA" || rr == "sub.example.org. MX" || rr == "deep.sub.example.org. NS" {
+			t.Errorf("Expected no records of the child zone in the parent, got %s", rr)
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.sub.example.org.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: m}
+	if _, _, _, result := parent.Lookup(context.TODO(), state, state.Name()); result != Delegation {
+		t.Errorf("Expected a referral to the child from the parent, got %d", result)
+	}
+	if answer, _, _, result := child.Lookup(context.TODO(), state, state.Name()); result != Success || len(answer) != 1 {
+		t.Errorf("Expected an answer from the child, got %d with %v", result, answer)
+	}
+	if elem, _ := child.Search("deep.sub.example.org."); elem == nil || len(elem.Type(dns.TypeNS)) != 1 {
+		t.Errorf("Expected the delegation to the zone below the child in the child")
+	}
+}
+
+func TestCutDS(t *testing.T) {
+	var rrs []dns.RR
+	for _, s := range []string{
+		"example.org. 3600 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600",
+		"example.org. 3600 IN NS ns1.example.org.",
+		"ns1.example.org. 3600 IN A 192.0.2.1",
+		"sub.example.org. 3600 IN SOA ns1.sub.example.org. hostmaster.example.org. 1 7200 3600 1209600 3600",
+		"sub.example.org. 3600 IN NS ns1.sub.example.org.",
+		"ns1.sub.example.org. 3600 IN A 192.0.2.3",
+		// The parent side of the cut.
+		"sub.example.org. 3600 IN DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF",
+		"sub.example.org. 3600 IN RRSIG DS 13 3 3600 20300101000000 20200101000000 54321 example.org. c2lnbmF0dXJl",
+		"sub.example.org. 3600 IN NSEC www.example.org. NS DS RRSIG NSEC",
+		"sub.example.org. 3600 IN RRSIG NSEC 13 3 3600 20300101000000 20200101000000 54321 example.org. c2lnbmF0dXJl",
+		// The child side of the cut.
+		"sub.example.org. 3600 IN NSEC ns1.sub.example.org. NS SOA RRSIG NSEC DNSKEY",
+		"sub.example.org. 3600 IN RRSIG NSEC 13 3 3600 20300101000000 20200101000000 12345 sub.example.org. c2lnbmF0dXJl",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	names := []string{"example.org.", "sub.example.org."}
+
+	parent := NewZone("example.org.")
+	for _, rr := range cut("example.org.", rrs, names) {
+		parent.Insert(rr)
+	}
+	child := NewZone("sub.example.org.")
+	for _, rr := range cut("sub.example.org.", rrs, names) {
+		child.Insert(rr)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("sub.example.org.", dns.TypeDS)
+	state := request.Request{W: &test.ResponseWriter{}, Req: m}
+	if answer, _, _, result := parent.Lookup(context.TODO(), state, state.Name()); result != Success || len(answer) != 1 || answer[0].Header().Rrtype != dns.TypeDS {
+		t.Errorf("Expected the DS record from the parent, got %d with %v", result, answer)
+	}
+	if answer, _, _, result := child.Lookup(context.TODO(), state, state.Name()); result != NoData {
+		t.Errorf("Expected no DS record in the child, got %d with %v", result, answer)
+	}
+
+	tests := []struct {
+		zone     *Zone
+		signer   string // signer of the signatures over NSEC at the cut
+		soaInMap bool   // the NSEC record at the cut has SOA in its bitmap
+	}{
+		{parent, "example.org.", false},
+		{child, "sub.example.org.", true},
+	}
+	for i, tc := range tests {
+		elem, _ := tc.zone.Search("sub.example.org.")
+		if elem == nil {
+			t.Fatalf("Test %d: expected records at the cut", i)
+		}
+		nsec := elem.Type(dns.TypeNSEC)
+		if len(nsec) != 1 {
+			t.Fatalf("Test %d: expected 1 NSEC record at the cut, got %v", i, nsec)
+		}
+		soa := false
+		for _, typ := range nsec[0].(*dns.NSEC).TypeBitMap {
+			soa = soa || typ == dns.TypeSOA
+		}
+		if soa != tc.soaInMap {
+			t.Errorf("Test %d: expected the NSEC record of the other side of the cut, got %s", i, nsec[0])
+		}
+		for _, rr := range elem.Type(dns.TypeRRSIG) {
+			sig := rr.(*dns.RRSIG)
+			if sig.SignerName != tc.signer {
+				t.Errorf("Test %d: expected only signatures by %s, got %s", i, tc.signer, sig)
+			}
+		}
+	}
+}
@@ -7,7 +7,9 @@ package forward
 import (
 	"context"
 	"io"
+	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -75,6 +77,39 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options) (*dns.Msg, error) {
 	start := time.Now()
 
+	var (
+		ret *dns.Msg
+		err error
+	)
+	if p.doh != nil {
+		ret, err = p.doh.Exchange(ctx, state.Req)
+	} else {
+		ret, err = p.exchange(ctx, state, opts)
+	}
+	if err != nil {
+		// A query we cancelled ourselves, or a stale connection, says nothing about the upstream.
+		if err != ErrCachedClosed && ctx.Err() == nil {
+			p.observe(0, err)
+		}
+		return ret, err
+	}
+	p.observe(time.Since(start), nil)
+
+	rc, ok := dns.RcodeToString[ret.Rcode]
+	if !ok {
+		rc = strconv.Itoa(ret.Rcode)
+	}
+
+	RequestCount.WithLabelValues(p.addr).Add(1)
+	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
+	RequestDuration.WithLabelValues(p.addr, rc).Observe(time.Since(start).Seconds())
+
+	return ret, nil
+}
+
+// exchange sends the request over a, possibly cached, connection and waits for a response. It gives up when
+// ctx is done, the connection is not reused then.
+func (p *Proxy) exchange(ctx context.Context, state request.Request, opts options) (*dns.Msg, error) {
 	proto := ""
 	switch {
 	case opts.forceTCP: // TCP flag has precedence over UDP flag
@@ -96,7 +131,10 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 		pc.c.UDPSize = 512
 	}
 
-	pc.c.SetWriteDeadline(time.Now().Add(maxTimeout))
+	stop := cancelOnDone(ctx, pc.c)
+	defer stop()
+
+	pc.c.SetWriteDeadline(deadline(ctx, maxTimeout))
 	// records the origin Id before upstream.
 	originId := state.Req.Id
 	state.Req.Id = dns.Id()
@@ -106,6 +144,9 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 
 	if err := pc.c.WriteMsg(state.Req); err != nil {
 		pc.c.Close() // not giving it back
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err == io.EOF && cached {
 			return nil, ErrCachedClosed
 		}
@@ -113,11 +154,14 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 	}
 
 	var ret *dns.Msg
-	pc.c.SetReadDeadline(time.Now().Add(readTimeout))
+	pc.c.SetReadDeadline(deadline(ctx, readTimeout))
 	for {
 		ret, err = pc.c.ReadMsg()
 		if err != nil {
 			pc.c.Close() // not giving it back
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			if err == io.EOF && cached {
 				return nil, ErrCachedClosed
 			}
@@ -135,18 +179,41 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts options
 	// recovery the origin Id after upstream.
 	ret.Id = originId
 
+	stop()
 	p.transport.Yield(pc)
 
-	rc, ok := dns.RcodeToString[ret.Rcode]
-	if !ok {
-		rc = strconv.Itoa(ret.Rcode)
-	}
+	return ret, nil
+}
 
-	RequestCount.WithLabelValues(p.addr).Add(1)
-	RcodeCount.WithLabelValues(rc, p.addr).Add(1)
-	RequestDuration.WithLabelValues(p.addr, rc).Observe(time.Since(start).Seconds())
+// deadline returns the time d from now, or the deadline of ctx when that is earlier.
+func deadline(ctx context.Context, d time.Duration) time.Time {
+	t := time.Now().Add(d)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(t) {
+		return dl
+	}
+	return t
+}
 
-	return ret, nil
+// cancelOnDone unblocks the reads and writes on c when ctx is done, by moving its deadline to now. The
+// returned function stops this, it must be called before c is reused, and can be called more than once.
+func cancelOnDone(ctx context.Context, c net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stopc, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-stopc:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopc) })
+		<-done
+	}
 }
 
 const cumulativeAvgWeight = 4
@@ -0,0 +1,157 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+)
+
+// dohTransport sends queries to a DNS-over-HTTPS endpoint. Its connections are kept open and
+// reused, and HTTP/2 is used when the endpoint supports it, so many queries share a connection.
+type dohTransport struct {
+	url    string // URL of the endpoint.
+	method string // GET or POST.
+
+	client    *http.Client
+	transport *http.Transport
+}
+
+func newDoHTransport(endpoint string) *dohTransport {
+	tr := &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: maxDialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: maxDialTimeout,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     defaultExpire,
+	}
+	return &dohTransport{
+		url:       endpoint,
+		method:    http.MethodPost,
+		client:    &http.Client{Transport: tr},
+		transport: tr,
+	}
+}
+
+// SetTLSConfig sets the TLS config of the connections.
+func (t *dohTransport) SetTLSConfig(cfg *tls.Config) { t.transport.TLSClientConfig = cfg.Clone() }
+
+// SetExpire sets the time an idle connection is kept open.
+func (t *dohTransport) SetExpire(expire time.Duration) { t.transport.IdleConnTimeout = expire }
+
+// Exchange sends m to the endpoint and returns the response.
+func (t *dohTransport) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	// Use ID 0 as RFC 8484 recommends, so GET responses can be cached by HTTP caches.
+	id := m.Id
+	m.Id = 0
+	req, err := doh.NewEndpointRequest(t.method, t.url, m)
+	m.Id = id
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body) // Drain the body, so the connection can be reused.
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status from %s: %s", t.url, resp.Status)
+	}
+	ct := resp.Header.Get("content-type")
+	if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != doh.MimeType {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content type from %s: %q", t.url, ct)
+	}
+
+	ret, err := doh.ResponseToMsg(resp)
+	if err != nil {
+		return nil, err
+	}
+	ret.Id = id
+	return ret, nil
+}
+
+// Stop closes the idle connections.
+func (t *dohTransport) Stop() { t.transport.CloseIdleConnections() }
+
+// dohURL returns the URL of the DoH endpoint in s, like https://dns.example.org/dns-query. The port
+// defaults to 443 and the path to /dns-query.
+func dohURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != transport.HTTPS || u.Host == "" {
+		return nil, fmt.Errorf("not a DoH endpoint: %q", s)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("DoH endpoint can only have a host and a path: %q", s)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = doh.Path
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), transport.HTTPSPort)
+	}
+	return u, nil
+}
+
+// dohHc is a health checker for a DoH endpoint, it sends the health check queries over the
+// connections of the proxy.
+type dohHc struct {
+	recursionDesired bool
+	domain           string
+}
+
+func (h *dohHc) SetTLSConfig(cfg *tls.Config) {}
+func (h *dohHc) SetTCPTransport()             {}
+
+func (h *dohHc) SetRecursionDesired(recursionDesired bool) {
+	h.recursionDesired = recursionDesired
+}
+func (h *dohHc) GetRecursionDesired() bool {
+	return h.recursionDesired
+}
+
+func (h *dohHc) SetDomain(domain string) {
+	h.domain = domain
+}
+func (h *dohHc) GetDomain() string {
+	return h.domain
+}
+
+// Check is used as the up.Func in the up.Probe. Like for DNS, any response is healthy.
+func (h *dohHc) Check(p *Proxy) error {
+	ping := new(dns.Msg)
+	ping.SetQuestion(h.domain, dns.TypeNS)
+	ping.MsgHdr.RecursionDesired = h.recursionDesired
+
+	ctx, cancel := context.WithTimeout(context.Background(), hcReadTimeout+hcWriteTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := p.doh.Exchange(ctx, ping)
+	p.observe(time.Since(start), err)
+	if err != nil {
+		HealthcheckFailureCount.WithLabelValues(p.addr).Add(1)
+		atomic.AddUint32(&p.fails, 1)
+		return err
+	}
+
+	atomic.StoreUint32(&p.fails, 0)
+	return nil
+}
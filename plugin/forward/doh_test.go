@@ -0,0 +1,179 @@
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/doh"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// newDoHServer returns a DoH server that answers every query with an A record, and counts the
+// connections made to it in conns.
+func newDoHServer(conns *int32) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "HTTP/2 expected", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		m, err := doh.RequestToMsg(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.Id != 0 {
+			http.Error(w, "ID 0 expected", http.StatusBadRequest)
+			return
+		}
+		ret := new(dns.Msg)
+		ret.SetReply(m)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		buf, _ := ret.Pack()
+		w.Header().Set("content-type", doh.MimeType)
+		w.Write(buf)
+	}))
+	s.EnableHTTP2 = true
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	s.StartTLS()
+	return s
+}
+
+func newDoHProxy(t *testing.T, s *httptest.Server) *Proxy {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	p, err := NewDoHProxy(s.URL + "/dns-query")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	p.SetTLSConfig(&tls.Config{RootCAs: pool})
+	return p
+}
+
+func TestDoH(t *testing.T) {
+	var conns int32
+	s := newDoHServer(&conns)
+	defer s.Close()
+
+	for _, method := range []string{http.MethodPost, http.MethodGet} {
+		p := newDoHProxy(t, s)
+		p.doh.method = method
+		p.start(hcInterval)
+
+		for i := 0; i < 3; i++ {
+			m := new(dns.Msg)
+			m.SetQuestion("example.org.", dns.TypeA)
+			req := request.Request{Req: m, W: &test.ResponseWriter{}}
+			resp, err := p.Connect(context.Background(), req, options{})
+			if err != nil {
+				t.Fatalf("Expected no error with %s, got %s", method, err)
+			}
+			if resp.Id != m.Id || len(resp.Answer) != 1 {
+				t.Errorf("Expected an answer with the ID of the query, got %v", resp)
+			}
+		}
+		p.stop()
+	}
+
+	// The queries of a proxy share a connection.
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Errorf("Expected 2 connections, got %d", n)
+	}
+}
+
+func TestDoHContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expectedErr bool
+	}{
+		{"application/dns-message", false},
+		{"application/dns-message; charset=utf-8", false},
+		{"Application/DNS-Message", false},
+		{"text/html", true},
+		{"", true},
+	}
+	for i, tc := range tests {
+		s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m, _ := doh.RequestToMsg(r)
+			ret := new(dns.Msg)
+			ret.SetReply(m)
+			buf, _ := ret.Pack()
+			w.Header().Set("content-type", tc.contentType)
+			w.Write(buf)
+		}))
+		p := newDoHProxy(t, s)
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		_, err := p.doh.Exchange(context.TODO(), m)
+		if tc.expectedErr && err == nil {
+			t.Errorf("Test %d: expected an error for content type %q", i, tc.contentType)
+		}
+		if !tc.expectedErr && err != nil {
+			t.Errorf("Test %d: expected no error for content type %q, got %s", i, tc.contentType, err)
+		}
+		p.stop()
+		s.Close()
+	}
+}
+
+func TestNewDoHProxy(t *testing.T) {
+	for _, endpoint := range []string{"https://", "https://user@dns.example.org/dns-query", "dns.example.org"} {
+		if _, err := NewDoHProxy(endpoint); err == nil {
+			t.Errorf("Expected an error for %q", endpoint)
+		}
+	}
+}
+
+func TestDoHForward(t *testing.T) {
+	var conns int32
+	s := newDoHServer(&conns)
+	defer s.Close()
+
+	f := New()
+	f.SetProxy(newDoHProxy(t, s))
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatal("Expected to receive reply, but didn't")
+	}
+	if x := rec.Msg.Answer[0].Header().Name; x != "example.org." {
+		t.Errorf("Expected %s, got %s", "example.org.", x)
+	}
+}
+
+func TestDoHHealth(t *testing.T) {
+	var conns int32
+	s := newDoHServer(&conns)
+	defer s.Close()
+
+	p := newDoHProxy(t, s)
+	defer p.stop()
+	if err := p.health.Check(p); err != nil {
+		t.Errorf("Expected a healthy upstream, got %s", err)
+	}
+
+	s.Close()
+	if err := p.health.Check(p); err == nil {
+		t.Error("Expected an unhealthy upstream")
+	}
+	if p.fails != 1 {
+		t.Errorf("Expected 1 failure, got %d", p.fails)
+	}
+}
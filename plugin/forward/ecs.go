@@ -0,0 +1,119 @@
+package forward
+
+import (
+	"context"
+	"net"
+
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// ecs holds the settings of the ecs property, what to do with the EDNS0 client subnet option of queries.
+type ecs struct {
+	mode   string
+	v4, v6 uint8 // Source prefix lengths of the client subnets that are added.
+}
+
+// These are the modes of the ecs property.
+const (
+	ecsAdd     = "add"     // Add the client subnet when the query has none.
+	ecsRewrite = "rewrite" // Replace the client subnet of the query by the client's.
+	ecsStrip   = "strip"   // Remove the client subnet from the query.
+)
+
+// apply changes the client subnet option of the query in state. When it changed it, it returns a request
+// with a copy of the query, and a response writer that changes the reply back to what the client expects.
+func (e *ecs) apply(ctx context.Context, state request.Request) request.Request {
+	orig := edns.Subnet(state.Req)
+
+	var sub *dns.EDNS0_SUBNET
+	switch e.mode {
+	case ecsAdd:
+		if orig != nil {
+			return state
+		}
+		fallthrough
+	case ecsRewrite:
+		if sub = edns.NewSubnet(net.ParseIP(state.IP()), e.v4, e.v6); sub == nil {
+			return state
+		}
+	case ecsStrip:
+		if orig == nil {
+			return state
+		}
+	}
+
+	opt := state.Req.IsEdns0() != nil
+	w := &ecsResponseWriter{ResponseWriter: state.W, scope: edns.SubnetScopeFromContext(ctx), orig: orig, opt: opt}
+	size := state.Size()
+	state = request.Request{W: w, Req: state.Req.Copy()}
+	if !opt {
+		state.Req.SetEdns0(uint16(size), false)
+	}
+	setSubnet(state.Req.IsEdns0(), sub)
+	return state
+}
+
+// setSubnet replaces the client subnet option in o with sub, or removes it when sub is nil.
+func setSubnet(o *dns.OPT, sub *dns.EDNS0_SUBNET) {
+	options := o.Option[:0]
+	for _, s := range o.Option {
+		if _, ok := s.(*dns.EDNS0_SUBNET); !ok {
+			options = append(options, s)
+		}
+	}
+	if sub != nil {
+		options = append(options, sub)
+	}
+	o.Option = options
+}
+
+// ecsResponseWriter changes the client subnet option of a reply back to the one of the client's query,
+// with the scope of the reply, and removes the OPT record if the client did not send one.
+type ecsResponseWriter struct {
+	dns.ResponseWriter
+	scope *edns.SubnetScope // When not nil, set to the scope of the reply.
+	orig  *dns.EDNS0_SUBNET // The client subnet option of the client's query.
+	opt   bool              // True if the client's query had an OPT record.
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *ecsResponseWriter) WriteMsg(res *dns.Msg) error {
+	var scope uint8
+	if sub := edns.Subnet(res); sub != nil {
+		scope = sub.SourceScope
+	}
+	if w.scope != nil {
+		w.scope.Set, w.scope.Prefix = true, scope
+	}
+
+	if !w.opt {
+		extra := res.Extra[:0]
+		for _, rr := range res.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		res.Extra = extra
+		return w.ResponseWriter.WriteMsg(res)
+	}
+
+	o := res.IsEdns0()
+	if o == nil {
+		return w.ResponseWriter.WriteMsg(res)
+	}
+	var sub *dns.EDNS0_SUBNET
+	if w.orig != nil {
+		sub = new(dns.EDNS0_SUBNET)
+		*sub = *w.orig
+		// The scope can't be longer than the source prefix length of the client.
+		sub.SourceScope = scope
+		if scope > sub.SourceNetmask {
+			sub.SourceScope = sub.SourceNetmask
+		}
+	}
+	setSubnet(o, sub)
+	return w.ResponseWriter.WriteMsg(res)
+}
@@ -0,0 +1,153 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/edns"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupECS(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    ecs
+		expectedErr string
+	}{
+		// positive
+		{"forward . 127.0.0.1 {\necs add\n}\n", false, ecs{ecsAdd, 24, 56}, ""},
+		{"forward . 127.0.0.1 {\necs rewrite 16\n}\n", false, ecs{ecsRewrite, 16, 56}, ""},
+		{"forward . 127.0.0.1 {\necs add 0 48\n}\n", false, ecs{ecsAdd, 0, 48}, ""},
+		{"forward . 127.0.0.1 {\necs strip\n}\n", false, ecs{ecsStrip, 24, 56}, ""},
+		// negative
+		{"forward . 127.0.0.1 {\necs\n}\n", true, ecs{}, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\necs replace\n}\n", true, ecs{}, "unknown ecs mode 'replace'"},
+		{"forward . 127.0.0.1 {\necs strip 24\n}\n", true, ecs{}, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\necs add 33\n}\n", true, ecs{}, "invalid IPv4 source prefix length"},
+		{"forward . 127.0.0.1 {\necs add 24 129\n}\n", true, ecs{}, "invalid IPv6 source prefix length"},
+		{"forward . 127.0.0.1 {\necs add 24 56 1\n}\n", true, ecs{}, "Wrong argument count"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if *f.ecs != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, *f.ecs)
+		}
+	}
+}
+
+func TestECS(t *testing.T) {
+	// The upstream answers with the subnet it got, with a scope of 16.
+	var (
+		mu  sync.Mutex
+		got *dns.EDNS0_SUBNET
+	)
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		sub := edns.Subnet(r)
+		mu.Lock()
+		got = sub
+		mu.Unlock()
+		if r.IsEdns0() != nil {
+			ret.SetEdns0(4096, false)
+		}
+		if sub != nil {
+			sub.SourceScope = 16
+			ret.IsEdns0().Option = append(ret.IsEdns0().Option, sub)
+		}
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	client := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: net.ParseIP("198.51.100.7").To4()}
+	tests := []struct {
+		mode     string
+		opt      bool              // query has an OPT record
+		sub      *dns.EDNS0_SUBNET // subnet in the query
+		upstream string            // subnet the upstream gets
+		reply    string            // subnet in the reply, "-" for no OPT record
+		scope    edns.SubnetScope
+	}{
+		{"add", false, nil, "10.240.0.0/24", "-", edns.SubnetScope{Set: true, Prefix: 16}},
+		{"add", true, nil, "10.240.0.0/24", "", edns.SubnetScope{Set: true, Prefix: 16}},
+		{"add", true, client, "198.51.100.7/32", "198.51.100.7/32/16", edns.SubnetScope{}},
+		{"rewrite", true, client, "10.240.0.0/24", "198.51.100.7/32/16", edns.SubnetScope{Set: true, Prefix: 16}},
+		{"strip", true, client, "", "198.51.100.7/32/0", edns.SubnetScope{Set: true, Prefix: 0}},
+		{"strip", false, nil, "", "-", edns.SubnetScope{}},
+	}
+
+	for i, tc := range tests {
+		f := newParallelForward(t, "forward . "+s.Addr+" {\necs "+tc.mode+"\n}")
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		if tc.opt {
+			m.SetEdns0(4096, false)
+			if tc.sub != nil {
+				sub := *tc.sub
+				m.IsEdns0().Option = append(m.IsEdns0().Option, &sub)
+			}
+		}
+		ctx, scope := edns.WithSubnetScope(context.TODO())
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := f.ServeDNS(ctx, rec, m); err != nil {
+			t.Fatalf("Test %d: expected to receive reply, but got %s", i, err)
+		}
+		f.OnShutdown()
+
+		mu.Lock()
+		if x := subnetString(got, false); x != tc.upstream {
+			t.Errorf("Test %d: expected the upstream to get subnet %q, got %q", i, tc.upstream, x)
+		}
+		mu.Unlock()
+		reply := "-"
+		if rec.Msg.IsEdns0() != nil {
+			reply = subnetString(edns.Subnet(rec.Msg), true)
+		}
+		if reply != tc.reply {
+			t.Errorf("Test %d: expected subnet %q in the reply, got %q", i, tc.reply, reply)
+		}
+		if *scope != tc.scope {
+			t.Errorf("Test %d: expected scope %+v, got %+v", i, tc.scope, *scope)
+		}
+		if x := subnetString(edns.Subnet(m), false); x != subnetString(tc.sub, false) {
+			t.Errorf("Test %d: expected the query to be unchanged, got subnet %q", i, x)
+		}
+	}
+}
+
+// subnetString returns e as ADDRESS/SOURCE, and with /SCOPE added when scope is true.
+func subnetString(e *dns.EDNS0_SUBNET, scope bool) string {
+	if e == nil {
+		return ""
+	}
+	if scope {
+		return fmt.Sprintf("%s/%d/%d", e.Address, e.SourceNetmask, e.SourceScope)
+	}
+	return fmt.Sprintf("%s/%d", e.Address, e.SourceNetmask)
+}
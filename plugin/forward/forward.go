@@ -8,6 +8,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"net/http"
 	"sync/atomic"
 	"time"
 
@@ -36,12 +37,17 @@ type Forward struct {
 
 	from    string
 	ignored []string
+	routes  *routes // Upstreams for zones below from, see route.go.
 
 	tlsConfig     *tls.Config
 	tlsServerName string
+	dohMethod     string // HTTP method of the queries to DoH upstreams.
 	maxfails      uint32
 	expire        time.Duration
 	maxConcurrent int64
+	ecs           *ecs          // What to do with the EDNS0 client subnet option of queries.
+	parallel      int           // Number of upstreams a query is sent to at the same time.
+	stagger       time.Duration // Delay before the query is sent to the next upstream, when parallel.
 
 	opts options // also here for testing
 
@@ -56,7 +62,7 @@ type Forward struct {
 
 // New returns a new Forward.
 func New() *Forward {
-	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, p: new(random), from: ".", hcInterval: hcInterval, opts: options{forceTCP: false, preferUDP: false, hcRecursionDesired: true, hcDomain: "."}}
+	f := &Forward{maxfails: 2, tlsConfig: new(tls.Config), expire: defaultExpire, dohMethod: http.MethodPost, p: new(random), from: ".", hcInterval: hcInterval, opts: options{forceTCP: false, preferUDP: false, hcRecursionDesired: true, hcDomain: "."}}
 	return f
 }
 
@@ -89,12 +95,29 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		}
 	}
 
+	proxies, policy := f.proxies, f.p
+	if f.routes != nil {
+		if rt := f.routes.match(state.Name()); rt != nil {
+			if len(rt.proxies) == 0 {
+				return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+			}
+			proxies, policy = rt.proxies, rt.p
+		}
+	}
+
+	if f.ecs != nil {
+		state = f.ecs.apply(ctx, state)
+		w = state.W
+	}
+
+	if f.parallel > 1 {
+		return f.serveParallel(ctx, w, state, proxies, policy)
+	}
+
 	fails := 0
-	var span, child ot.Span
 	var upstreamErr error
-	span = ot.SpanFromContext(ctx)
 	i := 0
-	list := f.List()
+	list := policy.List(proxies)
 	deadline := time.Now().Add(defaultTimeout)
 	start := time.Now()
 	for time.Now().Before(deadline) {
@@ -108,62 +131,27 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		i++
 		if proxy.Down(f.maxfails) {
 			fails++
-			if fails < len(f.proxies) {
+			if fails < len(proxies) {
 				continue
 			}
 			// All upstream proxies are dead, assume healthcheck is completely broken and randomly
 			// select an upstream to connect to.
 			r := new(random)
-			proxy = r.List(f.proxies)[0]
+			proxy = r.List(proxies)[0]
 
 			HealthcheckBrokenCount.Add(1)
 		}
 
-		if span != nil {
-			child = span.Tracer().StartSpan("connect", ot.ChildOf(span.Context()))
-			otext.PeerAddress.Set(child, proxy.addr)
-			ctx = ot.ContextWithSpan(ctx, child)
-		}
-
 		metadata.SetValueFunc(ctx, "forward/upstream", func() string {
 			return proxy.addr
 		})
 
-		var (
-			ret *dns.Msg
-			err error
-		)
-		opts := f.opts
-		for {
-			ret, err = proxy.Connect(ctx, state, opts)
-			if err == ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
-				continue
-			}
-			// Retry with TCP if truncated and prefer_udp configured.
-			if ret != nil && ret.Truncated && !opts.forceTCP && opts.preferUDP {
-				opts.forceTCP = true
-				continue
-			}
-			break
-		}
-
-		if child != nil {
-			child.Finish()
-		}
-
-		if f.tapPlugin != nil {
-			toDnstap(f, proxy.addr, state, opts, ret, start)
-		}
+		ret, err := f.connect(ctx, proxy, state, start)
 
 		upstreamErr = err
 
 		if err != nil {
-			// Kick off health check to see if *our* upstream is broken.
-			if f.maxfails != 0 {
-				proxy.Healthcheck()
-			}
-
-			if fails < len(f.proxies) {
+			if fails < len(proxies) {
 				continue
 			}
 			break
@@ -190,6 +178,49 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	return dns.RcodeServerFailure, ErrNoHealthy
 }
 
+// connect sends the query in state to proxy, retrying over TCP when prefer_udp is set and the reply
+// is truncated. If it fails, a health check of proxy is started.
+func (f *Forward) connect(ctx context.Context, proxy *Proxy, state request.Request, start time.Time) (*dns.Msg, error) {
+	var child ot.Span
+	if span := ot.SpanFromContext(ctx); span != nil {
+		child = span.Tracer().StartSpan("connect", ot.ChildOf(span.Context()))
+		otext.PeerAddress.Set(child, proxy.addr)
+		ctx = ot.ContextWithSpan(ctx, child)
+	}
+
+	var (
+		ret *dns.Msg
+		err error
+	)
+	opts := f.opts
+	for {
+		ret, err = proxy.Connect(ctx, state, opts)
+		if err == ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
+			continue
+		}
+		// Retry with TCP if truncated and prefer_udp configured.
+		if ret != nil && ret.Truncated && !opts.forceTCP && opts.preferUDP {
+			opts.forceTCP = true
+			continue
+		}
+		break
+	}
+
+	if child != nil {
+		child.Finish()
+	}
+
+	if f.tapPlugin != nil {
+		toDnstap(f, proxy.addr, state, opts, ret, start)
+	}
+
+	// Kick off health check to see if *our* upstream is broken, unless we gave up on it ourselves.
+	if err != nil && f.maxfails != 0 && ctx.Err() == nil {
+		proxy.Healthcheck()
+	}
+	return ret, err
+}
+
 func (f *Forward) match(state request.Request) bool {
 	if !plugin.Name(f.from).Matches(state.Name()) || !f.isAllowedDomain(state.Name()) {
 		return false
@@ -21,7 +21,7 @@ type HealthChecker interface {
 	SetTCPTransport()
 }
 
-// dnsHc is a health checker for a DNS endpoint (DNS, and DoT).
+// dnsHc is a health checker for a DNS endpoint (DNS, and DoT), see dohHc for DoH.
 type dnsHc struct {
 	c                *dns.Client
 	recursionDesired bool
@@ -43,6 +43,8 @@ func NewHealthChecker(trans string, recursionDesired bool, domain string) Health
 		c.WriteTimeout = hcWriteTimeout
 
 		return &dnsHc{c: c, recursionDesired: recursionDesired, domain: domain}
+	case transport.HTTPS:
+		return &dohHc{recursionDesired: recursionDesired, domain: domain}
 	}
 
 	log.Warningf("No healthchecker for transport %q", trans)
@@ -77,7 +79,9 @@ func (h *dnsHc) SetTCPTransport() {
 
 // Check is used as the up.Func in the up.Probe.
 func (h *dnsHc) Check(p *Proxy) error {
+	start := time.Now()
 	err := h.send(p.addr)
+	p.observe(time.Since(start), err)
 	if err != nil {
 		HealthcheckFailureCount.WithLabelValues(p.addr).Add(1)
 		atomic.AddUint32(&p.fails, 1)
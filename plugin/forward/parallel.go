@@ -0,0 +1,133 @@
+package forward
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/plugin/debug"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// result is the outcome of sending a query to one upstream.
+type result struct {
+	proxy *Proxy
+	ret   *dns.Msg
+	err   error
+}
+
+// serveParallel sends the query to up to f.parallel upstreams, f.stagger apart, and writes the first
+// answer that is not a SERVFAIL. The next upstream is queried right away when one fails.
+func (f *Forward) serveParallel(ctx context.Context, w dns.ResponseWriter, state request.Request, proxies []*Proxy, policy Policy) (int, error) {
+	list := f.upstreams(proxies, policy)
+	if len(list) > f.parallel {
+		list = list[:f.parallel]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	// Every upstream gets its own copy of the query, as the exchange changes its ID.
+	results := make(chan result, len(list))
+	start := time.Now()
+	next, inflight := 0, 0
+	var stagger <-chan time.Time
+	launch := func() {
+		proxy := list[next]
+		next++
+		inflight++
+		q := request.Request{W: state.W, Req: state.Req.Copy()}
+		go func() {
+			ret, err := f.connect(ctx, proxy, q, start)
+			results <- result{proxy: proxy, ret: ret, err: err}
+		}()
+		stagger = nil
+		if next < len(list) && f.stagger > 0 {
+			stagger = time.After(f.stagger)
+		}
+	}
+
+	launch()
+	for f.stagger == 0 && next < len(list) {
+		launch()
+	}
+
+	var (
+		servfail    *result
+		upstreamErr error
+		mismatch    bool
+	)
+	for inflight > 0 {
+		select {
+		case <-stagger:
+			launch()
+			continue
+		case <-ctx.Done():
+			if upstreamErr == nil {
+				upstreamErr = ctx.Err()
+			}
+			inflight = 0
+			continue
+		case res := <-results:
+			inflight--
+			switch {
+			case res.err != nil:
+				upstreamErr = res.err
+			case !state.Match(res.ret):
+				debug.Hexdumpf(res.ret, "Wrong reply for id: %d, %s %d", res.ret.Id, state.QName(), state.QType())
+				mismatch = true
+			case res.ret.Rcode == dns.RcodeServerFailure:
+				servfail = &res
+			default:
+				f.writeParallel(ctx, w, res)
+				return 0, nil
+			}
+			if next < len(list) {
+				launch()
+			}
+		}
+	}
+
+	// No upstream gave a good answer, pass on the best we have.
+	if servfail != nil {
+		f.writeParallel(ctx, w, *servfail)
+		return 0, nil
+	}
+	if mismatch {
+		formerr := new(dns.Msg)
+		formerr.SetRcode(state.Req, dns.RcodeFormatError)
+		w.WriteMsg(formerr)
+		return 0, nil
+	}
+	if upstreamErr != nil {
+		return dns.RcodeServerFailure, upstreamErr
+	}
+	return dns.RcodeServerFailure, ErrNoHealthy
+}
+
+func (f *Forward) writeParallel(ctx context.Context, w dns.ResponseWriter, res result) {
+	metadata.SetValueFunc(ctx, "forward/upstream", func() string {
+		return res.proxy.addr
+	})
+	w.WriteMsg(res.ret)
+}
+
+// upstreams returns the healthy proxies in the order of policy. If all of them are down, it returns
+// one random proxy, like ServeDNS does.
+func (f *Forward) upstreams(proxies []*Proxy, policy Policy) []*Proxy {
+	list := policy.List(proxies)
+	healthy := make([]*Proxy, 0, len(list))
+	for _, p := range list {
+		if !p.Down(f.maxfails) {
+			healthy = append(healthy, p)
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy
+	}
+
+	HealthcheckBrokenCount.Add(1)
+	return (&random{}).List(proxies)[:1]
+}
@@ -0,0 +1,176 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// parallelServer is a UDP server that answers after delay with rcode, and counts the queries it gets. Unlike
+// dnstest.Server, it has its own handler, so several of them can answer differently.
+type parallelServer struct {
+	Addr string
+	s    *dns.Server
+}
+
+func newParallelServer(t *testing.T, delay time.Duration, rcode int, queries *int32) *parallelServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{PacketConn: pc, NotifyStartedFunc: func() { close(started) }}
+	s.Handler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(queries, 1)
+		time.Sleep(delay)
+		ret := new(dns.Msg)
+		ret.SetRcode(r, rcode)
+		if rcode == dns.RcodeSuccess {
+			ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		}
+		w.WriteMsg(ret)
+	})
+	go s.ActivateAndServe()
+	<-started
+	return &parallelServer{Addr: pc.LocalAddr().String(), s: s}
+}
+
+func (s *parallelServer) Close() { s.s.Shutdown() }
+
+func newParallelForward(t *testing.T, input string) *Forward {
+	// The health check tests lower these. Queries of earlier tests may still be running, so
+	// only write them when needed.
+	if readTimeout != 2*time.Second {
+		readTimeout = 2 * time.Second
+		defaultTimeout = 5 * time.Second
+	}
+
+	f, err := parseForward(caddy.NewTestController("dns", input))
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.OnStartup()
+	return f
+}
+
+func TestParallel(t *testing.T) {
+	var slowQ, failQ, fastQ int32
+	slow := newParallelServer(t, time.Second, dns.RcodeSuccess, &slowQ)
+	defer slow.Close()
+	fail := newParallelServer(t, 0, dns.RcodeServerFailure, &failQ)
+	defer fail.Close()
+	fast := newParallelServer(t, 50*time.Millisecond, dns.RcodeSuccess, &fastQ)
+	defer fast.Close()
+
+	f := newParallelForward(t, "forward . "+slow.Addr+" "+fail.Addr+" "+fast.Addr+" {\npolicy sequential\nparallel 3\n}")
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but got %s", err)
+	}
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 1 {
+		t.Errorf("Expected the answer of the fast upstream, got %s", rec.Msg)
+	}
+	if rec.Msg.Id != m.Id {
+		t.Errorf("Expected the ID of the query %d, got %d", m.Id, rec.Msg.Id)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the answer without waiting for the slow upstream, took %s", d)
+	}
+	if atomic.LoadInt32(&slowQ) != 1 || atomic.LoadInt32(&failQ) != 1 || atomic.LoadInt32(&fastQ) != 1 {
+		t.Errorf("Expected one query to every upstream, got %d, %d and %d", atomic.LoadInt32(&slowQ), atomic.LoadInt32(&failQ), atomic.LoadInt32(&fastQ))
+	}
+}
+
+func TestParallelStagger(t *testing.T) {
+	var firstQ, secondQ int32
+	first := newParallelServer(t, 20*time.Millisecond, dns.RcodeSuccess, &firstQ)
+	defer first.Close()
+	second := newParallelServer(t, 0, dns.RcodeSuccess, &secondQ)
+	defer second.Close()
+
+	f := newParallelForward(t, "forward . "+first.Addr+" "+second.Addr+" {\npolicy sequential\nparallel 2 500ms\n}")
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but got %s", err)
+	}
+	if atomic.LoadInt32(&firstQ) != 1 || atomic.LoadInt32(&secondQ) != 0 {
+		t.Errorf("Expected only the first upstream to be queried before the delay, got %d and %d", atomic.LoadInt32(&firstQ), atomic.LoadInt32(&secondQ))
+	}
+}
+
+func TestParallelServfail(t *testing.T) {
+	var q1, q2 int32
+	fail1 := newParallelServer(t, 0, dns.RcodeServerFailure, &q1)
+	defer fail1.Close()
+	fail2 := newParallelServer(t, 0, dns.RcodeServerFailure, &q2)
+	defer fail2.Close()
+
+	f := newParallelForward(t, "forward . "+fail1.Addr+" "+fail2.Addr+" {\nparallel 2 1s\n}")
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+
+	start := time.Now()
+	if _, err := f.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but got %s", err)
+	}
+	if rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL when all upstreams fail, got %s", dns.RcodeToString[rec.Msg.Rcode])
+	}
+	// A SERVFAIL sends the query to the next upstream without waiting for the delay.
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the next upstream to be queried right away, took %s", d)
+	}
+	if atomic.LoadInt32(&q1) != 1 || atomic.LoadInt32(&q2) != 1 {
+		t.Errorf("Expected one query to both upstreams, got %d and %d", atomic.LoadInt32(&q1), atomic.LoadInt32(&q2))
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	var slowQ int32
+	slow := newParallelServer(t, time.Second, dns.RcodeSuccess, &slowQ)
+	defer slow.Close()
+
+	f := newParallelForward(t, "forward . "+slow.Addr)
+	defer f.OnShutdown()
+	p := f.proxies[0]
+
+	// The exchange of an upstream that lost the race is cancelled, once the reply is written.
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	start := time.Now()
+	_, err := p.Connect(ctx, request.Request{W: &test.ResponseWriter{}, Req: m}, f.opts)
+	if err != context.Canceled {
+		t.Errorf("Expected %s, got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the exchange to stop when cancelled, took %s", d)
+	}
+	if atomic.LoadInt64(&p.lastErr) != 0 {
+		t.Error("Expected a cancelled exchange not to count as a failure")
+	}
+}
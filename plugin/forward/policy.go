@@ -1,6 +1,7 @@
 package forward
 
 import (
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -13,6 +14,21 @@ type Policy interface {
 	String() string
 }
 
+// newPolicy returns the policy called name, or nil if there is no such policy.
+func newPolicy(name string) Policy {
+	switch name {
+	case "random":
+		return &random{}
+	case "round_robin":
+		return &roundRobin{}
+	case "sequential":
+		return &sequential{}
+	case "fastest":
+		return &fastest{}
+	}
+	return nil
+}
+
 // random is a policy that implements random upstream selection.
 type random struct{}
 
@@ -65,4 +81,44 @@ func (r *sequential) List(p []*Proxy) []*Proxy {
 	return p
 }
 
+// fastest is a policy that selects hosts with the lowest average round trip time first. Hosts that failed
+// recently, or are failing their health checks, are put behind the others. One in fastestProbe lists starts
+// with another, random, host instead, to keep its average current.
+type fastest struct{}
+
+func (r *fastest) String() string { return "fastest" }
+
+func (r *fastest) List(p []*Proxy) []*Proxy {
+	if len(p) == 1 {
+		return p
+	}
+
+	now := time.Now()
+	failing := make(map[*Proxy]bool, len(p))
+	rtts := make(map[*Proxy]time.Duration, len(p))
+	for _, p1 := range p {
+		failing[p1] = p1.failing(now)
+		rtts[p1] = p1.rtt()
+	}
+	fast := make([]*Proxy, len(p))
+	copy(fast, p)
+	sort.SliceStable(fast, func(i, j int) bool {
+		if failing[fast[i]] != failing[fast[j]] {
+			return !failing[fast[i]]
+		}
+		return rtts[fast[i]] < rtts[fast[j]]
+	})
+
+	if rn.Int()%fastestProbe == 0 {
+		i := 1 + rn.Int()%(len(fast)-1)
+		probe := fast[i]
+		copy(fast[1:i+1], fast[:i])
+		fast[0] = probe
+	}
+	return fast
+}
+
+// fastestProbe is the inverse of the fraction of queries the fastest policy sends to another host.
+const fastestProbe = 20
+
 var rn = rand.New(time.Now().UnixNano())
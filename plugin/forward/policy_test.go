@@ -0,0 +1,85 @@
+package forward
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFastest(t *testing.T) {
+	slow, fast, failing := NewProxy("127.0.0.1:53", "dns"), NewProxy("127.0.0.2:53", "dns"), NewProxy("127.0.0.3:53", "dns")
+	for i := 0; i < 20; i++ {
+		slow.observe(200*time.Millisecond, nil)
+		fast.observe(10*time.Millisecond, nil)
+		failing.observe(time.Millisecond, nil)
+	}
+	failing.observe(0, errors.New("timeout"))
+	if fast.rtt() >= slow.rtt() {
+		t.Fatalf("Expected fast < slow, got %s and %s", fast.rtt(), slow.rtt())
+	}
+	if failing.rtt() > 2*time.Millisecond {
+		t.Fatalf("Expected a failure to be kept out of the average, got %s", failing.rtt())
+	}
+
+	p := &fastest{}
+	first := map[*Proxy]int{}
+	for i := 0; i < 2000; i++ {
+		list := p.List([]*Proxy{failing, slow, fast})
+		if len(list) != 3 {
+			t.Fatalf("Expected 3 proxies, got %d", len(list))
+		}
+		first[list[0]]++
+		if list[0] == fast && (list[1] != slow || list[2] != failing) {
+			t.Errorf("Expected the proxies ordered by round trip time and failures, got %s, %s and %s", list[0].addr, list[1].addr, list[2].addr)
+		}
+	}
+	if first[fast] < 1800 {
+		t.Errorf("Expected the fast proxy first most of the time, got %d out of 2000", first[fast])
+	}
+	if first[slow] == 0 || first[failing] == 0 {
+		t.Errorf("Expected the other proxies to be probed, got slow %d and failing %d times", first[slow], first[failing])
+	}
+}
+
+func TestFastestStartsFailing(t *testing.T) {
+	fast, slow := NewProxy("127.0.0.1:53", "dns"), NewProxy("127.0.0.2:53", "dns")
+	for i := 0; i < 10; i++ {
+		fast.observe(10*time.Millisecond, nil)
+		slow.observe(200*time.Millisecond, nil)
+	}
+	p := &fastest{}
+	firsts := func() int {
+		n := 0
+		for i := 0; i < 100; i++ {
+			if p.List([]*Proxy{fast, slow})[0] == fast {
+				n++
+			}
+		}
+		return n
+	}
+	if n := firsts(); n < 80 {
+		t.Fatalf("Expected the fast proxy first, got %d out of 100", n)
+	}
+
+	// The fastest upstream starts failing: it goes behind the slow one.
+	fast.observe(0, errors.New("timeout"))
+	if n := firsts(); n > 20 {
+		t.Errorf("Expected the failing proxy behind the slow one, got it first %d out of 100", n)
+	}
+	if fast.rtt() > 20*time.Millisecond {
+		t.Errorf("Expected the failure to be kept out of the average, got %s", fast.rtt())
+	}
+
+	// Once the failure is older than the backoff, it is the fastest again.
+	atomic.StoreInt64(&fast.lastErr, time.Now().Add(-failureBackoff).UnixNano())
+	if n := firsts(); n < 80 {
+		t.Errorf("Expected the recovered proxy first, got %d out of 100", n)
+	}
+
+	// An upstream failing its health checks goes behind, whatever its average.
+	atomic.StoreUint32(&fast.fails, 1)
+	if n := firsts(); n > 20 {
+		t.Errorf("Expected the unhealthy proxy behind the slow one, got it first %d out of 100", n)
+	}
+}
@@ -6,22 +6,28 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/coredns/coredns/plugin/pkg/transport"
 	"github.com/coredns/coredns/plugin/pkg/up"
 )
 
 // Proxy defines an upstream host.
 type Proxy struct {
+	avgRTT  int64 // atomic counters need to be first in struct for proper alignment
+	lastErr int64 // Unix time in nanoseconds of the last failed exchange.
+
 	fails uint32
 	addr  string
 
 	transport *Transport
+	doh       *dohTransport // Set when the upstream is a DoH endpoint, transport is nil then.
 
 	// health checking
 	probe  *up.Probe
 	health HealthChecker
 }
 
-// NewProxy returns a new proxy.
+// NewProxy returns a new proxy for a plain DNS or a DNS-over-TLS upstream, see NewDoHProxy for
+// a DoH upstream.
 func NewProxy(addr, trans string) *Proxy {
 	p := &Proxy{
 		addr:      addr,
@@ -34,14 +40,41 @@ func NewProxy(addr, trans string) *Proxy {
 	return p
 }
 
+// NewDoHProxy returns a new proxy for the DNS-over-HTTPS endpoint with the URL endpoint, like
+// https://dns.example.org/dns-query.
+func NewDoHProxy(endpoint string) (*Proxy, error) {
+	u, err := dohURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		addr:  u.Host,
+		fails: 0,
+		probe: up.New(),
+		doh:   newDoHTransport(u.String()),
+	}
+	p.health = NewHealthChecker(transport.HTTPS, true, ".")
+	return p, nil
+}
+
 // SetTLSConfig sets the TLS config in the lower p.transport and in the healthchecking client.
 func (p *Proxy) SetTLSConfig(cfg *tls.Config) {
-	p.transport.SetTLSConfig(cfg)
 	p.health.SetTLSConfig(cfg)
+	if p.doh != nil {
+		p.doh.SetTLSConfig(cfg)
+		return
+	}
+	p.transport.SetTLSConfig(cfg)
 }
 
 // SetExpire sets the expire duration in the lower p.transport.
-func (p *Proxy) SetExpire(expire time.Duration) { p.transport.SetExpire(expire) }
+func (p *Proxy) SetExpire(expire time.Duration) {
+	if p.doh != nil {
+		p.doh.SetExpire(expire)
+		return
+	}
+	p.transport.SetExpire(expire)
+}
 
 // Healthcheck kicks of a round of health checks for this proxy.
 func (p *Proxy) Healthcheck() {
@@ -65,18 +98,50 @@ func (p *Proxy) Down(maxfails uint32) bool {
 	return fails > maxfails
 }
 
+// observe records the round trip time of a successful exchange with the upstream in the moving average,
+// or the time of a failed one, for the fastest policy. Failures are kept out of the average.
+func (p *Proxy) observe(rtt time.Duration, err error) {
+	if err != nil {
+		atomic.StoreInt64(&p.lastErr, time.Now().UnixNano())
+		return
+	}
+	averageTimeout(&p.avgRTT, rtt, cumulativeAvgWeight)
+}
+
+// rtt returns the average round trip time of the upstream.
+func (p *Proxy) rtt() time.Duration { return time.Duration(atomic.LoadInt64(&p.avgRTT)) }
+
+// failing returns true if the upstream failed a health check, or failed an exchange less than
+// failureBackoff before now.
+func (p *Proxy) failing(now time.Time) bool {
+	if atomic.LoadUint32(&p.fails) > 0 {
+		return true
+	}
+	last := atomic.LoadInt64(&p.lastErr)
+	return last != 0 && now.UnixNano()-last < int64(failureBackoff)
+}
+
 // close stops the health checking goroutine.
-func (p *Proxy) stop()      { p.probe.Stop() }
+func (p *Proxy) stop() {
+	p.probe.Stop()
+	if p.doh != nil {
+		p.doh.Stop()
+	}
+}
 func (p *Proxy) finalizer() { p.transport.Stop() }
 
 // start starts the proxy's healthchecking.
 func (p *Proxy) start(duration time.Duration) {
 	p.probe.Start(duration)
-	p.transport.Start()
+	if p.transport != nil {
+		p.transport.Start()
+	}
 }
 
 const (
 	maxTimeout = 2 * time.Second
+	// failureBackoff is how long the fastest policy puts an upstream behind the others after it failed.
+	failureBackoff = 10 * time.Second
 )
 
 var hcInterval = 500 * time.Millisecond
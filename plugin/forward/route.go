@@ -0,0 +1,333 @@
+package forward
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+)
+
+// A route sends the queries for a zone, and the names below it, to its own upstreams. Each route has its own
+// instance of the policy of the forward plugin.
+type route struct {
+	proxies []*Proxy // When empty, the queries are not forwarded, but passed to the next plugin.
+	p       Policy
+}
+
+// routes holds the routes of the route and routes properties. The upstreams of the routes are shared
+// between them, so an upstream that is used by many routes is only health checked once.
+type routes struct {
+	sync.RWMutex
+	zones   map[string]*route
+	proxies map[string]*Proxy // The upstreams of the routes, by the upstream as returned by parseTo.
+
+	inline map[string][]string // The route properties, these take precedence over the file.
+	path   string
+	reload time.Duration
+	mtime  time.Time
+	size   int64
+	done   chan struct{}
+}
+
+// defaultTo is the upstream that stands for the upstreams of the forward plugin itself.
+const defaultTo = "#"
+
+func newRoutes() *routes {
+	return &routes{
+		zones:   map[string]*route{},
+		proxies: map[string]*Proxy{},
+		inline:  map[string][]string{},
+		reload:  5 * time.Second,
+		done:    make(chan struct{}),
+	}
+}
+
+// match returns the route for the longest zone that name is in, or nil if there is none.
+func (r *routes) match(name string) *route {
+	r.RLock()
+	defer r.RUnlock()
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if rt, ok := r.zones[name[off:]]; ok {
+			return rt
+		}
+	}
+	return r.zones["."]
+}
+
+// load reads the routes file, if it changed since it was last read, and replaces the routes with the ones
+// from the file and the route properties. Proxies for new upstreams are started when start is true,
+// and the proxies of upstreams that are no longer used are stopped.
+func (r *routes) load(f *Forward, start bool) error {
+	zones := map[string][]string{}
+	if r.path != "" {
+		var err error
+		zones, err = r.read()
+		if err == errUnchanged {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for zone, to := range r.inline {
+		zones[zone] = to
+	}
+
+	r.RLock()
+	old := r.proxies
+	r.RUnlock()
+	routes, proxies, err := f.newRoutes(zones, old)
+	if err != nil {
+		return err
+	}
+	if start {
+		for to, p := range proxies {
+			if _, ok := old[to]; !ok {
+				p.start(f.hcInterval)
+			}
+		}
+	}
+
+	r.Lock()
+	r.zones, r.proxies = routes, proxies
+	r.Unlock()
+
+	for to, p := range old {
+		if _, ok := proxies[to]; !ok {
+			p.stop()
+		}
+	}
+	if r.path != "" {
+		log.Infof("Loaded %d routes from %s", len(routes), r.path)
+	}
+	return nil
+}
+
+// read parses the routes file. It returns errUnchanged if the file did not change since the last read.
+// A file that does not exist has no routes, it can be created later.
+func (r *routes) read() (map[string][]string, error) {
+	r.RLock()
+	mtime, size := r.mtime, r.size
+	r.RUnlock()
+
+	file, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		if size == missing {
+			return nil, errUnchanged
+		}
+		log.Warningf("Routes file %s does not exist", r.path)
+		r.Lock()
+		r.mtime, r.size = time.Time{}, missing
+		r.Unlock()
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if mtime.Equal(stat.ModTime()) && size == stat.Size() {
+		return nil, errUnchanged
+	}
+	// Also when the file is invalid, so it is reported once per change.
+	r.Lock()
+	r.mtime, r.size = stat.ModTime(), stat.Size()
+	r.Unlock()
+
+	zones, err := parseRoutes(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", r.path, err)
+	}
+	return zones, nil
+}
+
+var errUnchanged = errors.New("unchanged")
+
+// missing is the size of a routes file that does not exist.
+const missing = -1
+
+// newRoutes returns the routes for zones, reusing the proxies in pool for upstreams that are already there.
+func (f *Forward) newRoutes(zones map[string][]string, pool map[string]*Proxy) (map[string]*route, map[string]*Proxy, error) {
+	routes := make(map[string]*route, len(zones))
+	proxies := map[string]*Proxy{}
+	for zone, to := range zones {
+		if len(to) > 0 && to[0] == defaultTo {
+			routes[zone] = &route{proxies: f.proxies, p: f.p}
+			continue
+		}
+		if len(to) > max {
+			return nil, nil, fmt.Errorf("more than %d TOs configured for route %s: %d", max, zone, len(to))
+		}
+		rt := &route{p: newPolicy(f.p.String())}
+		if rt.p == nil {
+			rt.p = f.p
+		}
+		for _, host := range to {
+			p, ok := proxies[host]
+			if !ok {
+				if p, ok = pool[host]; !ok {
+					var err error
+					if p, err = f.newProxy(host); err != nil {
+						return nil, nil, err
+					}
+				}
+				proxies[host] = p
+			}
+			rt.proxies = append(rt.proxies, p)
+		}
+		routes[zone] = rt
+	}
+	return routes, proxies, nil
+}
+
+// start starts the proxies of the routes and, when there is a routes file, reloading it.
+func (r *routes) start(f *Forward) {
+	r.RLock()
+	for _, p := range r.proxies {
+		p.start(f.hcInterval)
+	}
+	r.RUnlock()
+
+	if r.path == "" || r.reload == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(r.reload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-ticker.C:
+				if err := r.load(f, true); err != nil {
+					log.Errorf("Failed to reload routes, keeping the current ones: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop stops reloading the routes file and the proxies of the routes.
+func (r *routes) stop() {
+	close(r.done)
+	r.RLock()
+	defer r.RUnlock()
+	for _, p := range r.proxies {
+		p.stop()
+	}
+}
+
+// parseRoutes parses a routes file with lines in the format of dnsmasq's server and local options:
+//
+//	server=/example.org/example.net/10.0.0.1#5353
+//	server=/corp.example.org/#
+//	local=/internal.example.org/
+//
+// It returns the upstreams as returned by parseTo, by zone. A zone with defaultTo as upstream uses the
+// upstreams of the forward plugin, and a zone without upstreams is not forwarded.
+func parseRoutes(r io.Reader) (map[string][]string, error) {
+	zones := map[string][]string{}
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		option, value := line, ""
+		if j := strings.IndexByte(line, '='); j >= 0 {
+			option, value = strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		}
+		if option != "server" && option != "local" {
+			return nil, fmt.Errorf("line %d: unknown option '%s', expected server or local", i, option)
+		}
+		if !strings.HasPrefix(value, "/") || strings.Count(value, "/") < 2 {
+			return nil, fmt.Errorf("line %d: expected %s=/DOMAIN/..., got '%s'", i, option, line)
+		}
+		// The upstream is after the last slash, unless it is a URL.
+		j := strings.LastIndexByte(value, '/')
+		if k := strings.Index(value, "://"); k >= 0 {
+			j = strings.LastIndexByte(value[:k], '/')
+		}
+		domains, to := strings.Split(value[1:j], "/"), value[j+1:]
+		if option == "local" && to != "" {
+			return nil, fmt.Errorf("line %d: local can't have an upstream: '%s'", i, line)
+		}
+
+		var upstream []string
+		switch to {
+		case "":
+		case defaultTo:
+			upstream = []string{defaultTo}
+		default:
+			host, err := routeTo(to)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i, err)
+			}
+			upstream = []string{host}
+		}
+
+		for _, d := range domains {
+			zone := "."
+			if d != "#" { // dnsmasq's way of saying every domain
+				if _, ok := dns.IsDomainName(d); !ok || d == "" {
+					return nil, fmt.Errorf("line %d: not a domain name: '%s'", i, d)
+				}
+				zone = plugin.Name(d).Normalize()
+			}
+			current, ok := zones[zone]
+			if ok && kind(current) != kind(upstream) {
+				return nil, fmt.Errorf("line %d: conflicting routes for %s", i, zone)
+			}
+			if kind(upstream) == defaultTo {
+				zones[zone] = upstream
+				continue
+			}
+			zones[zone] = append(current, upstream...)
+		}
+	}
+	return zones, scanner.Err()
+}
+
+// kind returns defaultTo for the default upstreams, an empty string for no upstreams and "to" otherwise.
+func kind(to []string) string {
+	switch {
+	case len(to) == 0:
+		return ""
+	case to[0] == defaultTo:
+		return defaultTo
+	}
+	return "to"
+}
+
+// routeTo returns the upstream to, in dnsmasq's ADDRESS[#PORT] format, as returned by parseTo.
+func routeTo(to string) (string, error) {
+	if strings.Contains(to, "@") {
+		return "", fmt.Errorf("source addresses and interfaces are not supported: '%s'", to)
+	}
+	if trans, host := parse.Transport(to); trans != transport.HTTPS {
+		if j := strings.LastIndexByte(host, '#'); j >= 0 {
+			addr := strings.TrimSuffix(strings.TrimPrefix(host[:j], "["), "]")
+			to = strings.TrimSuffix(to, host) + net.JoinHostPort(addr, host[j+1:])
+		}
+	}
+	hosts, err := parseTo([]string{to})
+	if err != nil {
+		return "", err
+	}
+	return hosts[0], nil
+}
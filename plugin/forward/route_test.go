@@ -0,0 +1,224 @@
+package forward
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expected    map[string][]string
+		expectedErr string
+	}{
+		{`# corporate resolvers
+server=/corp.example.org/10.0.0.1
+server=/corp.example.org/10.0.0.2#5353
+
+server=/Example.NET/example.com/::1#53
+server=/public.corp.example.org/#
+local=/internal.example.org/
+server=/lab.example.org/`, false, map[string][]string{
+			"corp.example.org.":        {"10.0.0.1:53", "10.0.0.2:5353"},
+			"example.net.":             {"[::1]:53"},
+			"example.com.":             {"[::1]:53"},
+			"public.corp.example.org.": {defaultTo},
+			"internal.example.org.":    nil,
+			"lab.example.org.":         nil,
+		}, ""},
+		{"server=/#/tls://9.9.9.9#853", false, map[string][]string{".": {"tls://9.9.9.9:853"}}, ""},
+		{"server=/example.org/https://dns.example.org/dns-query", false, map[string][]string{"example.org.": {"https://dns.example.org:443/dns-query"}}, ""},
+		{"server=/example.org/::1", false, map[string][]string{"example.org.": {"[::1]:53"}}, ""},
+		// fails
+		{"server=10.0.0.1", true, nil, "expected server=/DOMAIN/"},
+		{"address=/example.org/10.0.0.1", true, nil, "unknown option 'address'"},
+		{"server=/example.org/dns.example.org", true, nil, "not an IP address"},
+		{"server=/example.org/10.0.0.1@eth0", true, nil, "not supported"},
+		{"local=/example.org/10.0.0.1", true, nil, "local can't have an upstream"},
+		{"server=//10.0.0.1", true, nil, "not a domain name"},
+		{"server=/example.org/10.0.0.1\nlocal=/example.org/", true, nil, "line 2: conflicting routes"},
+		{"server=/example.org/#\nserver=/example.org/10.0.0.1", true, nil, "line 2: conflicting routes"},
+	}
+
+	for i, test := range tests {
+		zones, err := parseRoutes(strings.NewReader(test.input))
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(zones, test.expected) {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, zones)
+		}
+	}
+}
+
+func TestSetupRoute(t *testing.T) {
+	routes := filepath.Join(t.TempDir(), "routes")
+	if err := os.WriteFile(routes, []byte("server=/example.net/10.0.0.3\nserver=/example.org/10.0.0.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", `forward . 127.0.0.1 {
+		policy round_robin
+		route example.org 10.0.0.1 tls://10.0.0.2
+		routes `+routes+` 10s
+	}`)
+	f, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if f.routes.reload != 10*time.Second || len(f.routes.zones) != 2 || len(f.routes.proxies) != 3 {
+		t.Fatalf("Expected 2 routes with 3 upstreams reloaded every 10s, got %d routes with %d upstreams every %s", len(f.routes.zones), len(f.routes.proxies), f.routes.reload)
+	}
+	rt := f.routes.match("www.example.org.")
+	if rt == nil || len(rt.proxies) != 2 || rt.proxies[1].addr != "10.0.0.2:853" {
+		t.Fatalf("Expected the route property to take precedence over the routes file, got %v", rt)
+	}
+	if rt.p.String() != "round_robin" || rt.p == f.p {
+		t.Errorf("Expected a round_robin policy of the route's own, got %s", rt.p)
+	}
+	if f.routes.match("example.com.") != nil {
+		t.Errorf("Expected no route for example.com.")
+	}
+
+	// A routes file that does not exist yet has no routes.
+	c = caddy.NewTestController("dns", "forward . 127.0.0.1 {\nroutes "+routes+".new\n}")
+	if f, err = parseForward(c); err != nil {
+		t.Fatalf("Expected no error for a routes file that does not exist, got %s", err)
+	}
+	if len(f.routes.zones) != 0 || f.routes.size != missing {
+		t.Errorf("Expected no routes, got %d", len(f.routes.zones))
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"forward . 127.0.0.1 {\nroute example.org\n}\n", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nroute example.org example.net\n}\n", "not an IP address or file"},
+		{"forward example.org 127.0.0.1 {\nroute example.net 10.0.0.1\n}\n", "route example.net. is not in example.org."},
+		{"forward . 127.0.0.1 {\nroute example.org grpc://10.0.0.1\n}\n", "'grpc' is not supported"},
+		{"forward . 127.0.0.1 {\nroutes\n}\n", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nroutes " + t.TempDir() + "\n}\n", "is a directory"},
+		{"forward . 127.0.0.1 {\nroutes " + routes + " -1s\n}\n", "negative"},
+		{"forward . 127.0.0.1 {\nroutes " + routes + "\nroutes " + routes + "\n}\n", "only be used once"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		if _, err := parseForward(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	var defQ, corpQ int32
+	def := newParallelServer(t, 0, dns.RcodeSuccess, &defQ)
+	defer def.Close()
+	corp := newParallelServer(t, 0, dns.RcodeSuccess, &corpQ)
+	defer corp.Close()
+
+	routes := filepath.Join(t.TempDir(), "routes")
+	if err := os.WriteFile(routes, []byte("server=/public.corp.example.org/#\nlocal=/internal.example.org/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := newParallelForward(t, "forward . "+def.Addr+" {\nroute corp.example.org "+corp.Addr+"\nroutes "+routes+"\n}")
+	defer f.OnShutdown()
+	f.Next = test.ErrorHandler()
+
+	tests := []struct {
+		qname string
+		corp  int32 // queries to corp after this one
+		def   int32 // queries to def after this one
+		rcode int
+	}{
+		{"www.corp.example.org.", 1, 0, dns.RcodeSuccess},
+		{"www.public.corp.example.org.", 1, 1, dns.RcodeSuccess},
+		{"example.net.", 1, 2, dns.RcodeSuccess},
+		{"www.internal.example.org.", 1, 2, dns.RcodeServerFailure}, // from the next plugin
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		f.ServeDNS(context.TODO(), rec, m)
+		if rec.Msg == nil || rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %d, got %v", i, tc.rcode, rec.Msg)
+		}
+		if c, d := atomic.LoadInt32(&corpQ), atomic.LoadInt32(&defQ); c != tc.corp || d != tc.def {
+			t.Errorf("Test %d: expected %d queries to corp and %d to default, got %d and %d", i, tc.corp, tc.def, c, d)
+		}
+	}
+}
+
+func TestRouteReload(t *testing.T) {
+	var oldQ, newQ int32
+	old := newParallelServer(t, 0, dns.RcodeSuccess, &oldQ)
+	defer old.Close()
+	changed := newParallelServer(t, 0, dns.RcodeSuccess, &newQ)
+	defer changed.Close()
+
+	routes := filepath.Join(t.TempDir(), "routes")
+	if err := os.WriteFile(routes, []byte("server=/example.org/"+strings.Replace(old.Addr, ":", "#", 1)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := newParallelForward(t, "forward . 127.0.0.1 {\nroutes "+routes+" 10ms\n}")
+	defer f.OnShutdown()
+
+	query := func() {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		f.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	}
+	query()
+	if atomic.LoadInt32(&oldQ) != 1 {
+		t.Fatalf("Expected a query to the upstream in the routes file, got %d", atomic.LoadInt32(&oldQ))
+	}
+
+	// An invalid file keeps the current routes.
+	if err := os.WriteFile(routes, []byte("server=/example.org/not-an-address\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	query()
+	if atomic.LoadInt32(&oldQ) != 2 {
+		t.Fatalf("Expected the routes to be kept after an invalid change, got %d queries", atomic.LoadInt32(&oldQ))
+	}
+
+	if err := os.WriteFile(routes, []byte("server=/example.org/"+strings.Replace(changed.Addr, ":", "#", 1)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	query()
+	if atomic.LoadInt32(&newQ) != 1 {
+		t.Fatalf("Expected a query to the upstream in the changed routes file, got %d", atomic.LoadInt32(&newQ))
+	}
+	f.routes.RLock()
+	_, ok := f.routes.proxies[old.Addr]
+	f.routes.RUnlock()
+	if ok {
+		t.Errorf("Expected the proxy of the removed upstream to be gone")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
@@ -56,6 +58,9 @@ func (f *Forward) OnStartup() (err error) {
 	for _, p := range f.proxies {
 		p.start(f.hcInterval)
 	}
+	if f.routes != nil {
+		f.routes.start(f)
+	}
 	return nil
 }
 
@@ -64,6 +69,9 @@ func (f *Forward) OnShutdown() error {
 	for _, p := range f.proxies {
 		p.stop()
 	}
+	if f.routes != nil {
+		f.routes.stop()
+	}
 	return nil
 }
 
@@ -108,24 +116,11 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		return f, c.ArgErr()
 	}
 
-	toHosts, err := parse.HostPortOrFile(to...)
+	toHosts, err := parseTo(to)
 	if err != nil {
 		return f, err
 	}
 
-	transports := make([]string, len(toHosts))
-	allowedTrans := map[string]bool{"dns": true, "tls": true}
-	for i, host := range toHosts {
-		trans, h := parse.Transport(host)
-
-		if !allowedTrans[trans] {
-			return f, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
-		}
-		p := NewProxy(h, trans)
-		f.proxies = append(f.proxies, p)
-		transports[i] = trans
-	}
-
 	for c.NextBlock() {
 		if err := parseBlock(c, f); err != nil {
 			return f, err
@@ -138,25 +133,83 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
 	// in upcoming connections to the same TLS server.
-	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
+	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(toHosts))
 
-	for i := range f.proxies {
-		// Only set this for proxies that need it.
-		if transports[i] == transport.TLS {
-			f.proxies[i].SetTLSConfig(f.tlsConfig)
+	for _, host := range toHosts {
+		p, err := f.newProxy(host)
+		if err != nil {
+			return f, err
 		}
-		f.proxies[i].SetExpire(f.expire)
-		f.proxies[i].health.SetRecursionDesired(f.opts.hcRecursionDesired)
-		// when TLS is used, checks are set to tcp-tls
-		if f.opts.forceTCP && transports[i] != transport.TLS {
-			f.proxies[i].health.SetTCPTransport()
+		f.proxies = append(f.proxies, p)
+	}
+
+	if f.routes != nil {
+		if err := f.routes.load(f, false); err != nil {
+			return f, err
 		}
-		f.proxies[i].health.SetDomain(f.opts.hcDomain)
 	}
 
 	return f, nil
 }
 
+// parseTo parses the upstreams in to, and returns them with their transport and port. A resolv.conf
+// like file is replaced by its nameservers.
+func parseTo(to []string) ([]string, error) {
+	var toHosts []string
+	for _, host := range to {
+		// A DoH endpoint is a URL, with a host name that is resolved when connecting.
+		if trans, _ := parse.Transport(host); trans == transport.HTTPS {
+			u, err := dohURL(host)
+			if err != nil {
+				return nil, err
+			}
+			toHosts = append(toHosts, u.String())
+			continue
+		}
+		hosts, err := parse.HostPortOrFile(host)
+		if err != nil {
+			return nil, err
+		}
+		toHosts = append(toHosts, hosts...)
+	}
+	return toHosts, nil
+}
+
+// newProxy returns a proxy for the upstream host, as returned by parseTo, configured with the
+// properties of f.
+func (f *Forward) newProxy(host string) (*Proxy, error) {
+	allowedTrans := map[string]bool{"dns": true, "tls": true, "https": true}
+	trans, h := parse.Transport(host)
+	if !allowedTrans[trans] {
+		return nil, fmt.Errorf("'%s' is not supported as a destination protocol in forward: %s", trans, host)
+	}
+	var p *Proxy
+	if trans == transport.HTTPS {
+		var err error
+		if p, err = NewDoHProxy(host); err != nil {
+			return nil, err
+		}
+	} else {
+		p = NewProxy(h, trans)
+	}
+
+	// Only set this for proxies that need it.
+	if trans == transport.TLS || trans == transport.HTTPS {
+		p.SetTLSConfig(f.tlsConfig)
+	}
+	if trans == transport.HTTPS {
+		p.doh.method = f.dohMethod
+	}
+	p.SetExpire(f.expire)
+	p.health.SetRecursionDesired(f.opts.hcRecursionDesired)
+	// when TLS is used, checks are set to tcp-tls
+	if f.opts.forceTCP && trans != transport.TLS {
+		p.health.SetTCPTransport()
+	}
+	p.health.SetDomain(f.opts.hcDomain)
+	return p, nil
+}
+
 func parseBlock(c *caddy.Controller, f *Forward) error {
 	switch c.Val() {
 	case "except":
@@ -230,6 +283,16 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 			return c.ArgErr()
 		}
 		f.tlsServerName = c.Val()
+	case "doh_method":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		switch x := strings.ToUpper(c.Val()); x {
+		case http.MethodGet, http.MethodPost:
+			f.dohMethod = x
+		default:
+			return c.Errf("unknown DoH method '%s'", c.Val())
+		}
 	case "expire":
 		if !c.NextArg() {
 			return c.ArgErr()
@@ -246,15 +309,106 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		if !c.NextArg() {
 			return c.ArgErr()
 		}
-		switch x := c.Val(); x {
-		case "random":
-			f.p = &random{}
-		case "round_robin":
-			f.p = &roundRobin{}
-		case "sequential":
-			f.p = &sequential{}
+		p := newPolicy(c.Val())
+		if p == nil {
+			return c.Errf("unknown policy '%s'", c.Val())
+		}
+		f.p = p
+	case "parallel":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return fmt.Errorf("parallel must be at least 1: %d", n)
+		}
+		f.parallel = n
+		f.stagger = 0
+		if len(args) == 2 {
+			dur, err := time.ParseDuration(args[1])
+			if err != nil {
+				return err
+			}
+			if dur < 0 {
+				return fmt.Errorf("parallel delay can't be negative: %s", dur)
+			}
+			f.stagger = dur
+		}
+	case "ecs":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 3 {
+			return c.ArgErr()
+		}
+		e := &ecs{mode: args[0], v4: defaultECSv4, v6: defaultECSv6}
+		switch e.mode {
+		case ecsAdd, ecsRewrite:
+		case ecsStrip:
+			if len(args) > 1 {
+				return c.ArgErr()
+			}
 		default:
-			return c.Errf("unknown policy '%s'", x)
+			return c.Errf("unknown ecs mode '%s'", e.mode)
+		}
+		if len(args) > 1 {
+			n, err := strconv.ParseUint(args[1], 10, 8)
+			if err != nil || n > 32 {
+				return c.Errf("invalid IPv4 source prefix length '%s'", args[1])
+			}
+			e.v4 = uint8(n)
+		}
+		if len(args) > 2 {
+			n, err := strconv.ParseUint(args[2], 10, 8)
+			if err != nil || n > 128 {
+				return c.Errf("invalid IPv6 source prefix length '%s'", args[2])
+			}
+			e.v6 = uint8(n)
+		}
+		f.ecs = e
+	case "route":
+		args := c.RemainingArgs()
+		if len(args) < 2 {
+			return c.ArgErr()
+		}
+		to, err := parseTo(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(to) > max {
+			return fmt.Errorf("more than %d TOs configured for route %s: %d", max, args[0], len(to))
+		}
+		if f.routes == nil {
+			f.routes = newRoutes()
+		}
+		zone := plugin.Name(args[0]).Normalize()
+		if !plugin.Name(f.from).Matches(zone) {
+			return c.Errf("route %s is not in %s", zone, f.from)
+		}
+		f.routes.inline[zone] = append(f.routes.inline[zone], to...)
+	case "routes":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		if f.routes == nil {
+			f.routes = newRoutes()
+		}
+		if f.routes.path != "" {
+			return c.Err("routes can only be used once")
+		}
+		f.routes.path = args[0]
+		if len(args) == 2 {
+			dur, err := time.ParseDuration(args[1])
+			if err != nil {
+				return err
+			}
+			if dur < 0 {
+				return fmt.Errorf("routes reload can't be negative: %s", dur)
+			}
+			f.routes.reload = dur
 		}
 	case "max_concurrent":
 		if !c.NextArg() {
@@ -278,3 +432,9 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 }
 
 const max = 15 // Maximum number of upstreams.
+
+// Default source prefix lengths of the client subnets the ecs property adds, as RFC 7871 recommends.
+const (
+	defaultECSv4 = 24
+	defaultECSv6 = 56
+)
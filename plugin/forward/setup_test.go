@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 )
@@ -39,7 +40,7 @@ func TestSetup(t *testing.T) {
 		{"forward . 127.0.0.1 {\nhealth_check 0.5s domain\n}\n", true, "", nil, 0, options{hcRecursionDesired: true, hcDomain: "."}, "Wrong argument count or unexpected line ending after 'domain'"},
 		{`forward . ::1
 		forward com ::2`, true, "", nil, 0, options{hcRecursionDesired: true, hcDomain: "."}, "plugin"},
-		{"forward . https://127.0.0.1 \n", true, ".", nil, 2, options{hcRecursionDesired: true, hcDomain: "."}, "'https' is not supported as a destination protocol in forward: https://127.0.0.1"},
+		{"forward . grpc://127.0.0.1 \n", true, ".", nil, 2, options{hcRecursionDesired: true, hcDomain: "."}, "'grpc' is not supported as a destination protocol in forward: grpc://127.0.0.1:443"},
 		{"forward xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx 127.0.0.1 \n", true, ".", nil, 2, options{hcRecursionDesired: true, hcDomain: "."}, "unable to normalize 'xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx'"},
 	}
 
@@ -126,6 +127,65 @@ func TestSetupTLS(t *testing.T) {
 	}
 }
 
+func TestSetupDoH(t *testing.T) {
+	tests := []struct {
+		input          string
+		shouldErr      bool
+		expectedAddrs  []string
+		expectedURL    string
+		expectedMethod string
+		expectedErr    string
+	}{
+		// positive
+		{`forward . https://dns.example.org/dns-query`, false, []string{"dns.example.org:443"}, "https://dns.example.org:443/dns-query", "POST", ""},
+		{`forward . https://192.0.2.1:8443 127.0.0.1 {
+				doh_method get
+			}`, false, []string{"192.0.2.1:8443", "127.0.0.1:53"}, "https://192.0.2.1:8443/dns-query", "GET", ""},
+		{`forward . https://[2001:db8::1]/resolve`, false, []string{"[2001:db8::1]:443"}, "https://[2001:db8::1]:443/resolve", "POST", ""},
+		// negative
+		{`forward . https://`, true, nil, "", "", "not a DoH endpoint"},
+		{`forward . https://dns.example.org/dns-query?dns=x`, true, nil, "", "", "can only have a host and a path"},
+		{`forward . https://dns.example.org {
+				doh_method put
+			}`, true, nil, "", "", "unknown DoH method 'put'"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+
+		for j, addr := range test.expectedAddrs {
+			if f.proxies[j].addr != addr {
+				t.Errorf("Test %d: expected upstream %s, got %s", i, addr, f.proxies[j].addr)
+			}
+		}
+		d := f.proxies[0].doh
+		if d == nil {
+			t.Errorf("Test %d: expected a DoH upstream", i)
+			continue
+		}
+		if d.url != test.expectedURL || d.method != test.expectedMethod {
+			t.Errorf("Test %d: expected %s %s, got %s %s", i, test.expectedMethod, test.expectedURL, d.method, d.url)
+		}
+		if _, ok := f.proxies[0].health.(*dohHc); !ok {
+			t.Errorf("Test %d: expected a DoH health checker", i)
+		}
+	}
+}
+
 func TestSetupResolvconf(t *testing.T) {
 	const resolv = "resolv.conf"
 	if err := os.WriteFile(resolv,
@@ -221,6 +281,50 @@ func TestSetupMaxConcurrent(t *testing.T) {
 	}
 }
 
+func TestSetupParallel(t *testing.T) {
+	tests := []struct {
+		input            string
+		shouldErr        bool
+		expectedParallel int
+		expectedStagger  time.Duration
+		expectedErr      string
+	}{
+		// positive
+		{"forward . 127.0.0.1 127.0.0.2", false, 0, 0, ""},
+		{"forward . 127.0.0.1 127.0.0.2 {\nparallel 2\n}\n", false, 2, 0, ""},
+		{"forward . 127.0.0.1 127.0.0.2 {\nparallel 3 50ms\n}\n", false, 3, 50 * time.Millisecond, ""},
+		// negative
+		{"forward . 127.0.0.1 {\nparallel\n}\n", true, 0, 0, "Wrong argument count"},
+		{"forward . 127.0.0.1 {\nparallel 0\n}\n", true, 0, 0, "at least 1"},
+		{"forward . 127.0.0.1 {\nparallel many\n}\n", true, 0, 0, "invalid"},
+		{"forward . 127.0.0.1 {\nparallel 2 -1s\n}\n", true, 0, 0, "negative"},
+		{"forward . 127.0.0.1 {\nparallel 2 1s 2s\n}\n", true, 0, 0, "Wrong argument count"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		f, err := parseForward(c)
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found %s for input %s", i, err, test.input)
+		}
+
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+		}
+
+		if !test.shouldErr && (f.parallel != test.expectedParallel || f.stagger != test.expectedStagger) {
+			t.Errorf("Test %d: expected: %d %s, got: %d %s", i, test.expectedParallel, test.expectedStagger, f.parallel, f.stagger)
+		}
+	}
+}
+
 func TestSetupHealthCheck(t *testing.T) {
 	tests := []struct {
 		input          string
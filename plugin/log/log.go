@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/event"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/replacer"
 	"github.com/coredns/coredns/plugin/pkg/response"
@@ -20,7 +22,8 @@ type Logger struct {
 	Next  plugin.Handler
 	Rules []Rule
 
-	repl replacer.Replacer
+	repl     replacer.Replacer
+	hostname string // Host name in the events of the rules that have exporters.
 }
 
 // ServeDNS implements the plugin.Handler interface.
@@ -47,6 +50,9 @@ func (l Logger) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		if ok || ok1 {
 			logstr := l.repl.Replace(ctx, state, rrw, rule.Format)
 			clog.Info(logstr)
+			if len(rule.Exporters) > 0 {
+				l.export(ctx, state, rrw, rc, rule.Exporters)
+			}
 		}
 
 		return rc, err
@@ -55,6 +61,28 @@ func (l Logger) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 	return plugin.NextOrFailure(l.Name(), l.Next, ctx, w, r)
 }
 
+// export queues the event of the logged query with exporters.
+func (l Logger) export(ctx context.Context, state request.Request, rrw *dnstest.Recorder, rc int, exporters []*event.Exporter) {
+	var (
+		selected []*event.Exporter
+		fields   event.Fields
+	)
+	for _, x := range exporters {
+		if x.Selected(state.Name(), state.QType(), rrw.Msg) {
+			selected = append(selected, x)
+			fields |= x.Fields()
+		}
+	}
+	if len(selected) == 0 {
+		return
+	}
+	e := event.New(ctx, state, rrw, rc, l.hostname, fields)
+	server := metrics.WithServer(ctx)
+	for _, x := range selected {
+		x.Export(server, e)
+	}
+}
+
 // Name implements the Handler interface.
 func (l Logger) Name() string { return "log" }
 
@@ -63,6 +91,7 @@ type Rule struct {
 	NameScope string
 	Class     map[response.Class]struct{}
 	Format    string
+	Exporters []*event.Exporter // Also export the logged queries as events to these sinks.
 }
 
 const (
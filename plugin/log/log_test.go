@@ -6,9 +6,13 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/event"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/plugin/pkg/replacer"
 	"github.com/coredns/coredns/plugin/pkg/response"
@@ -278,3 +282,71 @@ func BenchmarkLogged(b *testing.B) {
 		logger.ServeDNS(ctx, rec, r)
 	}
 }
+
+// recordSink records the query names of the exported events.
+type recordSink struct {
+	sync.Mutex
+	names []string
+}
+
+func (s *recordSink) Export(_ context.Context, events []*event.Event) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, e := range events {
+		s.names = append(s.names, e.QName)
+	}
+	return nil
+}
+
+var sink = &recordSink{}
+
+func init() {
+	event.RegisterSink("record", func([]string, event.Encoding) (event.Sink, string, error) { return sink, "test", nil })
+}
+
+func TestLoggedSink(t *testing.T) {
+	c := caddy.NewTestController("dns", `log example.org {
+		class denial
+		sink record
+	}`)
+	rules, err := logParse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xs := exporters(rules)
+	if len(xs) != 1 {
+		t.Fatalf("Expected 1 exporter, got %d", len(xs))
+	}
+	xs[0].Start()
+
+	rcode := dns.RcodeNameError
+	next := plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		if rcode == dns.RcodeNameError {
+			m.Ns = []dns.RR{test.SOA("example.org. 300 IN SOA ns.example.org. hostmaster.example.org. 1 7200 3600 1209600 300")}
+		} else {
+			m.Answer = []dns.RR{test.A(r.Question[0].Name + " 300 IN A 192.0.2.1")}
+		}
+		w.WriteMsg(m)
+		return rcode, nil
+	})
+	logger := Logger{Rules: rules, Next: next, repl: replacer.New()}
+	for _, name := range []string{"a.example.org.", "example.net."} {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		logger.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), r)
+	}
+	// Not a denial, so it is neither logged nor exported.
+	rcode = dns.RcodeSuccess
+	r := new(dns.Msg)
+	r.SetQuestion("b.example.org.", dns.TypeA)
+	logger.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), r)
+
+	xs[0].Stop() // exports the queued events
+	sink.Lock()
+	defer sink.Unlock()
+	if len(sink.names) != 1 || sink.names[0] != "a.example.org." {
+		t.Errorf("Expected only the event of a.example.org., got %v", sink.names)
+	}
+}
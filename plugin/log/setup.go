@@ -1,11 +1,14 @@
 package log
 
 import (
+	"os"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/event"
 	"github.com/coredns/coredns/plugin/pkg/replacer"
 	"github.com/coredns/coredns/plugin/pkg/response"
 
@@ -20,8 +23,21 @@ func setup(c *caddy.Controller) error {
 		return plugin.Error("log", err)
 	}
 
+	hostname, _ := os.Hostname()
+	for _, x := range exporters(rules) {
+		x := x
+		c.OnStartup(func() error {
+			x.Start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			x.Stop()
+			return nil
+		})
+	}
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-		return Logger{Next: next, Rules: rules, repl: replacer.New()}
+		return Logger{Next: next, Rules: rules, repl: replacer.New(), hostname: hostname}
 	})
 
 	return nil
@@ -68,8 +84,9 @@ func logParse(c *caddy.Controller) ([]Rule, error) {
 			}
 		}
 
-		// Class refinements in an extra block.
+		// Class refinements and sinks in an extra block.
 		classes := make(map[response.Class]struct{})
+		var xs []*event.Exporter
 		for c.NextBlock() {
 			switch c.Val() {
 			// class followed by combinations of all, denial, error and success.
@@ -85,6 +102,14 @@ func logParse(c *caddy.Controller) ([]Rule, error) {
 					}
 					classes[cls] = struct{}{}
 				}
+			// sink followed by the sink and its arguments, and its options in a block, as in the
+			// events plugin.
+			case "sink":
+				x, err := sinkParse(c)
+				if err != nil {
+					return nil, err
+				}
+				xs = append(xs, x)
 			default:
 				return nil, c.ArgErr()
 			}
@@ -95,8 +120,100 @@ func logParse(c *caddy.Controller) ([]Rule, error) {
 
 		for i := len(rules) - 1; i >= length; i-- {
 			rules[i].Class = classes
+			rules[i].Exporters = xs
 		}
 	}
 
 	return rules, nil
 }
+
+// sinkParse parses the sink at the current token of c, with the options of the events plugin in an
+// optional block, and returns its exporter.
+func sinkParse(c *caddy.Controller) (*event.Exporter, error) {
+	args := c.RemainingArgs()
+	// RemainingArgs stops at the opening brace of a block, within the block of the log plugin
+	// NextBlock doesn't handle it.
+	block := c.NextArg()
+	if len(args) == 0 {
+		return nil, c.ArgErr()
+	}
+	f, ok := event.LookupSink(args[0])
+	if !ok {
+		return nil, c.Errf("unknown sink '%s', expected one of: %s", args[0], strings.Join(event.SinkNames(), ", "))
+	}
+
+	cfg := event.Config{
+		QueueSize:     defaultQueueSize,
+		BatchSize:     defaultBatchSize,
+		FlushInterval: defaultFlushInterval,
+	}
+	enc := event.JSON
+	for block {
+		if !c.Next() {
+			return nil, c.EOFErr()
+		}
+		if c.Val() == "}" {
+			break
+		}
+		if ok, err := cfg.Parse(c); ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch c.Val() {
+		case "fields":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			for _, a := range args {
+				f, err := event.FieldsFromString(a)
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				cfg.Fields |= f
+			}
+		case "encoding":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.ArgErr()
+			}
+			e, err := event.EncodingFromString(args[0])
+			if err != nil {
+				return nil, c.Err(err.Error())
+			}
+			enc = e
+		default:
+			return nil, c.Errf("unknown property '%s'", c.Val())
+		}
+	}
+
+	s, dest, err := f(args[1:], enc)
+	if err != nil {
+		return nil, c.Err(err.Error())
+	}
+	return event.NewExporter(args[0], dest, s, cfg), nil
+}
+
+// These are the settings of the exporters of the sinks, like the defaults of the events plugin.
+const (
+	defaultQueueSize     = 10000
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+)
+
+// exporters returns the exporters of rules, each once.
+func exporters(rules []Rule) []*event.Exporter {
+	var xs []*event.Exporter
+	seen := map[*event.Exporter]bool{}
+	for _, r := range rules {
+		for _, x := range r.Exporters {
+			if !seen[x] {
+				seen[x] = true
+				xs = append(xs, x)
+			}
+		}
+	}
+	return xs
+}
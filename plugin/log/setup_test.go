@@ -5,7 +5,10 @@ import (
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/event"
 	"github.com/coredns/coredns/plugin/pkg/response"
+
+	"github.com/miekg/dns"
 )
 
 func TestLogParse(t *testing.T) {
@@ -129,6 +132,12 @@ func TestLogParse(t *testing.T) {
 		{`log {
 			unknown
 		}`, true, []Rule{}},
+		{`log {
+			sink bogus
+		}`, true, []Rule{}},
+		{`log {
+			sink
+		}`, true, []Rule{}},
 		{`log example.org "{combined} {/forward/upstream}"`, false, []Rule{{
 			NameScope: "example.org.",
 			Format:    CombinedLogFormat + " {/forward/upstream}",
@@ -183,3 +192,84 @@ func TestLogParse(t *testing.T) {
 		}
 	}
 }
+
+func TestLogParseSink(t *testing.T) {
+	var enc event.Encoding
+	event.RegisterSink("encoding", func(_ []string, e event.Encoding) (event.Sink, string, error) {
+		enc = e
+		return sink, "test", nil
+	})
+
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expectedEnc event.Encoding
+	}{
+		{`log {
+			sink encoding
+		}`, false, event.JSON},
+		{`log {
+			sink encoding {
+				encoding protobuf
+				fields size transport
+				rule example.org
+				queue 10
+				drop oldest
+				batch 10 100ms
+			}
+			class denial
+		}`, false, event.Protobuf},
+		{`log {
+			sink encoding {
+				bogus
+			}
+		}`, true, event.JSON},
+		{`log {
+			sink encoding {
+				queue 0
+			}
+		}`, true, event.JSON},
+		{`log {
+			sink encoding {
+				encoding xml
+			}
+		}`, true, event.JSON},
+		{`log {
+			sink encoding {
+				queue 10
+		`, true, event.JSON},
+	}
+	for i, tc := range tests {
+		enc = event.JSON
+		rules, err := logParse(caddy.NewTestController("dns", tc.input))
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if xs := exporters(rules); len(xs) != 1 {
+			t.Errorf("Test %d: expected 1 exporter, got %d", i, len(xs))
+		}
+		if enc != tc.expectedEnc {
+			t.Errorf("Test %d: expected encoding %d, got %d", i, tc.expectedEnc, enc)
+		}
+	}
+
+	// The options of the block apply to the exporter.
+	rules, _ := logParse(caddy.NewTestController("dns", tests[1].input))
+	x := exporters(rules)[0]
+	if x.Fields() != event.Size|event.Transport {
+		t.Errorf("Expected the size and transport fields, got %d", x.Fields())
+	}
+	if x.Selected("example.net.", dns.TypeA, new(dns.Msg)) {
+		t.Error("Expected a query outside of the rule not to be selected")
+	}
+	if _, ok := rules[0].Class[response.Denial]; !ok {
+		t.Error("Expected the class after the sink block to be parsed")
+	}
+}
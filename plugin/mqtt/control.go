@@ -0,0 +1,208 @@
+package mqtt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/miekg/dns"
+)
+
+// maxSkew is how far the time of a command may be from the current time. Within this window
+// every signature is accepted only once, which prevents replaying commands.
+const maxSkew = 5 * time.Minute
+
+// Control executes the commands received on the control topic and holds the state they change:
+// the blocked names and the zones with debug logging.
+type Control struct {
+	secret []byte
+	cache  remover // Nil when there is no cache plugin.
+	now    func() time.Time
+
+	sync.RWMutex
+	blocked map[string]struct{}
+	debug   map[string]struct{}
+	seen    map[string]time.Time // Signatures of the accepted commands, by the time they expire.
+}
+
+// remover removes responses from a cache, for qtypes or all types when qtypes is empty. It is
+// implemented by the cache plugin.
+type remover interface {
+	Remove(qname string, qtypes ...uint16)
+}
+
+// NewControl returns a Control that accepts commands signed with secret.
+func NewControl(secret []byte) *Control {
+	return &Control{
+		secret:  secret,
+		now:     time.Now,
+		blocked: make(map[string]struct{}),
+		debug:   make(map[string]struct{}),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// envelope is a message on the control topic. Signature is the base64 encoded HMAC-SHA256 of
+// Command, exactly as it appears in the message.
+type envelope struct {
+	Command   json.RawMessage `json:"command"`
+	Signature string          `json:"signature"`
+}
+
+// command is a signed command.
+type command struct {
+	Op     string `json:"op"`     // flush, debug, block or unblock.
+	Name   string `json:"name"`   // Name to flush or (un)block, or the zone for debug.
+	Type   string `json:"type"`   // Type to flush, all types when empty.
+	Enable bool   `json:"enable"` // For debug, whether to enable or disable debug logging.
+	Time   int64  `json:"time"`   // Unix time the command was created.
+}
+
+// Blocked returns true when name, or one of its parents, is blocked.
+func (c *Control) Blocked(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return matchesAny(c.blocked, name)
+}
+
+// Debug returns true when debug logging is enabled for the zone of name.
+func (c *Control) Debug(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return matchesAny(c.debug, name)
+}
+
+// matchesAny returns true when name or one of its parents is in names.
+func matchesAny(names map[string]struct{}, name string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := names[name[off:]]; ok {
+			return true
+		}
+	}
+	_, ok := names["."]
+	return ok
+}
+
+// message is the paho.MessageHandler for the control topic.
+func (c *Control) message(_ paho.Client, m paho.Message) {
+	op, err := c.execute(m.Payload())
+	switch op {
+	case "flush", "debug", "block", "unblock":
+	default:
+		op = "unknown"
+	}
+	if err != nil {
+		controlCount.WithLabelValues(op, "rejected").Inc()
+		log.Warningf("Rejected command from %s: %s", m.Topic(), err)
+		return
+	}
+	controlCount.WithLabelValues(op, "accepted").Inc()
+}
+
+// execute verifies the command in payload and executes it. It returns the command's operation.
+func (c *Control) execute(payload []byte) (string, error) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return "", fmt.Errorf("invalid message: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature: %s", err)
+	}
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(env.Command)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", fmt.Errorf("bad signature")
+	}
+
+	var cmd command
+	if err := json.Unmarshal(env.Command, &cmd); err != nil {
+		return "", fmt.Errorf("invalid command: %s", err)
+	}
+	if err := c.fresh(env.Signature, cmd.Time); err != nil {
+		return cmd.Op, err
+	}
+	if cmd.Name == "" {
+		return cmd.Op, fmt.Errorf("no name in %s command", cmd.Op)
+	}
+	name := plugin.Name(cmd.Name).Normalize()
+
+	switch cmd.Op {
+	case "flush":
+		return cmd.Op, c.flush(name, cmd.Type)
+	case "debug":
+		c.Lock()
+		if cmd.Enable {
+			c.debug[name] = struct{}{}
+		} else {
+			delete(c.debug, name)
+		}
+		c.Unlock()
+		log.Infof("Debug logging for %s enabled: %t", name, cmd.Enable)
+	case "block":
+		c.Lock()
+		c.blocked[name] = struct{}{}
+		c.Unlock()
+		log.Infof("Blocked %s", name)
+	case "unblock":
+		c.Lock()
+		delete(c.blocked, name)
+		c.Unlock()
+		log.Infof("Unblocked %s", name)
+	default:
+		return cmd.Op, fmt.Errorf("unknown command %q", cmd.Op)
+	}
+	return cmd.Op, nil
+}
+
+// fresh checks that a command with signature sig created at t is recent, and was not seen before.
+func (c *Control) fresh(sig string, t int64) error {
+	now := c.now()
+	created := time.Unix(t, 0)
+	if created.Before(now.Add(-maxSkew)) || created.After(now.Add(maxSkew)) {
+		return fmt.Errorf("command time %s is too far from the current time", created.UTC().Format(time.RFC3339))
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for s, expire := range c.seen {
+		if now.After(expire) {
+			delete(c.seen, s)
+		}
+	}
+	if _, ok := c.seen[sig]; ok {
+		return fmt.Errorf("command was replayed")
+	}
+	c.seen[sig] = created.Add(maxSkew)
+	return nil
+}
+
+// flush removes name from the cache, for qtype or all types when qtype is empty.
+func (c *Control) flush(name, qtype string) error {
+	if c.cache == nil {
+		return fmt.Errorf("no cache to flush")
+	}
+	if qtype == "" {
+		c.cache.Remove(name)
+		log.Infof("Flushed %s from the cache", name)
+		return nil
+	}
+	t, ok := dns.StringToType[strings.ToUpper(qtype)]
+	if !ok {
+		return fmt.Errorf("unknown type %q", qtype)
+	}
+	c.cache.Remove(name, t)
+	log.Infof("Flushed %s %s from the cache", name, dns.TypeToString[t])
+	return nil
+}
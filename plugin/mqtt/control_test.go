@@ -0,0 +1,122 @@
+package mqtt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// signed returns a control message holding cmd, signed with secret.
+func signed(secret, cmd string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(cmd))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return []byte(fmt.Sprintf(`{"command":%s,"signature":%q}`, cmd, sig))
+}
+
+type fakeCache struct{ removed []string }
+
+func (f *fakeCache) Remove(qname string, qtypes ...uint16) {
+	if len(qtypes) == 0 {
+		f.removed = append(f.removed, qname+" *")
+	}
+	for _, t := range qtypes {
+		f.removed = append(f.removed, qname+" "+dns.TypeToString[t])
+	}
+}
+
+func TestControl(t *testing.T) {
+	now := time.Unix(1654070400, 0)
+	c := NewControl([]byte("s3cret"))
+	c.now = func() time.Time { return now }
+	f := &fakeCache{}
+	c.cache = f
+
+	cmds := []string{
+		`{"op":"block","name":"ads.example.org","time":1654070400}`,
+		`{"op":"debug","name":"example.net.","enable":true,"time":1654070401}`,
+		`{"op":"flush","name":"www.example.org.","type":"aaaa","time":1654070402}`,
+	}
+	for _, cmd := range cmds {
+		if _, err := c.execute(signed("s3cret", cmd)); err != nil {
+			t.Fatalf("Expected %s to be executed, got %s", cmd, err)
+		}
+	}
+
+	if !c.Blocked("ads.example.org.") || !c.Blocked("x.ads.example.org.") || c.Blocked("example.org.") {
+		t.Errorf("Expected ads.example.org. and its subdomains to be blocked")
+	}
+	if !c.Debug("www.example.net.") || c.Debug("www.example.org.") {
+		t.Errorf("Expected debug logging for example.net. only")
+	}
+	if len(f.removed) != 1 || f.removed[0] != "www.example.org. AAAA" {
+		t.Errorf("Expected www.example.org. AAAA to be flushed, got %v", f.removed)
+	}
+
+	for _, cmd := range []string{
+		`{"op":"unblock","name":"ads.example.org.","time":1654070403}`,
+		`{"op":"debug","name":"example.net.","enable":false,"time":1654070404}`,
+	} {
+		if _, err := c.execute(signed("s3cret", cmd)); err != nil {
+			t.Fatalf("Expected %s to be executed, got %s", cmd, err)
+		}
+	}
+	if c.Blocked("ads.example.org.") || c.Debug("www.example.net.") {
+		t.Errorf("Expected the block and debug logging to be removed")
+	}
+
+	// A flush without a type flushes all types.
+	f.removed = nil
+	if _, err := c.execute(signed("s3cret", `{"op":"flush","name":"example.org.","time":1654070405}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.removed) != 1 || f.removed[0] != "example.org. *" {
+		t.Errorf("Expected all types of example.org. to be flushed at once, got %v", f.removed)
+	}
+}
+
+func TestControlRejected(t *testing.T) {
+	now := time.Unix(1654070400, 0)
+	c := NewControl([]byte("s3cret"))
+	c.now = func() time.Time { return now }
+
+	replayed := signed("s3cret", `{"op":"block","name":"example.org.","time":1654070400}`)
+	if _, err := c.execute(replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		msg         []byte
+		expectedErr string
+	}{
+		{[]byte(`not json`), "invalid message"},
+		{[]byte(`{"command":{"op":"block"},"signature":"!!"}`), "invalid signature"},
+		{signed("other", `{"op":"block","name":"example.org.","time":1654070400}`), "bad signature"},
+		{signed("s3cret", `{"op":"block","name":"example.org.","time":1654060400}`), "too far from the current time"},
+		{signed("s3cret", `{"op":"block","name":"example.org.","time":1654080400}`), "too far from the current time"},
+		{replayed, "replayed"},
+		{signed("s3cret", `{"op":"block","time":1654070401}`), "no name"},
+		{signed("s3cret", `{"op":"delete","name":"example.org.","time":1654070402}`), "unknown command"},
+		{signed("s3cret", `{"op":"flush","name":"example.org.","time":1654070403}`), "no cache"},
+	}
+	for i, tc := range tests {
+		if _, err := c.execute(tc.msg); err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, tc.expectedErr, err)
+		}
+	}
+
+	// Once the command expired, its signature is forgotten.
+	now = now.Add(2 * maxSkew)
+	if _, err := c.execute(signed("s3cret", `{"op":"unblock","name":"example.org.","time":1654071000}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.seen) != 1 {
+		t.Errorf("Expected expired signatures to be removed, got %d", len(c.seen))
+	}
+}
@@ -0,0 +1,18 @@
+package mqtt
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// controlCount is the number of commands received on the control topic.
+	controlCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "mqtt",
+		Name:      "control_commands_total",
+		Help:      "Counter of commands received on the control topic.",
+	}, []string{"command", "result"})
+)
@@ -1,60 +1,62 @@
-// Package log implements basic but useful request (access) logging plugin.
-package log
+// Package mqtt implements a plugin that publishes an event for every query to an MQTT broker. The
+// events of the queries are captured and queued by the event package, the plugin's Publisher is
+// the sink that publishes them.
+package mqtt
 
 import (
 	"context"
 	"encoding/json"
-	"log"
-	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/miekg/dns"
 )
 
-// Logger is a basic request logging plugin.
-type Logger struct {
+var log = clog.NewWithPlugin("mqtt")
+
+// controlHandler applies the commands received on the control topic to the queries: it answers
+// the queries for blocked names with NXDOMAIN, and logs the events of the zones with debug logging.
+type controlHandler struct {
 	Next     plugin.Handler
-	Mqtt     mqtt.Client
+	Control  *Control
 	Hostname string
 }
 
-type Event struct {
-	IP         string `json:"ip"`
-	QName      string `json:"qname"`
-	QType      string `json:"qtype"`
-	Rcode      string `json:"rcode"`
-	DurationUS int64  `json:"duration_us"`
-	NSHostname string `json:"ns_hostname"`
-}
-
 // ServeDNS implements the plugin.Handler interface.
-func (l Logger) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+func (h controlHandler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
 
-	rw := dnstest.NewRecorder(w)
-	status, err := plugin.NextOrFailure(l.Name(), l.Next, ctx, rw, r)
-
-	event := Event{
-		IP:         state.IP(),
-		QName:      state.QName(),
-		QType:      state.Type(),
-		Rcode:      dns.RcodeToString[rw.Msg.Rcode],
-		DurationUS: time.Since(rw.Start).Microseconds(),
-		NSHostname: l.Hostname,
+	var rw *dnstest.Recorder
+	debug := h.Control.Debug(state.Name())
+	if debug {
+		rw = dnstest.NewRecorder(w)
+		w = rw
 	}
-	json, _ := json.Marshal(event)
-	jstring := string(json)
-	log.Println(jstring)
 
-	token := l.Mqtt.Publish("fdns", 0, false, jstring)
-	token.Wait()
+	var (
+		status int
+		err    error
+	)
+	if h.Control.Blocked(state.Name()) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+		status = dns.RcodeNameError
+	} else {
+		status, err = plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
 
+	if debug {
+		if b, jerr := json.Marshal(event.New(ctx, state, rw, status, h.Hostname, event.All)); jerr == nil {
+			log.Infof("Debug: %s", b)
+		}
+	}
 	return status, err
 }
 
 // Name implements the Handler interface.
-func (l Logger) Name() string { return "log" }
+func (h controlHandler) Name() string { return "mqtt" }
@@ -0,0 +1,76 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNS(t *testing.T) {
+	f := &fakeClient{}
+	topic, _ := ParseTopic("dns/{zone}/{rcode}")
+	x := event.NewExporter("mqtt", "tcp://localhost:1883", NewPublisher(f, "tcp://localhost:1883", topic, []string{"org.", "example.org."}), event.Config{QueueSize: 1, BatchSize: 1})
+	h := event.Handler{Next: test.ErrorHandler(), Plugin: "mqtt", Exporters: []*event.Exporter{x}, Hostname: "ns1"}
+	x.Start()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	h.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+	x.Stop()
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.msgs) != 1 || f.topics[0] != "dns/example.org/SERVFAIL" {
+		t.Fatalf("Expected an event on dns/example.org/SERVFAIL, got %v on %v", f.msgs, f.topics)
+	}
+	var e event.Event
+	if err := json.Unmarshal([]byte(f.msgs[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.QName != "example.org." || e.QType != "A" || e.Rcode != "SERVFAIL" || e.NSHostname != "ns1" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
+
+func TestServeDNSBlocked(t *testing.T) {
+	ctrl := NewControl([]byte("s3cret"))
+	ctrl.blocked["example.org."] = struct{}{}
+	h := controlHandler{Next: test.ErrorHandler(), Control: ctrl}
+
+	tests := []struct {
+		name     string
+		expected int
+	}{
+		{"www.example.org.", dns.RcodeNameError},
+		{"example.net.", dns.RcodeServerFailure},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if rcode, _ := h.ServeDNS(context.TODO(), rec, m); rcode != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, dns.RcodeToString[tc.expected], dns.RcodeToString[rcode])
+		}
+		if rec.Msg == nil || rec.Msg.Rcode != tc.expected {
+			t.Errorf("Test %d: expected a %s reply, got %v", i, dns.RcodeToString[tc.expected], rec.Msg)
+		}
+	}
+}
+
+func TestServeDNSDebug(t *testing.T) {
+	ctrl := NewControl([]byte("s3cret"))
+	ctrl.debug["example.org."] = struct{}{}
+	h := controlHandler{Next: test.NextHandler(dns.RcodeRefused, nil), Control: ctrl}
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	if rcode, _ := h.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m); rcode != dns.RcodeRefused {
+		t.Errorf("Expected the rcode of the next plugin, got %s", dns.RcodeToString[rcode])
+	}
+}
@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/event"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultQueueSize      = 10000
+	defaultBatchSize      = 1
+	defaultBatchTimeout   = 100 * time.Millisecond
+	defaultPublishTimeout = 5 * time.Second
+)
+
+// Publisher is the event.Sink that publishes events to the broker. The events of a batch that
+// are published to the same topic are published as a single message.
+type Publisher struct {
+	client   paho.Client
+	broker   string // Broker address for logs.
+	topic    Topic
+	zones    []string // Zones of the server block, for the {zone} placeholder.
+	encoding event.Encoding
+	qos      byte
+	retain   bool
+
+	publishTimeout time.Duration // Maximum time to wait for the broker to acknowledge a message.
+}
+
+// NewPublisher returns a publisher that publishes to the broker client is connected to, on
+// topic. It publishes JSON events with QoS 0. Until it is used these settings can be changed.
+func NewPublisher(client paho.Client, broker string, topic Topic, zones []string) *Publisher {
+	return &Publisher{
+		client:         client,
+		broker:         broker,
+		topic:          topic,
+		zones:          zones,
+		publishTimeout: defaultPublishTimeout,
+	}
+}
+
+// Export implements event.Sink. When a message fails, the broker is unlikely to accept the next
+// one: the events for the remaining topics are not published instead of waiting for the broker
+// again.
+func (p *Publisher) Export(_ context.Context, events []*event.Event) error {
+	if !p.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected")
+	}
+
+	// Group the events by topic, keeping the topics in the order they were first seen.
+	var (
+		topics   []string
+		payloads = make(map[string][][]byte)
+	)
+	for _, e := range events {
+		b, err := p.encoding.Encode(e)
+		if err != nil {
+			log.Errorf("Failed to encode event: %s", err)
+			continue
+		}
+		name := strings.ToLower(e.QName)
+		topic := p.topic.Render(TopicValues{
+			Zone:     plugin.Zones(p.zones).Matches(name),
+			Name:     name,
+			Type:     e.QType,
+			Rcode:    e.Rcode,
+			Hostname: e.NSHostname,
+		})
+		if _, ok := payloads[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		payloads[topic] = append(payloads[topic], b)
+	}
+
+	for _, topic := range topics {
+		msg := bytes.Join(payloads[topic], p.encoding.Separator())
+		token := p.client.Publish(topic, p.qos, p.retain, msg)
+		if !token.WaitTimeout(p.publishTimeout) {
+			return fmt.Errorf("publishing to %s timed out", topic)
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
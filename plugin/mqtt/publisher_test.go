@@ -0,0 +1,112 @@
+package mqtt
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient records the published messages, the other methods of paho.Client are not implemented.
+type fakeClient struct {
+	paho.Client
+
+	sync.Mutex
+	msgs    []string
+	topics  []string
+	offline bool
+}
+
+func (f *fakeClient) IsConnectionOpen() bool { return !f.offline }
+
+func (f *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	f.Lock()
+	defer f.Unlock()
+	f.topics = append(f.topics, topic)
+	f.msgs = append(f.msgs, string(payload.([]byte)))
+	return &fakeToken{}
+}
+
+func (f *fakeClient) published() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.msgs...)
+}
+
+type fakeToken struct{ err error }
+
+func (t *fakeToken) Wait() bool                     { return true }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (t *fakeToken) Error() error                   { return t.err }
+
+func TestPublisher(t *testing.T) {
+	f := &fakeClient{}
+	topic, _ := ParseTopic("fdns")
+	p := NewPublisher(f, "tcp://localhost:1883", topic, nil)
+	if err := p.Export(context.TODO(), []*event.Event{{QName: "a."}, {QName: "b."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := f.published()
+	if len(got) != 1 || strings.Count(got[0], "\n") != 1 || !strings.Contains(got[0], `"qname":"b."`) {
+		t.Errorf("Expected the events in a single message, got %q", got)
+	}
+}
+
+func TestPublisherTopics(t *testing.T) {
+	f := &fakeClient{}
+	topic, _ := ParseTopic("dns/{zone}/{name}/{rcode}")
+	p := NewPublisher(f, "tcp://localhost:1883", topic, []string{"org.", "example.org."})
+	events := []*event.Event{
+		{QName: "WWW.example.org.", Rcode: "NOERROR"},
+		{QName: "example.com.", Rcode: "NXDOMAIN"},
+		{QName: "www.example.org.", Rcode: "NOERROR"},
+	}
+	if err := p.Export(context.TODO(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if got := strings.Join(f.topics, ","); got != "dns/example.org/www.example.org/NOERROR,dns/-/example.com/NXDOMAIN" {
+		t.Errorf("Expected a message per topic, got topics %q", got)
+	}
+	if len(f.msgs) != 2 || strings.Count(f.msgs[0], "\n") != 1 {
+		t.Errorf("Expected the events grouped by topic, got %q", f.msgs)
+	}
+}
+
+func TestPublisherProtobuf(t *testing.T) {
+	f := &fakeClient{}
+	topic, _ := ParseTopic("fdns")
+	p := NewPublisher(f, "tcp://localhost:1883", topic, nil)
+	p.encoding = event.Protobuf
+	e := &event.Event{QName: "a."}
+	if err := p.Export(context.TODO(), []*event.Event{e, e}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Protobuf events are concatenated without a separator.
+	b, _ := event.Protobuf.Encode(e)
+	if got := f.published(); len(got) != 1 || got[0] != string(b)+string(b) {
+		t.Errorf("Expected the concatenated events, got %q", got)
+	}
+}
+
+func TestPublisherDisconnected(t *testing.T) {
+	f := &fakeClient{offline: true}
+	topic, _ := ParseTopic("fdns")
+	p := NewPublisher(f, "tcp://localhost:1883", topic, nil)
+	if err := p.Export(context.TODO(), []*event.Event{{QName: "a."}}); err == nil {
+		t.Error("Expected an error while disconnected")
+	}
+	if got := f.published(); len(got) != 0 {
+		t.Errorf("Expected nothing to be published while disconnected, got %v", got)
+	}
+}
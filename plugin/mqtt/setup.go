@@ -1,13 +1,20 @@
-package log
+package mqtt
 
 import (
-	"log"
+	"crypto/tls"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
 )
 
 func init() {
@@ -17,136 +24,321 @@ func init() {
 	})
 }
 
-var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	log.Println("[mqtt] Connected")
-}
+const defaultTopic = "fdns"
+
+// config holds everything parsed from the Corefile.
+type config struct {
+	broker       string
+	exporter     event.Config // Rules, fields, queue and batches.
+	topic        Topic
+	control      string // Control topic, empty when there is none.
+	secret       []byte // Secret the commands on the control topic are signed with.
+	encoding     event.Encoding
+	qos          byte
+	retain       bool
+	clientID     string // Defaults to the hostname.
+	username     string
+	password     string
+	tls          *tls.Config
+	cleanSession bool
+	keepalive    time.Duration // Zero uses the paho default.
 
-var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	log.Printf("[mqtt] Connect lost: %v", err)
+	publishTimeout time.Duration
 }
 
 func setup(c *caddy.Controller) error {
-	logger := Logger{}
-	hostname, _ := os.Hostname()
-	logger.Hostname = hostname
-	c.Next()
-
-	if !c.NextArg() {
-		return plugin.Error("mqtt", c.ArgErr())
+	cfg, err := mqttParse(c)
+	if err != nil {
+		return plugin.Error("mqtt", err)
 	}
 
-	connString := c.Val()
-	log.Println("[mqtt] connecting to", connString)
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(connString)
-	opts.SetClientID(hostname)
-	// opts.SetUsername("emqx")
-	// opts.SetPassword("public")
-	opts.OnConnect = connectHandler
-	opts.OnConnectionLost = connectLostHandler
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Println(token.Error())
+	hostname, _ := os.Hostname()
+	if cfg.clientID == "" {
+		cfg.clientID = hostname
 	}
-	logger.Mqtt = client
-
-	if c.NextArg() {
-		return plugin.Error("mqtt", c.ArgErr())
+	opts := paho.NewClientOptions()
+	opts.AddBroker(cfg.broker)
+	opts.SetClientID(cfg.clientID)
+	opts.SetUsername(cfg.username)
+	opts.SetPassword(cfg.password)
+	opts.SetCleanSession(cfg.cleanSession)
+	if cfg.tls != nil {
+		opts.SetTLSConfig(cfg.tls)
+	}
+	if cfg.keepalive > 0 {
+		opts.SetKeepAlive(cfg.keepalive)
+	}
+	// Don't wait for the broker on startup, connect and reconnect in the background.
+	opts.SetConnectRetry(true)
+	opts.SetAutoReconnect(true)
+	var ctrl *Control
+	if cfg.control != "" {
+		ctrl = NewControl(cfg.secret)
 	}
+	opts.OnConnect = func(client paho.Client) {
+		log.Infof("Connected to %s", cfg.broker)
+		if ctrl == nil {
+			return
+		}
+		// Subscribe on every connect, the subscription is lost with a clean session.
+		token := client.Subscribe(cfg.control, cfg.qos, ctrl.message)
+		if token.WaitTimeout(cfg.publishTimeout) && token.Error() != nil {
+			log.Warningf("Failed to subscribe to %s: %s", cfg.control, token.Error())
+		}
+	}
+	opts.OnConnectionLost = func(_ paho.Client, err error) { log.Warningf("Connection to %s lost: %s", cfg.broker, err) }
+	client := paho.NewClient(opts)
+
+	p := NewPublisher(client, cfg.broker, cfg.topic, plugin.OriginsFromArgsOrServerBlock(nil, c.ServerBlockKeys))
+	p.encoding = cfg.encoding
+	p.qos = cfg.qos
+	p.retain = cfg.retain
+	p.publishTimeout = cfg.publishTimeout
+	x := event.NewExporter("mqtt", cfg.broker, p, cfg.exporter)
 
-	dnsserver.
-		GetConfig(c).
-		AddPlugin(func(next plugin.Handler) plugin.Handler {
-			logger.Next = next
-			return logger
-		})
+	c.OnStartup(func() error {
+		if ctrl != nil {
+			if r, ok := dnsserver.GetConfig(c).Handler("cache").(remover); ok {
+				ctrl.cache = r
+			}
+		}
+		log.Infof("Connecting to %s", cfg.broker)
+		client.Connect()
+		x.Start()
+		return nil
+	})
+	c.OnShutdown(func() error {
+		x.Stop()
+		client.Disconnect(250)
+		return nil
+	})
+
+	h := event.Handler{Plugin: "mqtt", Exporters: []*event.Exporter{x}, Hostname: hostname}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		if ctrl != nil {
+			next = controlHandler{Next: next, Control: ctrl, Hostname: hostname}
+		}
+		h.Next = next
+		return h
+	})
 
 	return nil
 }
 
-// func setup(c *caddy.Controller) error {
-// 	rules, err := logParse(c)
-// 	if err != nil {
-// 		return plugin.Error("log", err)
-// 	}
-
-// 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-// 		return Logger{Next: next, Rules: rules, repl: replacer.New()}
-// 	})
-
-// 	return nil
-// }
-
-// func logParse(c *caddy.Controller) ([]Rule, error) {
-// 	var rules []Rule
-
-// 	for c.Next() {
-// 		args := c.RemainingArgs()
-// 		length := len(rules)
-
-// 		switch len(args) {
-// 		case 0:
-// 			// Nothing specified; use defaults
-// 			rules = append(rules, Rule{
-// 				NameScope: ".",
-// 				Format:    DefaultLogFormat,
-// 				Class:     make(map[response.Class]struct{}),
-// 			})
-// 		case 1:
-// 			rules = append(rules, Rule{
-// 				NameScope: dns.Fqdn(args[0]),
-// 				Format:    DefaultLogFormat,
-// 				Class:     make(map[response.Class]struct{}),
-// 			})
-// 		default:
-// 			// Name scopes, and maybe a format specified
-// 			format := DefaultLogFormat
-
-// 			if strings.Contains(args[len(args)-1], "{") {
-// 				format = args[len(args)-1]
-// 				format = strings.Replace(format, "{common}", CommonLogFormat, -1)
-// 				format = strings.Replace(format, "{combined}", CombinedLogFormat, -1)
-// 				args = args[:len(args)-1]
-// 			}
-
-// 			for _, str := range args {
-// 				rules = append(rules, Rule{
-// 					NameScope: dns.Fqdn(str),
-// 					Format:    format,
-// 					Class:     make(map[response.Class]struct{}),
-// 				})
-// 			}
-// 		}
-
-// 		// Class refinements in an extra block.
-// 		classes := make(map[response.Class]struct{})
-// 		for c.NextBlock() {
-// 			switch c.Val() {
-// 			// class followed by combinations of all, denial, error and success.
-// 			case "class":
-// 				classesArgs := c.RemainingArgs()
-// 				if len(classesArgs) == 0 {
-// 					return nil, c.ArgErr()
-// 				}
-// 				for _, c := range classesArgs {
-// 					cls, err := response.ClassFromString(c)
-// 					if err != nil {
-// 						return nil, err
-// 					}
-// 					classes[cls] = struct{}{}
-// 				}
-// 			default:
-// 				return nil, c.ArgErr()
-// 			}
-// 		}
-// 		if len(classes) == 0 {
-// 			classes[response.All] = struct{}{}
-// 		}
-
-// 		for i := len(rules) - 1; i >= length; i-- {
-// 			rules[i].Class = classes
-// 		}
-// 	}
-
-// 	return rules, nil
-// }
+func mqttParse(c *caddy.Controller) (*config, error) {
+	topic, _ := ParseTopic(defaultTopic)
+	cfg := &config{
+		exporter: event.Config{
+			QueueSize:     defaultQueueSize,
+			BatchSize:     defaultBatchSize,
+			FlushInterval: defaultBatchTimeout,
+		},
+		topic:          topic,
+		cleanSession:   true,
+		publishTimeout: defaultPublishTimeout,
+	}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		cfg.broker = args[0]
+
+		var (
+			tlsArgs    []string
+			serverName string
+			passwords  int
+		)
+		for c.NextBlock() {
+			if ok, err := cfg.exporter.Parse(c); ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switch c.Val() {
+			case "topic":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				t, err := ParseTopic(args[0])
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				cfg.topic = t
+			case "fields":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, a := range args {
+					f, err := event.FieldsFromString(a)
+					if err != nil {
+						return nil, c.Err(err.Error())
+					}
+					cfg.exporter.Fields |= f
+				}
+			case "control":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				b, err := os.ReadFile(args[1])
+				if err != nil {
+					return nil, c.Errf("could not read control secret: %s", err)
+				}
+				secret := strings.TrimRight(string(b), "\r\n")
+				if secret == "" {
+					return nil, c.Err("control secret is empty")
+				}
+				cfg.control, cfg.secret = args[0], []byte(secret)
+			case "encoding":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				e, err := event.EncodingFromString(args[0])
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				cfg.encoding = e
+			case "qos":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "0", "1", "2":
+					cfg.qos = args[0][0] - '0'
+				default:
+					return nil, c.Errf("qos provided is invalid: %s", args[0])
+				}
+			case "retain":
+				if c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				cfg.retain = true
+			case "client_id":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				cfg.clientID = args[0]
+			case "username":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				cfg.username = args[0]
+			case "password_file":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				b, err := os.ReadFile(args[0])
+				if err != nil {
+					return nil, c.Errf("could not read password file: %s", err)
+				}
+				cfg.password = strings.TrimRight(string(b), "\r\n")
+				passwords++
+			case "password_env":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				v, ok := os.LookupEnv(args[0])
+				if !ok {
+					return nil, c.Errf("environment variable %s is not set", args[0])
+				}
+				cfg.password = v
+				passwords++
+			case "tls":
+				tlsArgs = c.RemainingArgs()
+				if len(tlsArgs) > 3 {
+					return nil, c.ArgErr()
+				}
+				if tlsArgs == nil {
+					tlsArgs = []string{}
+				}
+			case "tls_servername":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				serverName = c.Val()
+			case "clean_session":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				b, err := strconv.ParseBool(args[0])
+				if err != nil {
+					return nil, c.Errf("clean_session provided is invalid: %s", args[0])
+				}
+				cfg.cleanSession = b
+			case "keepalive":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d < time.Second {
+					return nil, c.Errf("keepalive provided is invalid: %s", d)
+				}
+				cfg.keepalive = d
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d <= 0 {
+					return nil, c.Errf("timeout provided is invalid: %s", d)
+				}
+				cfg.publishTimeout = d
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+
+		if passwords > 1 {
+			return nil, c.Err("password_file and password_env are mutually exclusive")
+		}
+		if passwords > 0 && cfg.username == "" {
+			return nil, c.Err("a password requires a username")
+		}
+		if serverName != "" && tlsArgs == nil {
+			return nil, c.Err("tls_servername requires tls")
+		}
+		if tlsArgs != nil {
+			u, err := url.Parse(cfg.broker)
+			if err != nil {
+				return nil, c.Errf("invalid broker: %s", err)
+			}
+			switch u.Scheme {
+			case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+			default:
+				return nil, c.Errf("tls requires a broker with a TLS scheme, like ssl://, found %s://", u.Scheme)
+			}
+			cfg.tls, err = pkgtls.NewTLSConfigFromArgs(tlsArgs...)
+			if err != nil {
+				return nil, c.Err(err.Error())
+			}
+			cfg.tls.ServerName = serverName
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,269 @@
+package mqtt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	"github.com/coredns/coredns/plugin/pkg/response"
+
+	"github.com/miekg/dns"
+)
+
+func TestMqttParse(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedErr   string
+		expectedQueue int
+		expectedDrop  event.DropPolicy
+		expectedBatch int
+		expectedWait  time.Duration
+	}{
+		{`mqtt tcp://localhost:1883`, false, "", defaultQueueSize, event.DropNewest, 1, defaultBatchTimeout},
+		{`mqtt tcp://localhost:1883 {
+			queue 100
+			drop oldest
+			batch 50 1s
+			timeout 2s
+		}`, false, "", 100, event.DropOldest, 50, time.Second},
+		{`mqtt tcp://localhost:1883 {
+			batch 10
+		}`, false, "", defaultQueueSize, event.DropNewest, 10, defaultBatchTimeout},
+		// fails
+		{`mqtt`, true, "Wrong argument count", 0, 0, 0, 0},
+		{`mqtt tcp://localhost:1883 {
+			queue 0
+		}`, true, "queue size provided is invalid", 0, 0, 0, 0},
+		{`mqtt tcp://localhost:1883 {
+			drop random
+		}`, true, "unknown drop policy", 0, 0, 0, 0},
+		{`mqtt tcp://localhost:1883 {
+			batch 10 -1s
+		}`, true, "flush interval provided is invalid", 0, 0, 0, 0},
+		{`mqtt tcp://localhost:1883 {
+			bogus
+		}`, true, "unknown property", 0, 0, 0, 0},
+		{`mqtt tcp://localhost:1883
+		mqtt tcp://localhost:1883`, true, "this plugin", 0, 0, 0, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := mqttParse(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if cfg.exporter.QueueSize != test.expectedQueue {
+			t.Errorf("Test %d: expected queue size %d, got %d", i, test.expectedQueue, cfg.exporter.QueueSize)
+		}
+		if cfg.exporter.Drop != test.expectedDrop {
+			t.Errorf("Test %d: expected drop policy %d, got %d", i, test.expectedDrop, cfg.exporter.Drop)
+		}
+		if cfg.exporter.BatchSize != test.expectedBatch || cfg.exporter.FlushInterval != test.expectedWait {
+			t.Errorf("Test %d: expected batch %d %s, got %d %s", i, test.expectedBatch, test.expectedWait, cfg.exporter.BatchSize, cfg.exporter.FlushInterval)
+		}
+	}
+}
+
+func TestMqttParseClient(t *testing.T) {
+	password := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(password, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", `mqtt ssl://mqtt.example.org:8883 {
+		topic dns/{zone}/{rcode}
+		qos 1
+		retain
+		client_id ns1-fdns
+		username fdns
+		password_file `+password+`
+		tls
+		tls_servername mqtt.example.net
+		clean_session false
+		keepalive 10s
+	}`)
+	cfg, err := mqttParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if cfg.topic.String() != "dns/{zone}/{rcode}" || cfg.qos != 1 || !cfg.retain {
+		t.Errorf("Unexpected topic %q, qos %d or retain %t", cfg.topic, cfg.qos, cfg.retain)
+	}
+	if cfg.clientID != "ns1-fdns" || cfg.username != "fdns" || cfg.password != "s3cret" {
+		t.Errorf("Unexpected client ID %q, username %q or password %q", cfg.clientID, cfg.username, cfg.password)
+	}
+	if cfg.tls == nil || cfg.tls.ServerName != "mqtt.example.net" {
+		t.Errorf("Expected a TLS config for mqtt.example.net, got %v", cfg.tls)
+	}
+	if cfg.cleanSession || cfg.keepalive != 10*time.Second {
+		t.Errorf("Unexpected clean session %t or keepalive %s", cfg.cleanSession, cfg.keepalive)
+	}
+
+	t.Setenv("MQTT_PASSWORD", "from-env")
+	c = caddy.NewTestController("dns", `mqtt tcp://localhost:1883 {
+		username fdns
+		password_env MQTT_PASSWORD
+	}`)
+	cfg, err = mqttParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if cfg.password != "from-env" || !cfg.cleanSession || cfg.topic.String() != defaultTopic {
+		t.Errorf("Unexpected password %q, clean session %t or topic %q", cfg.password, cfg.cleanSession, cfg.topic)
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"topic dns/+/x", "wildcard"},
+		{"topic dns/{bogus}", "unknown placeholder"},
+		{"qos 3", "qos provided is invalid"},
+		{"retain yes", "Wrong argument count"},
+		{"username fdns\npassword_file /nonexistent", "could not read password file"},
+		{"username fdns\npassword_env MQTT_UNSET_PASSWORD", "is not set"},
+		{"username fdns\npassword_file " + password + "\npassword_env MQTT_PASSWORD", "mutually exclusive"},
+		{"password_env MQTT_PASSWORD", "requires a username"},
+		{"tls", "requires a broker with a TLS scheme"},
+		{"tls_servername mqtt.example.org", "requires tls"},
+		{"clean_session maybe", "clean_session provided is invalid"},
+		{"keepalive 10ms", "keepalive provided is invalid"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "mqtt tcp://localhost:1883 {\n"+test.input+"\n}")
+		if _, err := mqttParse(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
+
+func TestMqttParseRules(t *testing.T) {
+	c := caddy.NewTestController("dns", `mqtt tcp://localhost:1883 {
+		rule example.org example.net class denial error type A aaaa
+		rule . sample 0.01
+	}`)
+	cfg, err := mqttParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(cfg.exporter.Rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(cfg.exporter.Rules))
+	}
+	r := cfg.exporter.Rules[1]
+	if r.NameScope != "example.net." || len(r.Class) != 2 || len(r.Types) != 2 || r.Sample != 1 {
+		t.Errorf("Unexpected rule %+v", r)
+	}
+	if _, ok := r.Types[dns.TypeAAAA]; !ok {
+		t.Errorf("Expected rule for AAAA, got %+v", r)
+	}
+	r = cfg.exporter.Rules[2]
+	if _, ok := r.Class[response.All]; r.NameScope != "." || !ok || len(r.Types) != 0 || r.Sample != 0.01 {
+		t.Errorf("Unexpected rule %+v", r)
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"rule", "Wrong argument count"},
+		{"rule class denial", "Wrong argument count"},
+		{"rule . class", "Wrong argument count"},
+		{"rule . class failure", "invalid Class"},
+		{"rule . type BOGUS", "unknown query type"},
+		{"rule . sample 0", "sample rate provided is invalid"},
+		{"rule . sample 1.5", "sample rate provided is invalid"},
+		{"rule . sample 0.1 0.2", "Wrong argument count"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "mqtt tcp://localhost:1883 {\n"+test.input+"\n}")
+		if _, err := mqttParse(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
+
+func TestMqttParseEvent(t *testing.T) {
+	c := caddy.NewTestController("dns", `mqtt tcp://localhost:1883 {
+		fields transport edns
+		fields metadata
+		encoding protobuf
+	}`)
+	cfg, err := mqttParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if cfg.exporter.Fields != event.Transport|event.EDNS0|event.Metadata || cfg.encoding != event.Protobuf {
+		t.Errorf("Unexpected fields %d or encoding %s", cfg.exporter.Fields, cfg.encoding)
+	}
+
+	cfg, _ = mqttParse(caddy.NewTestController("dns", `mqtt tcp://localhost:1883`))
+	if cfg.exporter.Fields != 0 || cfg.encoding != event.JSON {
+		t.Errorf("Expected no optional fields in JSON, got fields %d and encoding %s", cfg.exporter.Fields, cfg.encoding)
+	}
+
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"fields", "Wrong argument count"},
+		{"fields transport bogus", "unknown field"},
+		{"encoding", "Wrong argument count"},
+		{"encoding xml", "unknown encoding"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "mqtt tcp://localhost:1883 {\n"+test.input+"\n}")
+		if _, err := mqttParse(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
+
+func TestMqttParseControl(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := caddy.NewTestController("dns", "mqtt tcp://localhost:1883 {\ncontrol fdns/control "+secret+"\n}")
+	cfg, err := mqttParse(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if cfg.control != "fdns/control" || string(cfg.secret) != "s3cret" {
+		t.Errorf("Unexpected control topic %q or secret %q", cfg.control, cfg.secret)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input       string
+		expectedErr string
+	}{
+		{"control fdns/control", "Wrong argument count"},
+		{"control fdns/control /nonexistent", "could not read control secret"},
+		{"control fdns/control " + empty, "control secret is empty"},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "mqtt tcp://localhost:1883 {\n"+test.input+"\n}")
+		if _, err := mqttParse(c); err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErr, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topic is a parsed topic template. Placeholders in the template are replaced with values taken
+// from the query, so events can be published to per-zone or per-rcode topics.
+type Topic []topicNode
+
+type topicNode struct {
+	literal     string
+	placeholder string // Empty for literal nodes.
+}
+
+// placeholders are the placeholders that can be used in a topic template.
+var placeholders = map[string]struct{}{
+	"{zone}":     {},
+	"{name}":     {},
+	"{type}":     {},
+	"{rcode}":    {},
+	"{hostname}": {},
+}
+
+// TopicValues are the values the placeholders of a topic template are replaced with.
+type TopicValues struct {
+	Zone, Name, Type, Rcode, Hostname string
+}
+
+// ParseTopic parses the topic template s. It returns an error for unknown placeholders and for
+// templates containing the MQTT wildcards '+' and '#', which can't be used to publish.
+func ParseTopic(s string) (Topic, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty topic")
+	}
+	if strings.ContainsAny(s, "+#") {
+		return nil, fmt.Errorf("topic %q contains a wildcard", s)
+	}
+
+	var t Topic
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			t = append(t, topicNode{literal: s})
+			break
+		}
+		if i > 0 {
+			t = append(t, topicNode{literal: s[:i]})
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in topic %q", s)
+		}
+		p := s[i : i+j+1]
+		if _, ok := placeholders[p]; !ok {
+			return nil, fmt.Errorf("unknown placeholder %s in topic", p)
+		}
+		t = append(t, topicNode{placeholder: p})
+		s = s[i+j+1:]
+	}
+	return t, nil
+}
+
+// Render returns the topic for the query described by v. Domain names are written without their
+// final dot, characters that have a special meaning in topics are replaced with '_'.
+func (t Topic) Render(v TopicValues) string {
+	var b strings.Builder
+	for _, n := range t {
+		switch n.placeholder {
+		case "":
+			b.WriteString(n.literal)
+		case "{zone}":
+			b.WriteString(topicLevel(trimDot(v.Zone)))
+		case "{name}":
+			b.WriteString(topicLevel(trimDot(v.Name)))
+		case "{type}":
+			b.WriteString(topicLevel(v.Type))
+		case "{rcode}":
+			b.WriteString(topicLevel(v.Rcode))
+		case "{hostname}":
+			b.WriteString(topicLevel(v.Hostname))
+		}
+	}
+	return b.String()
+}
+
+// String returns the template t was parsed from.
+func (t Topic) String() string {
+	var b strings.Builder
+	for _, n := range t {
+		b.WriteString(n.literal)
+		b.WriteString(n.placeholder)
+	}
+	return b.String()
+}
+
+// trimDot removes the final dot of name, the root zone is returned as is.
+func trimDot(name string) string {
+	if len(name) > 1 {
+		return strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// topicLevel makes s safe to use within a single topic level: the level separator, the wildcards
+// and NUL are replaced with '_'. An empty value is replaced with '-'.
+func topicLevel(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return levelReplacer.Replace(s)
+}
+
+var levelReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_", "\x00", "_")
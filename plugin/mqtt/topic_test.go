@@ -0,0 +1,57 @@
+package mqtt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopic(t *testing.T) {
+	v := TopicValues{Zone: "example.org.", Name: "a/b+c.example.org.", Type: "A", Rcode: "NOERROR", Hostname: "ns1"}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"fdns", "fdns"},
+		{"dns/{zone}/{rcode}", "dns/example.org/NOERROR"},
+		{"{hostname}/{type}/{name}", "ns1/A/a_b_c.example.org"},
+		{"dns/{zone}", "dns/example.org"},
+	}
+	for i, tc := range tests {
+		topic, err := ParseTopic(tc.template)
+		if err != nil {
+			t.Fatalf("Test %d: %s", i, err)
+		}
+		if got := topic.Render(v); got != tc.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, tc.expected, got)
+		}
+		if topic.String() != tc.template {
+			t.Errorf("Test %d: expected template %q, got %q", i, tc.template, topic.String())
+		}
+	}
+
+	topic, _ := ParseTopic("dns/{zone}")
+	if got := topic.Render(TopicValues{Zone: "."}); got != "dns/." {
+		t.Errorf("Expected the root zone as %q, got %q", "dns/.", got)
+	}
+	if got := topic.Render(TopicValues{}); got != "dns/-" {
+		t.Errorf("Expected an empty value as %q, got %q", "dns/-", got)
+	}
+}
+
+func TestParseTopicErrors(t *testing.T) {
+	tests := []struct {
+		template    string
+		expectedErr string
+	}{
+		{"", "empty topic"},
+		{"dns/#", "wildcard"},
+		{"dns/+/x", "wildcard"},
+		{"dns/{zone", "unterminated"},
+		{"dns/{qname}", "unknown placeholder"},
+	}
+	for i, tc := range tests {
+		if _, err := ParseTopic(tc.template); err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, tc.expectedErr, err)
+		}
+	}
+}
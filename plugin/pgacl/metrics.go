@@ -0,0 +1,32 @@
+package pgacl
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount is the number of queries by the action taken.
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "pgacl",
+		Name:      "requests_total",
+		Help:      "Counter of requests by the action of the rule they matched.",
+	}, []string{"server", "action"})
+	// ruleCount is the number of loaded rules.
+	ruleCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "pgacl",
+		Name:      "rules",
+		Help:      "Number of rules loaded from the database.",
+	})
+	// reloadCount is the number of times the rules were loaded, by result.
+	reloadCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "pgacl",
+		Name:      "reloads_total",
+		Help:      "Counter of rule reloads, by result.",
+	}, []string{"result"})
+)
@@ -0,0 +1,89 @@
+// Package pgacl implements a plugin that allows, denies, refuses or sinkholes queries with rules
+// loaded from PostgreSQL.
+package pgacl
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("pgacl")
+
+// PgACL applies the rules of its store to the queries.
+type PgACL struct {
+	Next  plugin.Handler
+	Store *Store
+
+	Sinkhole4 net.IP // Address of the A record of a sinkholed name.
+	Sinkhole6 net.IP // Address of the AAAA record of a sinkholed name.
+	TTL       uint32 // TTL of the sinkhole records.
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (p PgACL) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+
+	addr := state.IP()
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		// Like the acl plugin, refuse what can't be matched.
+		log.Errorf("Refusing request, unable to parse source address: %v", state.IP())
+		return p.reply(ctx, w, r, actionRefuse)
+	}
+
+	a := p.Store.Rules().Match(ip, state.Name(), state.QType())
+	if a == actionNone || a == actionAllow {
+		requestCount.WithLabelValues(metrics.WithServer(ctx), a.String()).Inc()
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+	return p.reply(ctx, w, r, a)
+}
+
+// reply writes the response for action a.
+func (p PgACL) reply(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, a action) (int, error) {
+	requestCount.WithLabelValues(metrics.WithServer(ctx), a.String()).Inc()
+	state := request.Request{W: w, Req: r}
+
+	m := new(dns.Msg)
+	switch a {
+	case actionDeny:
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Ns = p.soa(state.QName())
+	case actionRefuse:
+		m.SetRcode(r, dns.RcodeRefused)
+	case actionSinkhole:
+		m.SetReply(r)
+		hdr := dns.RR_Header{Name: state.QName(), Rrtype: state.QType(), Class: dns.ClassINET, Ttl: p.TTL}
+		switch {
+		case state.QType() == dns.TypeA && p.Sinkhole4 != nil:
+			m.Answer = []dns.RR{&dns.A{Hdr: hdr, A: p.Sinkhole4}}
+		case state.QType() == dns.TypeAAAA && p.Sinkhole6 != nil:
+			m.Answer = []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: p.Sinkhole6}}
+		default:
+			m.Ns = p.soa(state.QName())
+		}
+	}
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
+
+// soa returns the synthetic SOA record of the negative replies for name, so resolvers can cache
+// them for the sinkhole TTL.
+func (p PgACL) soa(name string) []dns.RR {
+	hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: p.TTL}
+	return []dns.RR{&dns.SOA{Hdr: hdr, Ns: "pgacl.invalid.", Mbox: "hostmaster.pgacl.invalid.", Serial: 1, Refresh: 0, Retry: 0, Expire: 0, Minttl: p.TTL}}
+}
+
+// Name implements the plugin.Handler interface.
+func (p PgACL) Name() string { return "pgacl" }
@@ -0,0 +1,78 @@
+package pgacl
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNS(t *testing.T) {
+	store := NewStore(nil, defaultTable, 0)
+	store.rules, _ = compile([]row{
+		{ID: 1, Action: "deny", Name: str("deny.example.org.")},
+		{ID: 2, Action: "refuse", Name: str("refuse.example.org.")},
+		{ID: 3, Action: "sinkhole", Name: str("sinkhole.example.org.")},
+		{ID: 4, Priority: -1, Action: "allow", Name: str("allow.deny.example.org.")},
+	})
+	p := PgACL{Next: test.NextHandler(dns.RcodeSuccess, nil), Store: store, Sinkhole4: net.IPv4zero, Sinkhole6: net.IPv6unspecified, TTL: 60}
+
+	tests := []struct {
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expectedRR    string
+		expectedSOA   bool
+	}{
+		{"www.example.org.", dns.TypeA, dns.RcodeSuccess, "", false},
+		{"allow.deny.example.org.", dns.TypeA, dns.RcodeSuccess, "", false},
+		{"www.deny.example.org.", dns.TypeA, dns.RcodeNameError, "", true},
+		{"refuse.example.org.", dns.TypeA, dns.RcodeRefused, "", false},
+		{"sinkhole.example.org.", dns.TypeA, dns.RcodeSuccess, "sinkhole.example.org.\t60\tIN\tA\t0.0.0.0", false},
+		{"sinkhole.example.org.", dns.TypeAAAA, dns.RcodeSuccess, "sinkhole.example.org.\t60\tIN\tAAAA\t::", false},
+		{"sinkhole.example.org.", dns.TypeMX, dns.RcodeSuccess, "", true},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := p.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if rec.Msg == nil {
+			// Passed to the next plugin, which writes nothing.
+			if tc.expectedRcode != dns.RcodeSuccess || tc.expectedRR != "" {
+				t.Errorf("Test %d: expected a reply for %s", i, tc.qname)
+			}
+			continue
+		}
+		if rec.Msg.Rcode != tc.expectedRcode {
+			t.Errorf("Test %d: expected rcode %d, got %d", i, tc.expectedRcode, rec.Msg.Rcode)
+		}
+		switch {
+		case tc.expectedRR == "" && len(rec.Msg.Answer) != 0:
+			t.Errorf("Test %d: expected no answer, got %v", i, rec.Msg.Answer)
+		case tc.expectedRR != "" && (len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].String() != tc.expectedRR):
+			t.Errorf("Test %d: expected answer %q, got %v", i, tc.expectedRR, rec.Msg.Answer)
+		}
+		if !tc.expectedSOA {
+			if len(rec.Msg.Ns) != 0 {
+				t.Errorf("Test %d: expected no authority section, got %v", i, rec.Msg.Ns)
+			}
+			continue
+		}
+		if len(rec.Msg.Ns) != 1 {
+			t.Errorf("Test %d: expected an SOA record, got %v", i, rec.Msg.Ns)
+			continue
+		}
+		soa, ok := rec.Msg.Ns[0].(*dns.SOA)
+		if !ok || soa.Header().Name != tc.qname || soa.Header().Ttl != 60 || soa.Minttl != 60 {
+			t.Errorf("Test %d: expected an SOA record for %s with TTL 60, got %v", i, tc.qname, rec.Msg.Ns[0])
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package pgacl
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// action is what happens to a query that matches a rule.
+type action int
+
+const (
+	// actionNone passes a query that matches no rule to the next plugin.
+	actionNone action = iota
+	// actionAllow passes the query to the next plugin, without trying the other rules.
+	actionAllow
+	// actionDeny answers the query with NXDOMAIN.
+	actionDeny
+	// actionRefuse answers the query with REFUSED.
+	actionRefuse
+	// actionSinkhole answers the query with the sinkhole addresses.
+	actionSinkhole
+)
+
+var actions = map[string]action{
+	"allow":    actionAllow,
+	"deny":     actionDeny,
+	"refuse":   actionRefuse,
+	"sinkhole": actionSinkhole,
+}
+
+func (a action) String() string {
+	for s, v := range actions {
+		if v == a {
+			return s
+		}
+	}
+	return "none"
+}
+
+// row is a row of the rules table. Net, Name and Type are nil when the rule matches all
+// clients, names or types.
+type row struct {
+	ID       int64
+	Priority int32
+	Action   string
+	Net      *string
+	Name     *string
+	Type     *string
+}
+
+// rule is a parsed row.
+type rule struct {
+	id       int64
+	priority int32
+	action   action
+	net      *net.IPNet // Nil matches all clients.
+	qtype    uint16     // dns.TypeNone matches all types.
+}
+
+// before returns true when r is tried before o: rules with a lower priority are tried first, and
+// rules with the same priority in the order they were added.
+func (r *rule) before(o *rule) bool {
+	if r.priority != o.priority {
+		return r.priority < o.priority
+	}
+	return r.id < o.id
+}
+
+func (r *rule) matches(ip net.IP, qtype uint16) bool {
+	if r.qtype != dns.TypeNone && r.qtype != qtype {
+		return false
+	}
+	return r.net == nil || r.net.Contains(ip)
+}
+
+// Rules holds the rules, indexed by the name suffix they apply to. A Rules is never modified
+// after it has been compiled, a reload replaces it.
+type Rules struct {
+	names map[string][]*rule // Rules by name, sorted by the order they are tried in.
+	count int
+}
+
+// compile parses rows into Rules. Rows that can't be parsed are skipped, they are returned as errors.
+func compile(rows []row) (*Rules, []error) {
+	rs := &Rules{names: make(map[string][]*rule)}
+	var errs []error
+	for _, rw := range rows {
+		r, name, err := parse(rw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %s", rw.ID, err))
+			continue
+		}
+		rs.names[name] = append(rs.names[name], r)
+		rs.count++
+	}
+	for _, rules := range rs.names {
+		sort.Slice(rules, func(i, j int) bool { return rules[i].before(rules[j]) })
+	}
+	return rs, errs
+}
+
+// parse parses rw and returns its rule and the name it applies to.
+func parse(rw row) (*rule, string, error) {
+	r := &rule{id: rw.ID, priority: rw.Priority}
+	var ok bool
+	if r.action, ok = actions[strings.ToLower(rw.Action)]; !ok {
+		return nil, "", fmt.Errorf("unknown action '%s'", rw.Action)
+	}
+
+	if rw.Net != nil && *rw.Net != "" {
+		s := *rw.Net
+		if !strings.Contains(s, "/") {
+			// A single address, as a CIDR column without a prefix length is printed.
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid net '%s'", *rw.Net)
+		}
+		r.net = n
+	}
+
+	name := "."
+	if rw.Name != nil && *rw.Name != "" {
+		name = dns.Fqdn(strings.ToLower(*rw.Name))
+		if _, ok := dns.IsDomainName(name); !ok {
+			return nil, "", fmt.Errorf("invalid name '%s'", *rw.Name)
+		}
+	}
+
+	if rw.Type != nil && *rw.Type != "" && *rw.Type != "*" {
+		if r.qtype, ok = dns.StringToType[strings.ToUpper(*rw.Type)]; !ok {
+			return nil, "", fmt.Errorf("invalid type '%s'", *rw.Type)
+		}
+	}
+	return r, name, nil
+}
+
+// Len returns the number of rules.
+func (rs *Rules) Len() int { return rs.count }
+
+// Match returns the action of the first rule that matches a query for qname and qtype from ip.
+// A rule for a name matches the name and all names below it.
+func (rs *Rules) Match(ip net.IP, qname string, qtype uint16) action {
+	best := rs.first(".", ip, qtype, nil)
+	if qname != "." {
+		for off, end := 0, false; !end; off, end = dns.NextLabel(qname, off) {
+			best = rs.first(qname[off:], ip, qtype, best)
+		}
+	}
+	if best == nil {
+		return actionNone
+	}
+	return best.action
+}
+
+// first returns the first rule for name that matches, when it is tried before best. Otherwise it
+// returns best.
+func (rs *Rules) first(name string, ip net.IP, qtype uint16, best *rule) *rule {
+	for _, r := range rs.names[name] {
+		if best != nil && best.before(r) {
+			break
+		}
+		if r.matches(ip, qtype) {
+			return r
+		}
+	}
+	return best
+}
@@ -0,0 +1,66 @@
+package pgacl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func str(s string) *string { return &s }
+
+func TestCompile(t *testing.T) {
+	rows := []row{
+		{ID: 1, Action: "deny", Name: str("ads.example.org")},
+		{ID: 2, Action: "bogus"},
+		{ID: 3, Action: "allow", Net: str("not-a-net")},
+		{ID: 4, Action: "refuse", Type: str("BOGUS")},
+		{ID: 5, Action: "Sinkhole", Net: str("192.0.2.1"), Type: str("*")},
+	}
+	rules, errs := compile(rows)
+	if rules.Len() != 2 {
+		t.Errorf("Expected 2 rules, got %d", rules.Len())
+	}
+	if len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got %v", errs)
+	}
+	if r := rules.names["."]; len(r) != 1 || r[0].net.String() != "192.0.2.1/32" || r[0].qtype != dns.TypeNone {
+		t.Errorf("Expected a rule for 192.0.2.1/32 and all types")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules, errs := compile([]row{
+		{ID: 1, Priority: 10, Action: "deny", Name: str("example.org.")},
+		{ID: 2, Priority: 0, Action: "allow", Name: str("www.example.org."), Net: str("10.0.0.0/8")},
+		{ID: 3, Priority: 5, Action: "sinkhole", Name: str("ads.example.org."), Type: str("A")},
+		{ID: 4, Priority: 20, Action: "refuse", Net: str("192.0.2.0/24")},
+		{ID: 5, Priority: 10, Action: "refuse", Name: str("example.org.")},
+		{ID: 6, Priority: 1, Action: "deny", Net: str("2001:db8::/32")},
+	})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	tests := []struct {
+		ip       string
+		qname    string
+		qtype    uint16
+		expected action
+	}{
+		{"10.1.1.1", "www.example.org.", dns.TypeA, actionAllow},
+		{"192.0.2.1", "www.example.org.", dns.TypeA, actionDeny}, // The first of equal priorities.
+		{"192.0.2.1", "ads.example.org.", dns.TypeA, actionSinkhole},
+		{"192.0.2.1", "ads.example.org.", dns.TypeMX, actionDeny},
+		{"192.0.2.1", "example.net.", dns.TypeA, actionRefuse},
+		{"198.51.100.1", "example.net.", dns.TypeA, actionNone},
+		{"198.51.100.1", "EXAMPLE.org.", dns.TypeA, actionNone}, // Names are lowercased by request.
+		{"2001:db8::1", "www.example.org.", dns.TypeAAAA, actionDeny},
+		{"2001:db8::1", ".", dns.TypeNS, actionDeny},
+	}
+	for i, tc := range tests {
+		if got := rules.Match(net.ParseIP(tc.ip), tc.qname, tc.qtype); got != tc.expected {
+			t.Errorf("Test %d: expected %s for %s from %s, got %s", i, tc.expected, tc.qname, tc.ip, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+-- The rules pgacl applies to the queries. A rule matches the queries from the clients in net, for
+-- name and the names below it, of type. NULL matches all clients, names or types. The rules are
+-- tried by priority, lowest first, and the first rule that matches decides.
+CREATE TABLE IF NOT EXISTS acl_rules (
+    id BIGSERIAL PRIMARY KEY,
+    priority INTEGER NOT NULL DEFAULT 0,
+    action TEXT NOT NULL CHECK (action IN ('allow', 'deny', 'refuse', 'sinkhole')),
+    net CIDR,
+    name TEXT,
+    type TEXT
+);
+
+-- Tell pgacl the rules changed, so it reloads them. A notification per statement is enough, the
+-- rules are always reloaded as a whole.
+CREATE OR REPLACE FUNCTION pgacl_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('pgacl', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS acl_rules_notify ON acl_rules;
+CREATE TRIGGER acl_rules_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON acl_rules
+    FOR EACH STATEMENT EXECUTE FUNCTION pgacl_notify();
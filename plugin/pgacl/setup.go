@@ -0,0 +1,182 @@
+package pgacl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/fdns"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func init() { plugin.Register("pgacl", setup) }
+
+const (
+	defaultTable   = "acl_rules"
+	defaultTimeout = 30 * time.Second
+	defaultTTL     = 60
+)
+
+// config holds everything parsed from the Corefile.
+type config struct {
+	pool      *pgxpool.Config // Nil when the pool of an fdns instance is used.
+	poolName  string          // Name of the fdns pool.
+	table     string
+	timeout   time.Duration
+	sinkhole4 net.IP
+	sinkhole6 net.IP
+	ttl       uint32
+}
+
+func setup(c *caddy.Controller) error {
+	cfg, err := pgaclParse(c)
+	if err != nil {
+		return plugin.Error("pgacl", err)
+	}
+
+	var own *pgxpool.Pool
+	if cfg.pool != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		own, err = pgxpool.ConnectConfig(ctx, cfg.pool)
+		cancel()
+		if err != nil {
+			return plugin.Error("pgacl", err)
+		}
+	}
+
+	store := NewStore(own, cfg.table, cfg.timeout)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.OnStartup(func() error {
+		// fdns shares its pool while it is set up, it is there once the servers start.
+		if cfg.poolName != "" {
+			if store.pool = fdns.Pool(cfg.poolName); store.pool == nil {
+				return plugin.Error("pgacl", fmt.Errorf("no fdns pool named '%s'", cfg.poolName))
+			}
+		}
+		if err := store.Load(ctx); err != nil {
+			return plugin.Error("pgacl", err)
+		}
+		go store.Listen(ctx)
+		return nil
+	})
+	c.OnShutdown(func() error {
+		cancel()
+		if own != nil {
+			own.Close()
+		}
+		return nil
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		return PgACL{Next: next, Store: store, Sinkhole4: cfg.sinkhole4, Sinkhole6: cfg.sinkhole6, TTL: cfg.ttl}
+	})
+
+	return nil
+}
+
+func pgaclParse(c *caddy.Controller) (*config, error) {
+	cfg := &config{
+		table:     defaultTable,
+		timeout:   defaultTimeout,
+		sinkhole4: net.IPv4zero,
+		sinkhole6: net.IPv6unspecified,
+		ttl:       defaultTTL,
+	}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+		if len(args) > 1 {
+			return nil, c.ArgErr()
+		}
+		if len(args) == 1 {
+			var err error
+			if cfg.pool, err = pgxpool.ParseConfig(args[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		for c.NextBlock() {
+			switch c.Val() {
+			case "pool":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				cfg.poolName = args[0]
+			case "table":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				cfg.table = args[0]
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d < 0 {
+					return nil, c.Errf("timeout provided is invalid: %s", d)
+				}
+				cfg.timeout = d
+			case "sinkhole":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				cfg.sinkhole4, cfg.sinkhole6 = nil, nil
+				for _, a := range args {
+					ip := net.ParseIP(a)
+					switch {
+					case ip == nil:
+						return nil, c.Errf("sinkhole address provided is invalid: %s", a)
+					case ip.To4() != nil && cfg.sinkhole4 == nil:
+						cfg.sinkhole4 = ip.To4()
+					case ip.To4() == nil && cfg.sinkhole6 == nil:
+						cfg.sinkhole6 = ip
+					default:
+						return nil, c.Errf("sinkhole has two addresses of the same family: %s", a)
+					}
+				}
+			case "ttl":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				ttl, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if ttl < 0 || ttl > 2147483647 {
+					return nil, c.Errf("ttl provided is invalid: %d", ttl)
+				}
+				cfg.ttl = uint32(ttl)
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+	}
+
+	switch {
+	case cfg.pool == nil && cfg.poolName == "":
+		return nil, c.Err("either a connection string or an fdns pool is required")
+	case cfg.pool != nil && cfg.poolName != "":
+		return nil, c.Err("a connection string and an fdns pool are mutually exclusive")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,99 @@
+package pgacl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestPgaclParse(t *testing.T) {
+	tests := []struct {
+		input             string
+		shouldErr         bool
+		expectedErr       string
+		expectedPool      string
+		expectedTable     string
+		expectedTimeout   time.Duration
+		expectedSinkhole4 string
+		expectedSinkhole6 string
+	}{
+		{`pgacl postgres://localhost/dns`, false, "", "", defaultTable, defaultTimeout, "0.0.0.0", "::"},
+		{`pgacl {
+			pool zones
+			table dns.rules
+			timeout 5s
+			sinkhole 192.0.2.1
+			ttl 300
+		}`, false, "", "zones", "dns.rules", 5 * time.Second, "192.0.2.1", "<nil>"},
+		{`pgacl {
+			pool zones
+			sinkhole 2001:db8::1 192.0.2.1
+		}`, false, "", "zones", defaultTable, defaultTimeout, "192.0.2.1", "2001:db8::1"},
+		// fails
+		{`pgacl`, true, "either a connection string or an fdns pool is required", "", "", 0, "", ""},
+		{`pgacl postgres://localhost/dns {
+			pool zones
+		}`, true, "mutually exclusive", "", "", 0, "", ""},
+		{`pgacl postgres://localhost/dns extra`, true, "Wrong argument count", "", "", 0, "", ""},
+		{`pgacl {
+			pool
+		}`, true, "Wrong argument count", "", "", 0, "", ""},
+		{`pgacl {
+			pool zones
+			sinkhole 192.0.2.1 192.0.2.2
+		}`, true, "two addresses of the same family", "", "", 0, "", ""},
+		{`pgacl {
+			pool zones
+			sinkhole bogus
+		}`, true, "sinkhole address provided is invalid", "", "", 0, "", ""},
+		{`pgacl {
+			pool zones
+			ttl -1
+		}`, true, "ttl provided is invalid", "", "", 0, "", ""},
+		{`pgacl {
+			pool zones
+			bogus
+		}`, true, "unknown property", "", "", 0, "", ""},
+		{`pgacl postgres://localhost/dns
+		pgacl postgres://localhost/dns`, true, "this plugin", "", "", 0, "", ""},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := pgaclParse(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+
+		if cfg.poolName != test.expectedPool {
+			t.Errorf("Test %d: expected pool %q, got %q", i, test.expectedPool, cfg.poolName)
+		}
+		if cfg.table != test.expectedTable {
+			t.Errorf("Test %d: expected table %s, got %s", i, test.expectedTable, cfg.table)
+		}
+		if cfg.timeout != test.expectedTimeout {
+			t.Errorf("Test %d: expected timeout %s, got %s", i, test.expectedTimeout, cfg.timeout)
+		}
+		if cfg.sinkhole4.String() != test.expectedSinkhole4 || cfg.sinkhole6.String() != test.expectedSinkhole6 {
+			t.Errorf("Test %d: expected sinkholes %s and %s, got %s and %s", i, test.expectedSinkhole4, test.expectedSinkhole6, cfg.sinkhole4, cfg.sinkhole6)
+		}
+	}
+}
+
+func TestRulesSQL(t *testing.T) {
+	if got := rulesSQL("dns.acl_rules"); got != `SELECT id,priority,action,net::text,name,type FROM "dns"."acl_rules"` {
+		t.Errorf("Unexpected query %s", got)
+	}
+}
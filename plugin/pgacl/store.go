@@ -0,0 +1,136 @@
+package pgacl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Channel is the PostgreSQL notification channel pgacl listens on. Any notification reloads the
+// rules, see schema.sql.
+const Channel = "pgacl"
+
+// listenRetry is the time to wait before reconnecting after the listening connection failed.
+var listenRetry = 5 * time.Second
+
+// Store loads the rules from the rules table and keeps them in memory.
+type Store struct {
+	pool    *pgxpool.Pool
+	table   string
+	timeout time.Duration // Timeout for loading the rules, zero means no timeout.
+
+	sync.RWMutex
+	rules *Rules
+}
+
+// NewStore returns a store that loads the rules from table, which may be qualified with a schema
+// name. It holds no rules until they are loaded.
+func NewStore(pool *pgxpool.Pool, table string, timeout time.Duration) *Store {
+	rules, _ := compile(nil)
+	return &Store{pool: pool, table: table, timeout: timeout, rules: rules}
+}
+
+// Rules returns the loaded rules.
+func (s *Store) Rules() *Rules {
+	s.RLock()
+	defer s.RUnlock()
+	return s.rules
+}
+
+// rulesSQL returns the query for all rules in table.
+func rulesSQL(table string) string {
+	return fmt.Sprintf("SELECT id,priority,action,net::text,name,type FROM %s", pgx.Identifier(strings.Split(table, ".")).Sanitize())
+}
+
+// Load loads all rules, and replaces the rules in s with them. Rows that can't be parsed are
+// logged and skipped.
+func (s *Store) Load(ctx context.Context) error {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	rows, err := s.pool.Query(ctx, rulesSQL(s.table))
+	if err != nil {
+		reloadCount.WithLabelValues("failed").Inc()
+		return err
+	}
+	defer rows.Close()
+	var rs []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.ID, &r.Priority, &r.Action, &r.Net, &r.Name, &r.Type); err != nil {
+			reloadCount.WithLabelValues("failed").Inc()
+			return err
+		}
+		rs = append(rs, r)
+	}
+	if err := rows.Err(); err != nil {
+		reloadCount.WithLabelValues("failed").Inc()
+		return err
+	}
+
+	rules, errs := compile(rs)
+	for _, err := range errs {
+		log.Warningf("Skipping invalid %s", err)
+	}
+	s.Lock()
+	s.rules = rules
+	s.Unlock()
+	reloadCount.WithLabelValues("success").Inc()
+	ruleCount.Set(float64(rules.Len()))
+	log.Infof("Loaded %d rules", rules.Len())
+	return nil
+}
+
+// Listen waits for notifications on Channel and reloads the rules, until ctx is canceled. When
+// the connection is lost the rules are reloaded after reconnecting, because notifications sent in
+// the meantime are lost.
+func (s *Store) Listen(ctx context.Context) {
+	for {
+		err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("Listening for changes failed: %s", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetry):
+		}
+
+		if err := s.Load(ctx); err != nil {
+			log.Errorf("Failed to reload rules: %s", err)
+		}
+	}
+}
+
+// listen listens on a connection of its own, made with the settings of the pool, so it doesn't hold a
+// connection of the pool. The pool may be shared with fdns, which has its own listening connection.
+func (s *Store) listen(ctx context.Context) error {
+	conn, err := pgx.ConnectConfig(ctx, s.pool.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		if err := s.Load(ctx); err != nil {
+			log.Errorf("Failed to reload rules: %s", err)
+		}
+	}
+}
@@ -51,6 +51,34 @@ func NewRequest(method, url string, m *dns.Msg) (*http.Request, error) {
 	}
 }
 
+// NewEndpointRequest returns a new DoH request given a method, the complete URL of the endpoint,
+// for example https://dns.example.org/dns-query, and dns.Msg.
+func NewEndpointRequest(method, endpoint string, m *dns.Msg) (*http.Request, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	switch method {
+	case http.MethodGet:
+		req, err = http.NewRequest(http.MethodGet, endpoint+"?dns="+b64Enc.EncodeToString(buf), nil)
+	case http.MethodPost:
+		req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(buf))
+	default:
+		return nil, fmt.Errorf("method not allowed: %s", method)
+	}
+	if err != nil {
+		return req, err
+	}
+
+	if method == http.MethodPost {
+		req.Header.Set("content-type", MimeType)
+	}
+	req.Header.Set("accept", MimeType)
+	return req, nil
+}
+
 // ResponseToMsg converts a http.Response to a dns message.
 func ResponseToMsg(resp *http.Response) (*dns.Msg, error) {
 	defer resp.Body.Close()
@@ -50,3 +50,29 @@ func TestGetRequest(t *testing.T) {
 		t.Errorf("Qname expected %d, got %d", x, dns.TypeDNSKEY)
 	}
 }
+
+func TestEndpointRequest(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req, err := NewEndpointRequest(method, "https://example.org/resolve", m)
+		if err != nil {
+			t.Fatalf("Failure to make %s request: %s", method, err)
+		}
+		if req.URL.Path != "/resolve" {
+			t.Errorf("Expected the path of the endpoint, got %s", req.URL.Path)
+		}
+		m, err := RequestToMsg(req)
+		if err != nil {
+			t.Fatalf("Failure to get message from %s request: %s", method, err)
+		}
+		if x := m.Question[0].Name; x != "example.org." {
+			t.Errorf("Qname expected %s, got %s", "example.org.", x)
+		}
+	}
+
+	if _, err := NewEndpointRequest(http.MethodPut, "https://example.org/resolve", m); err == nil {
+		t.Error("Expected an error for the PUT method")
+	}
+}
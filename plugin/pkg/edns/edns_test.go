@@ -1,6 +1,8 @@
 package edns
 
 import (
+	"context"
+	"net"
 	"testing"
 
 	"github.com/miekg/dns"
@@ -35,3 +37,41 @@ func ednsMsg() *dns.Msg {
 	m.Extra = append(m.Extra, o)
 	return m
 }
+
+func TestSubnet(t *testing.T) {
+	m := ednsMsg()
+	if Subnet(m) != nil {
+		t.Errorf("Expected no subnet option")
+	}
+
+	e := NewSubnet(net.ParseIP("192.0.2.130"), 24, 56)
+	if e.Family != 1 || e.SourceNetmask != 24 || e.Address.String() != "192.0.2.0" {
+		t.Errorf("Expected 192.0.2.0/24, got %s", e)
+	}
+	m.Extra[0].(*dns.OPT).Option = append(m.Extra[0].(*dns.OPT).Option, e)
+	if Subnet(m) != e {
+		t.Errorf("Expected the subnet option of the message, got %v", Subnet(m))
+	}
+
+	e = NewSubnet(net.ParseIP("2001:db8:1:2:3::1"), 24, 56)
+	if e.Family != 2 || e.SourceNetmask != 56 || e.Address.String() != "2001:db8:1::" {
+		t.Errorf("Expected 2001:db8:1::/56, got %s", e)
+	}
+	if e := NewSubnet(net.ParseIP("192.0.2.130"), 0, 0); e.SourceNetmask != 0 || !e.Address.Equal(net.IPv4zero) {
+		t.Errorf("Expected 0.0.0.0/0, got %s", e)
+	}
+	if NewSubnet(nil, 24, 56) != nil {
+		t.Errorf("Expected no subnet option for an invalid address")
+	}
+}
+
+func TestSubnetScope(t *testing.T) {
+	if SubnetScopeFromContext(context.TODO()) != nil {
+		t.Errorf("Expected no subnet scope")
+	}
+	ctx, s := WithSubnetScope(context.TODO())
+	SubnetScopeFromContext(ctx).Set, SubnetScopeFromContext(ctx).Prefix = true, 24
+	if !s.Set || s.Prefix != 24 {
+		t.Errorf("Expected the subnet scope to be set to 24, got %+v", s)
+	}
+}
@@ -0,0 +1,63 @@
+package edns
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Subnet returns the EDNS0 client subnet option of m, or nil if there is none.
+func Subnet(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, s := range o.Option {
+		if e, ok := s.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// NewSubnet returns an EDNS0 client subnet option for ip, truncated to the source prefix length v4 for an
+// IPv4 address and v6 for an IPv6 address. It returns nil if ip is not a valid address.
+func NewSubnet(ip net.IP, v4, v6 uint8) *dns.EDNS0_SUBNET {
+	e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if ip4 := ip.To4(); ip4 != nil {
+		e.Family = 1
+		e.SourceNetmask = v4
+		e.Address = ip4.Mask(net.CIDRMask(int(v4), net.IPv4len*8))
+		return e
+	}
+	if len(ip) != net.IPv6len {
+		return nil
+	}
+	e.Family = 2
+	e.SourceNetmask = v6
+	e.Address = ip.Mask(net.CIDRMask(int(v6), net.IPv6len*8))
+	return e
+}
+
+// SubnetScope is the scope prefix length of a reply to a query that was sent with an EDNS0 client subnet
+// option, for a plugin that caches the reply. It is set by the plugin that sent the query, as the option
+// may be removed from the reply before it is written.
+type SubnetScope struct {
+	Set    bool
+	Prefix uint8
+}
+
+type subnetScopeKey struct{}
+
+// WithSubnetScope returns a context with a new SubnetScope, to be set by the plugins that are called with it.
+func WithSubnetScope(ctx context.Context) (context.Context, *SubnetScope) {
+	s := new(SubnetScope)
+	return context.WithValue(ctx, subnetScopeKey{}, s), s
+}
+
+// SubnetScopeFromContext returns the SubnetScope of ctx, or nil if there is none.
+func SubnetScopeFromContext(ctx context.Context) *SubnetScope {
+	s, _ := ctx.Value(subnetScopeKey{}).(*SubnetScope)
+	return s
+}
@@ -0,0 +1,156 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encoding is the wire format of an event.
+type Encoding int
+
+const (
+	// JSON encodes an event as a JSON object.
+	JSON Encoding = iota
+	// Protobuf encodes an event as a Batch message with a single event, see event.proto. The
+	// concatenation of Protobuf encoded events is a valid Batch message holding all of them.
+	Protobuf
+)
+
+// EncodingFromString returns the encoding named s.
+func EncodingFromString(s string) (Encoding, error) {
+	switch s {
+	case "json":
+		return JSON, nil
+	case "protobuf":
+		return Protobuf, nil
+	}
+	return JSON, fmt.Errorf("unknown encoding: %s", s)
+}
+
+func (e Encoding) String() string {
+	switch e {
+	case JSON:
+		return "json"
+	case Protobuf:
+		return "protobuf"
+	}
+	return ""
+}
+
+// Encode returns ev in encoding e.
+func (e Encoding) Encode(ev *Event) ([]byte, error) {
+	switch e {
+	case JSON:
+		return json.Marshal(ev)
+	case Protobuf:
+		return protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), marshal(ev)), nil
+	}
+	return nil, fmt.Errorf("unknown encoding: %d", e)
+}
+
+// Separator returns the bytes that separate multiple encoded events in a single message.
+func (e Encoding) Separator() []byte {
+	if e == JSON {
+		return []byte("\n")
+	}
+	return nil
+}
+
+// The field numbers of the Event and EDNS messages in event.proto.
+const (
+	fieldVersion protowire.Number = iota + 1
+	fieldTime
+	fieldIP
+	fieldQName
+	fieldQType
+	fieldRcode
+	fieldDurationUS
+	fieldNSHostname
+	fieldTransport
+	fieldServer
+	fieldID
+	fieldFlags
+	fieldRFlags
+	fieldEDNS
+	fieldAnswers
+	fieldResponseSize
+	fieldLabels
+)
+
+const (
+	fieldBufSize protowire.Number = iota + 1
+	fieldDO
+	fieldECS
+)
+
+// marshal returns the protobuf encoding of the Event message for ev. Fields with the zero value
+// are left out, as proto3 does.
+func marshal(ev *Event) []byte {
+	var b []byte
+	b = appendVarint(b, fieldVersion, uint64(ev.Version))
+	b = appendVarint(b, fieldTime, uint64(ev.Time.UnixNano()))
+	b = appendString(b, fieldIP, ev.IP)
+	b = appendString(b, fieldQName, ev.QName)
+	b = appendString(b, fieldQType, ev.QType)
+	b = appendString(b, fieldRcode, ev.Rcode)
+	b = appendVarint(b, fieldDurationUS, uint64(ev.DurationUS))
+	b = appendString(b, fieldNSHostname, ev.NSHostname)
+	b = appendString(b, fieldTransport, ev.Transport)
+	b = appendString(b, fieldServer, ev.Server)
+	b = appendVarint(b, fieldID, uint64(ev.ID))
+	for _, f := range ev.Flags {
+		b = appendString(b, fieldFlags, f)
+	}
+	for _, f := range ev.RFlags {
+		b = appendString(b, fieldRFlags, f)
+	}
+	if ev.EDNS != nil {
+		var e []byte
+		e = appendVarint(e, fieldBufSize, uint64(ev.EDNS.BufSize))
+		if ev.EDNS.DO {
+			e = appendVarint(e, fieldDO, 1)
+		}
+		e = appendString(e, fieldECS, ev.EDNS.ECS)
+		b = protowire.AppendTag(b, fieldEDNS, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	}
+	for _, a := range ev.Answers {
+		b = appendString(b, fieldAnswers, a)
+	}
+	b = appendVarint(b, fieldResponseSize, uint64(ev.ResponseSize))
+
+	// Map entries are messages with the key in field 1 and the value in field 2. They are sorted
+	// to make the encoding deterministic.
+	keys := make([]string, 0, len(ev.Labels))
+	for k := range ev.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, ev.Labels[k])
+		b = protowire.AppendTag(b, fieldLabels, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func appendVarint(b []byte, n protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, n protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, n, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
@@ -0,0 +1,233 @@
+// Package event defines the event that is exported for every query by plugins like mqtt and
+// timescale, and its encodings.
+//
+// The layout of an event is versioned: fields are only added within a version, a field is never
+// removed or changes meaning without the version being incremented.
+package event
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Version is the version of the event layout.
+const Version = 1
+
+// Event describes a query and its response. The fields up to NSHostname are always set, the
+// others only when they are selected with Fields.
+type Event struct {
+	Version    int       `json:"version"`
+	Time       time.Time `json:"time"`
+	IP         string    `json:"ip"`
+	QName      string    `json:"qname"`
+	QType      string    `json:"qtype"`
+	Rcode      string    `json:"rcode"`
+	DurationUS int64     `json:"duration_us"`
+	NSHostname string    `json:"ns_hostname"`
+
+	Transport    string            `json:"transport,omitempty"` // udp, tcp, tls, https or grpc.
+	Server       string            `json:"server,omitempty"`    // Address the query was received on.
+	ID           uint16            `json:"id,omitempty"`
+	Flags        []string          `json:"flags,omitempty"`  // Flags of the query.
+	RFlags       []string          `json:"rflags,omitempty"` // Flags of the response.
+	EDNS         *EDNS             `json:"edns,omitempty"`   // Nil when the query has no OPT record.
+	Answers      []string          `json:"answers,omitempty"`
+	ResponseSize int               `json:"response_size,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"` // Metadata of the query.
+
+	// The messages and addresses of the query, for sinks that export them as they are. They are
+	// only set with the Messages field and are never encoded.
+	Query      *dns.Msg `json:"-"`
+	Response   *dns.Msg `json:"-"` // Nil when no response was written.
+	RemoteAddr net.Addr `json:"-"`
+	LocalAddr  net.Addr `json:"-"`
+}
+
+// EDNS holds the EDNS0 options of the query.
+type EDNS struct {
+	BufSize uint16 `json:"bufsize"`
+	DO      bool   `json:"do"`
+	ECS     string `json:"ecs,omitempty"` // Client subnet, like 192.0.2.0/24.
+}
+
+// Fields selects the optional fields of an event.
+type Fields uint
+
+// The optional fields of an event.
+const (
+	Transport Fields = 1 << iota
+	Server
+	ID
+	Flags
+	EDNS0
+	Answers
+	Size
+	Metadata
+
+	// All selects all optional fields that are encoded.
+	All = Transport | Server | ID | Flags | EDNS0 | Answers | Size | Metadata
+
+	// Messages selects the messages and addresses of the query, which are never encoded. It is
+	// not part of All.
+	Messages Fields = 1 << 15
+)
+
+var fieldNames = map[string]Fields{
+	"transport": Transport,
+	"server":    Server,
+	"id":        ID,
+	"flags":     Flags,
+	"edns":      EDNS0,
+	"answers":   Answers,
+	"size":      Size,
+	"metadata":  Metadata,
+	"all":       All,
+}
+
+// FieldsFromString returns the optional field named s.
+func FieldsFromString(s string) (Fields, error) {
+	if f, ok := fieldNames[s]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown field: %s", s)
+}
+
+// New returns the event for the query in state, answered with the response recorded by rec. When
+// no response was written, status is used as the rcode. The optional fields in fields are filled in.
+func New(ctx context.Context, state request.Request, rec *dnstest.Recorder, status int, hostname string, fields Fields) *Event {
+	rcode := status
+	if rec.Msg != nil {
+		rcode = rec.Msg.Rcode
+	}
+	e := &Event{
+		Version:    Version,
+		Time:       rec.Start.UTC(),
+		IP:         state.IP(),
+		QName:      state.QName(),
+		QType:      state.Type(),
+		Rcode:      dns.RcodeToString[rcode],
+		DurationUS: time.Since(rec.Start).Microseconds(),
+		NSHostname: hostname,
+	}
+	if e.Rcode == "" {
+		e.Rcode = fmt.Sprintf("RCODE%d", rcode)
+	}
+
+	if fields&Transport != 0 {
+		e.Transport = transportOf(ctx, state)
+	}
+	if fields&Server != 0 {
+		e.Server = state.LocalAddr()
+	}
+	if fields&ID != 0 {
+		e.ID = state.Req.Id
+	}
+	if fields&Flags != 0 {
+		e.Flags = flags(state.Req.MsgHdr)
+		if rec.Msg != nil {
+			e.RFlags = flags(rec.Msg.MsgHdr)
+		}
+	}
+	if fields&EDNS0 != 0 {
+		e.EDNS = edns(state.Req)
+	}
+	if fields&Answers != 0 && rec.Msg != nil {
+		for _, rr := range rec.Msg.Answer {
+			e.Answers = append(e.Answers, rr.String())
+		}
+	}
+	if fields&Size != 0 {
+		e.ResponseSize = rec.Len
+	}
+	if fields&Metadata != 0 {
+		e.Labels = labels(ctx)
+	}
+	if fields&Messages != 0 {
+		e.Query, e.Response = state.Req, rec.Msg
+		e.RemoteAddr, e.LocalAddr = state.W.RemoteAddr(), state.W.LocalAddr()
+	}
+	return e
+}
+
+// transportOf returns the transport the query was received over. Plain DNS servers report the
+// protocol of the connection, the other servers the transport from their address.
+func transportOf(ctx context.Context, state request.Request) string {
+	if srv, ok := ctx.Value(dnsserver.Key{}).(*dnsserver.Server); ok {
+		if i := strings.Index(srv.Addr, "://"); i > 0 && srv.Addr[:i] != transport.DNS {
+			return srv.Addr[:i]
+		}
+	}
+	return state.Proto()
+}
+
+// flags returns the names of the flags set in h, in the order dig shows them.
+func flags(h dns.MsgHdr) []string {
+	var f []string
+	if h.Response {
+		f = append(f, "qr")
+	}
+	if h.Authoritative {
+		f = append(f, "aa")
+	}
+	if h.Truncated {
+		f = append(f, "tc")
+	}
+	if h.RecursionDesired {
+		f = append(f, "rd")
+	}
+	if h.RecursionAvailable {
+		f = append(f, "ra")
+	}
+	if h.Zero {
+		f = append(f, "z")
+	}
+	if h.AuthenticatedData {
+		f = append(f, "ad")
+	}
+	if h.CheckingDisabled {
+		f = append(f, "cd")
+	}
+	return f
+}
+
+// edns returns the EDNS0 options of r, or nil when it has no OPT record.
+func edns(r *dns.Msg) *EDNS {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	e := &EDNS{BufSize: opt.UDPSize(), DO: opt.Do()}
+	for _, o := range opt.Option {
+		if s, ok := o.(*dns.EDNS0_SUBNET); ok {
+			e.ECS = fmt.Sprintf("%s/%d", s.Address, s.SourceNetmask)
+			break
+		}
+	}
+	return e
+}
+
+// labels returns the metadata in ctx, or nil when there is none.
+func labels(ctx context.Context) map[string]string {
+	names := metadata.Labels(ctx)
+	if len(names) == 0 {
+		return nil
+	}
+	l := make(map[string]string, len(names))
+	for _, name := range names {
+		if f := metadata.ValueFunc(ctx, name); f != nil {
+			l[name] = f()
+		}
+	}
+	return l
+}
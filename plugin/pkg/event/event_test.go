@@ -0,0 +1,200 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newTestEvent(t *testing.T, ctx context.Context, fields Fields) *Event {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	r.Id = 4242
+	r.SetEdns0(1232, true)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4(),
+	})
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Answer = []dns.RR{test.A("example.org. 3600 IN A 127.0.0.1")}
+	rec.WriteMsg(m)
+
+	state := request.Request{W: rec, Req: r}
+	return New(ctx, state, rec, dns.RcodeServerFailure, "ns1", fields)
+}
+
+func TestNew(t *testing.T) {
+	e := newTestEvent(t, context.TODO(), 0)
+	if e.Version != Version || e.IP != "10.240.0.1" || e.QName != "example.org." || e.QType != "A" || e.Rcode != "NOERROR" || e.NSHostname != "ns1" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if e.Transport != "" || e.ID != 0 || e.Flags != nil || e.EDNS != nil || e.Answers != nil || e.ResponseSize != 0 {
+		t.Errorf("Expected no optional fields, got %+v", e)
+	}
+
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "geoip/city/name", func() string { return "Amsterdam" })
+	ctx = context.WithValue(ctx, dnsserver.Key{}, &dnsserver.Server{Addr: "tls://:853"})
+
+	e = newTestEvent(t, ctx, All)
+	if e.Transport != "tls" || e.Server != "127.0.0.1:53" || e.ID != 4242 {
+		t.Errorf("Unexpected transport %q, server %q or id %d", e.Transport, e.Server, e.ID)
+	}
+	if !reflect.DeepEqual(e.Flags, []string{"rd"}) || !reflect.DeepEqual(e.RFlags, []string{"qr", "aa", "rd"}) {
+		t.Errorf("Unexpected flags %v and %v", e.Flags, e.RFlags)
+	}
+	if e.EDNS == nil || e.EDNS.BufSize != 1232 || !e.EDNS.DO || e.EDNS.ECS != "192.0.2.0/24" {
+		t.Errorf("Unexpected EDNS %+v", e.EDNS)
+	}
+	if len(e.Answers) != 1 || e.Answers[0] != "example.org.\t3600\tIN\tA\t127.0.0.1" || e.ResponseSize == 0 {
+		t.Errorf("Unexpected answers %v or response size %d", e.Answers, e.ResponseSize)
+	}
+	if e.Labels["geoip/city/name"] != "Amsterdam" {
+		t.Errorf("Unexpected labels %v", e.Labels)
+	}
+
+	// Without a server in the context, the protocol of the connection is used.
+	if e := newTestEvent(t, context.TODO(), Transport); e.Transport != "udp" {
+		t.Errorf("Expected transport udp, got %q", e.Transport)
+	}
+}
+
+func TestNewWithoutResponse(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	e := New(context.TODO(), request.Request{W: rec, Req: r}, rec, dns.RcodeRefused, "ns1", All)
+	if e.Rcode != "REFUSED" || e.RFlags != nil || e.Answers != nil || e.EDNS != nil {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
+
+func TestFieldsFromString(t *testing.T) {
+	if f, err := FieldsFromString("edns"); err != nil || f != EDNS0 {
+		t.Errorf("Expected edns field, got %d, %v", f, err)
+	}
+	if _, err := FieldsFromString("bogus"); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	e := &Event{Version: Version, Time: time.Unix(1, 0).UTC(), QName: "example.org.", EDNS: &EDNS{BufSize: 512}}
+	b, err := JSON.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":1,"time":"1970-01-01T00:00:01Z","ip":"","qname":"example.org.","qtype":"","rcode":"","duration_us":0,"ns_hostname":"","edns":{"bufsize":512,"do":false}}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(b, &decoded); err != nil || !reflect.DeepEqual(&decoded, e) {
+		t.Errorf("Expected the event to round-trip, got %+v, %v", decoded, err)
+	}
+}
+
+func TestEncodeProtobuf(t *testing.T) {
+	e := newTestEvent(t, context.TODO(), All)
+	e.Labels = map[string]string{"b/x": "2", "a/x": "1"}
+	b, err := Protobuf.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two concatenated events are a Batch with two events.
+	batch := decode(t, append(b, b...))
+	if len(batch[1]) != 2 {
+		t.Fatalf("Expected a batch with 2 events, got %d", len(batch[1]))
+	}
+	ev := decode(t, batch[1][0].([]byte))
+
+	expected := map[protowire.Number][]interface{}{
+		fieldVersion:    {uint64(1)},
+		fieldQName:      {"example.org."},
+		fieldRcode:      {"NOERROR"},
+		fieldNSHostname: {"ns1"},
+		fieldTransport:  {"udp"},
+		fieldID:         {uint64(4242)},
+		fieldRFlags:     {"qr", "aa", "rd"},
+		fieldAnswers:    {"example.org.\t3600\tIN\tA\t127.0.0.1"},
+	}
+	for n, values := range expected {
+		got := make([]interface{}, len(ev[n]))
+		for i, v := range ev[n] {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			got[i] = v
+		}
+		if !reflect.DeepEqual(got, values) {
+			t.Errorf("Field %d: expected %v, got %v", n, values, got)
+		}
+	}
+	if ev[fieldTime][0].(uint64) != uint64(e.Time.UnixNano()) {
+		t.Errorf("Expected time %d, got %v", e.Time.UnixNano(), ev[fieldTime])
+	}
+
+	edns := decode(t, ev[fieldEDNS][0].([]byte))
+	if edns[fieldBufSize][0] != uint64(1232) || edns[fieldDO][0] != uint64(1) || string(edns[fieldECS][0].([]byte)) != "192.0.2.0/24" {
+		t.Errorf("Unexpected EDNS %v", edns)
+	}
+
+	if len(ev[fieldLabels]) != 2 {
+		t.Fatalf("Expected 2 labels, got %d", len(ev[fieldLabels]))
+	}
+	first := decode(t, ev[fieldLabels][0].([]byte))
+	if string(first[1][0].([]byte)) != "a/x" || string(first[2][0].([]byte)) != "1" {
+		t.Errorf("Expected the labels to be sorted, got %v first", first)
+	}
+}
+
+// decode returns the values of the fields in the protobuf message b, by field number. Varints are
+// returned as uint64, length delimited fields as []byte.
+func decode(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	t.Helper()
+	fields := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatalf("Invalid tag: %s", protowire.ParseError(l))
+		}
+		b = b[l:]
+		switch typ {
+		case protowire.VarintType:
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				t.Fatalf("Invalid varint: %s", protowire.ParseError(l))
+			}
+			fields[n] = append(fields[n], v)
+			b = b[l:]
+		case protowire.BytesType:
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatalf("Invalid bytes: %s", protowire.ParseError(l))
+			}
+			fields[n] = append(fields[n], v)
+			b = b[l:]
+		default:
+			t.Fatalf("Unexpected wire type %d", typ)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,320 @@
+package event
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("event")
+
+// DropPolicy decides what happens to an event when the queue of an exporter is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the event that doesn't fit in the queue anymore.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued event to make room for the new one.
+	DropOldest
+	// Block waits for room in the queue, up to the block timeout, and drops the event after that.
+	Block
+)
+
+// DefaultBlockTimeout is the default time the Block drop policy waits for room in the queue.
+const DefaultBlockTimeout = 100 * time.Millisecond
+
+// Config configures an Exporter. Plugins set their own defaults and parse the options that are
+// shared by all plugins that export events with Parse.
+type Config struct {
+	Rules         []Rule // Rules selecting the exported queries, all queries when empty.
+	Fields        Fields // Optional fields of the events.
+	QueueSize     int
+	Drop          DropPolicy
+	BlockTimeout  time.Duration
+	BatchSize     int           // Maximum number of events exported at once.
+	FlushInterval time.Duration // Maximum time an event waits for the batch to fill up.
+}
+
+// Parse parses the shared option at the current token of c. It returns false when the option is
+// not a shared option. The shared options are:
+//
+//	rule NAMES... [class CLASSES...] [type TYPES...] [sample RATE]
+//	queue SIZE
+//	drop newest|oldest|block [TIMEOUT]
+//	batch SIZE [INTERVAL]
+func (cfg *Config) Parse(c *caddy.Controller) (bool, error) {
+	switch c.Val() {
+	case "rule":
+		rules, err := ParseRule(c)
+		if err != nil {
+			return true, err
+		}
+		cfg.Rules = append(cfg.Rules, rules...)
+	case "queue":
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return true, c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return true, err
+		}
+		if n <= 0 {
+			return true, c.Errf("queue size provided is invalid: %d", n)
+		}
+		cfg.QueueSize = n
+	case "drop":
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return true, c.ArgErr()
+		}
+		switch args[0] {
+		case "newest":
+			cfg.Drop = DropNewest
+		case "oldest":
+			cfg.Drop = DropOldest
+		case "block":
+			cfg.Drop = Block
+		default:
+			return true, c.Errf("unknown drop policy '%s'", args[0])
+		}
+		if len(args) == 2 {
+			if cfg.Drop != Block {
+				return true, c.Errf("only the block drop policy has a timeout")
+			}
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return true, err
+			}
+			if d <= 0 {
+				return true, c.Errf("block timeout provided is invalid: %s", d)
+			}
+			cfg.BlockTimeout = d
+		}
+	case "batch":
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return true, c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return true, err
+		}
+		if n <= 0 {
+			return true, c.Errf("batch size provided is invalid: %d", n)
+		}
+		cfg.BatchSize = n
+		if len(args) == 2 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return true, err
+			}
+			if d <= 0 {
+				return true, c.Errf("flush interval provided is invalid: %s", d)
+			}
+			cfg.FlushInterval = d
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Exporter exports the events of the queries selected by its rules to a sink, from a background
+// goroutine. Events are queued in a bounded queue and exported in batches, so exporting never
+// delays a reply more than the drop policy allows.
+type Exporter struct {
+	sink        Sink
+	name        string // Kind of the sink, like mqtt, for the metrics.
+	destination string // Destination of the sink for logs and metrics.
+
+	rules  []Rule
+	fields Fields
+
+	queue         chan *Event
+	drop          DropPolicy
+	blockTimeout  time.Duration
+	batchSize     int
+	flushInterval time.Duration
+
+	ctx    context.Context // Done when the exporter stops.
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewExporter returns an exporter that exports events to sink, configured with cfg. Name is the
+// kind of sink, and destination where it exports to, they are used in logs and metrics.
+func NewExporter(name, destination string, sink Sink, cfg Config) *Exporter {
+	x := &Exporter{
+		sink:          sink,
+		name:          name,
+		destination:   destination,
+		rules:         cfg.Rules,
+		fields:        cfg.Fields,
+		queue:         make(chan *Event, max(cfg.QueueSize, 1)),
+		drop:          cfg.Drop,
+		blockTimeout:  cfg.BlockTimeout,
+		batchSize:     max(cfg.BatchSize, 1),
+		flushInterval: cfg.FlushInterval,
+		done:          make(chan struct{}),
+	}
+	if len(x.rules) == 0 {
+		x.rules = DefaultRules()
+	}
+	if x.blockTimeout <= 0 {
+		x.blockTimeout = DefaultBlockTimeout
+	}
+	if f, ok := sink.(Fielder); ok {
+		x.fields |= f.Fields()
+	}
+	x.ctx, x.cancel = context.WithCancel(context.Background())
+	return x
+}
+
+// Fields returns the optional fields the events of the exporter need.
+func (x *Exporter) Fields() Fields { return x.fields }
+
+// Selected returns true when the query for name and qtype, that got response m, is exported.
+func (x *Exporter) Selected(name string, qtype uint16, m *dns.Msg) bool {
+	return Selected(x.rules, name, qtype, m)
+}
+
+// Export queues e for exporting. It only blocks with the Block drop policy, for at most the block
+// timeout. The server label is only used for metrics.
+func (x *Exporter) Export(server string, e *Event) {
+	select {
+	case x.queue <- e:
+		queuedCount.WithLabelValues(server, x.name, x.destination).Inc()
+		return
+	default:
+	}
+
+	switch x.drop {
+	case DropOldest:
+		select {
+		case <-x.queue:
+			droppedCount.WithLabelValues(x.name, x.destination, "queue_full").Inc()
+		default:
+		}
+		select {
+		case x.queue <- e:
+			queuedCount.WithLabelValues(server, x.name, x.destination).Inc()
+			return
+		default:
+		}
+	case Block:
+		t := time.NewTimer(x.blockTimeout)
+		defer t.Stop()
+		select {
+		case x.queue <- e:
+			queuedCount.WithLabelValues(server, x.name, x.destination).Inc()
+			return
+		case <-t.C:
+		}
+	}
+	droppedCount.WithLabelValues(x.name, x.destination, "queue_full").Inc()
+}
+
+// Start starts exporting the queued events.
+func (x *Exporter) Start() { go x.run() }
+
+// Stop exports the events that are still queued and stops exporting. When a batch can't be
+// exported, the remaining events are dropped instead of waiting for the sink again.
+func (x *Exporter) Stop() {
+	x.cancel()
+	<-x.done
+	if c, ok := x.sink.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warningf("Failed to close %s sink %s: %s", x.name, x.destination, err)
+		}
+	}
+}
+
+func (x *Exporter) run() {
+	defer close(x.done)
+
+	var (
+		batch = make([]*Event, 0, x.batchSize)
+		flush <-chan time.Time
+	)
+	for {
+		select {
+		case e := <-x.queue:
+			batch = append(batch, e)
+			if len(batch) < x.batchSize {
+				if flush == nil {
+					flush = time.After(x.flushInterval)
+				}
+				continue
+			}
+		case <-flush:
+		case <-x.ctx.Done():
+			x.drain(batch)
+			return
+		}
+
+		x.export(batch)
+		batch = make([]*Event, 0, x.batchSize)
+		flush = nil
+		queueLength.WithLabelValues(x.name, x.destination).Set(float64(len(x.queue)))
+	}
+}
+
+// drain exports batch and the events left in the queue, until exporting fails.
+func (x *Exporter) drain(batch []*Event) {
+	ok := true
+	for {
+		select {
+		case e := <-x.queue:
+			batch = append(batch, e)
+			if len(batch) < x.batchSize {
+				continue
+			}
+		default:
+			if ok {
+				x.export(batch)
+			} else {
+				droppedCount.WithLabelValues(x.name, x.destination, "shutdown").Add(float64(len(batch)))
+			}
+			queueLength.WithLabelValues(x.name, x.destination).Set(0)
+			return
+		}
+
+		if ok {
+			ok = x.export(batch)
+		} else {
+			droppedCount.WithLabelValues(x.name, x.destination, "shutdown").Add(float64(len(batch)))
+		}
+		batch = make([]*Event, 0, x.batchSize)
+	}
+}
+
+// export exports batch to the sink. It returns false when the sink failed.
+func (x *Exporter) export(batch []*Event) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	start := time.Now()
+	err := x.sink.Export(x.ctx, batch)
+	exportDuration.WithLabelValues(x.name, x.destination).Observe(time.Since(start).Seconds())
+	if err != nil {
+		log.Warningf("Failed to export %d events to %s sink %s: %s", len(batch), x.name, x.destination, err)
+		droppedCount.WithLabelValues(x.name, x.destination, "failed").Add(float64(len(batch)))
+		return false
+	}
+	exportedCount.WithLabelValues(x.name, x.destination).Add(float64(len(batch)))
+	return true
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
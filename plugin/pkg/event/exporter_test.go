@@ -0,0 +1,200 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+// fakeSink records the exported batches. The first fail exports fail.
+type fakeSink struct {
+	sync.Mutex
+	batches [][]string
+	fail    int
+	closed  bool
+}
+
+func (f *fakeSink) Export(_ context.Context, events []*Event) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.fail > 0 {
+		f.fail--
+		return errors.New("connection refused")
+	}
+	var names []string
+	for _, e := range events {
+		names = append(names, e.QName)
+	}
+	f.batches = append(f.batches, names)
+	return nil
+}
+
+func (f *fakeSink) Close() error { f.closed = true; return nil }
+
+func (f *fakeSink) exported() string {
+	f.Lock()
+	defer f.Unlock()
+	var s []string
+	for _, b := range f.batches {
+		s = append(s, strings.Join(b, " "))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestExporter(t *testing.T) {
+	f := &fakeSink{}
+	x := NewExporter("fake", "test", f, Config{QueueSize: 10, BatchSize: 2, FlushInterval: 10 * time.Millisecond})
+	x.Start()
+	for _, q := range []string{"a.", "b.", "c."} {
+		x.Export("dns://:53", &Event{QName: q})
+	}
+
+	// The last event is exported after the flush interval.
+	deadline := time.Now().Add(time.Second)
+	for f.exported() != "a. b.,c." && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	x.Stop()
+
+	if got := f.exported(); got != "a. b.,c." {
+		t.Errorf("Expected batches %q, got %q", "a. b.,c.", got)
+	}
+	if !f.closed {
+		t.Error("Expected the sink to be closed")
+	}
+}
+
+func TestExporterStop(t *testing.T) {
+	f := &fakeSink{fail: 1}
+	x := NewExporter("fake", "test", f, Config{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour})
+	for _, q := range []string{"a.", "b."} {
+		x.Export("dns://:53", &Event{QName: q})
+	}
+	x.drain(nil)
+
+	// The queue is drained on stop, until a batch fails.
+	if got := f.exported(); got != "" {
+		t.Errorf("Expected the events after the failed batch to be dropped, got %q", got)
+	}
+
+	f = &fakeSink{}
+	x = NewExporter("fake", "test", f, Config{QueueSize: 10, BatchSize: 5, FlushInterval: time.Hour})
+	x.Start()
+	x.Export("dns://:53", &Event{QName: "a."})
+	x.Stop()
+	if got := f.exported(); got != "a." {
+		t.Errorf("Expected the queued event to be exported on stop, got %q", got)
+	}
+}
+
+func TestExporterDrop(t *testing.T) {
+	tests := []struct {
+		drop     DropPolicy
+		expected string
+	}{
+		{DropNewest, "a. b."},
+		{DropOldest, "b. c."},
+		{Block, "a. b."},
+	}
+
+	for i, tc := range tests {
+		// The exporter isn't started, so the queue fills up.
+		x := NewExporter("fake", "test", &fakeSink{}, Config{QueueSize: 2, Drop: tc.drop, BlockTimeout: time.Millisecond})
+		for _, q := range []string{"a.", "b.", "c."} {
+			x.Export("dns://:53", &Event{QName: q})
+		}
+		close(x.queue)
+		var queued []string
+		for e := range x.queue {
+			queued = append(queued, e.QName)
+		}
+		if got := strings.Join(queued, " "); got != tc.expected {
+			t.Errorf("Test %d: expected queue %q, got %q", i, tc.expected, got)
+		}
+	}
+}
+
+func TestExporterBlock(t *testing.T) {
+	x := NewExporter("fake", "test", &fakeSink{}, Config{QueueSize: 1, Drop: Block, BlockTimeout: time.Second})
+	x.Export("dns://:53", &Event{QName: "a."})
+
+	// The event is queued as soon as there is room.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-x.queue
+	}()
+	x.Export("dns://:53", &Event{QName: "b."})
+	if e := <-x.queue; e.QName != "b." {
+		t.Errorf("Expected the blocked event to be queued, got %s", e.QName)
+	}
+}
+
+type fielderSink struct{ fakeSink }
+
+func (f *fielderSink) Fields() Fields { return Messages }
+
+func TestExporterFields(t *testing.T) {
+	x := NewExporter("fake", "test", &fielderSink{}, Config{Fields: Transport})
+	if x.Fields() != Transport|Messages {
+		t.Errorf("Expected the configured fields and the fields of the sink, got %d", x.Fields())
+	}
+	if len(x.rules) != 1 || x.rules[0].NameScope != "." {
+		t.Errorf("Expected a rule selecting every query, got %+v", x.rules)
+	}
+}
+
+func TestConfigParse(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		expected  Config
+	}{
+		{"queue 10", false, Config{QueueSize: 10}},
+		{"drop oldest", false, Config{Drop: DropOldest}},
+		{"drop block 1s", false, Config{Drop: Block, BlockTimeout: time.Second}},
+		{"batch 100 2s", false, Config{BatchSize: 100, FlushInterval: 2 * time.Second}},
+		// fails
+		{"queue", true, Config{}},
+		{"queue 0", true, Config{}},
+		{"drop random", true, Config{}},
+		{"drop newest 1s", true, Config{}},
+		{"batch 0", true, Config{}},
+		{"batch 10 -1s", true, Config{}},
+	}
+
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		c.Next()
+		var cfg Config
+		ok, err := cfg.Parse(c)
+		if !ok {
+			t.Errorf("Test %d: expected %q to be a shared option", i, tc.input)
+		}
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got %v", i, tc.shouldErr, err)
+			continue
+		}
+		if !tc.shouldErr && (cfg.QueueSize != tc.expected.QueueSize || cfg.Drop != tc.expected.Drop || cfg.BlockTimeout != tc.expected.BlockTimeout ||
+			cfg.BatchSize != tc.expected.BatchSize || cfg.FlushInterval != tc.expected.FlushInterval) {
+			t.Errorf("Test %d: expected %+v, got %+v", i, tc.expected, cfg)
+		}
+	}
+
+	c := caddy.NewTestController("dns", "rule example.org sample 0.5")
+	c.Next()
+	var cfg Config
+	if ok, err := cfg.Parse(c); !ok || err != nil || len(cfg.Rules) != 1 || cfg.Rules[0].Sample != 0.5 {
+		t.Errorf("Expected a rule, got %+v, %v", cfg.Rules, err)
+	}
+
+	c = caddy.NewTestController("dns", "bogus")
+	c.Next()
+	if ok, _ := cfg.Parse(c); ok {
+		t.Error("Expected bogus not to be a shared option")
+	}
+}
@@ -0,0 +1,87 @@
+package event
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Handler records the response to every query and exports its event with its exporters. When
+// more plugins in a chain use a Handler, the first one records the response and captures the event
+// once, for the exporters of all of them.
+type Handler struct {
+	Next      plugin.Handler
+	Plugin    string // Name of the plugin, returned by Name.
+	Exporters []*Exporter
+	Hostname  string
+}
+
+type captureKey struct{}
+
+// capture collects the exporters of the Handlers a query passes through. The context is also passed
+// to the queries that plugins make themselves while handling it, like lookups of the targets of
+// CNAMEs, req tells these apart.
+type capture struct {
+	req       *dns.Msg
+	exporters []*Exporter
+}
+
+// add adds the exporters in xs that c doesn't have yet.
+func (c *capture) add(xs []*Exporter) {
+outer:
+	for _, x := range xs {
+		for _, y := range c.exporters {
+			if x == y {
+				continue outer
+			}
+		}
+		c.exporters = append(c.exporters, x)
+	}
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (h Handler) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if c, ok := ctx.Value(captureKey{}).(*capture); ok && c.req == r {
+		// An earlier Handler records this query, it exports the event for us too.
+		c.add(h.Exporters)
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+
+	c := &capture{req: r}
+	c.add(h.Exporters)
+	ctx = context.WithValue(ctx, captureKey{}, c)
+	state := request.Request{W: w, Req: r}
+
+	rw := dnstest.NewRecorder(w)
+	status, err := plugin.NextOrFailure(h.Name(), h.Next, ctx, rw, r)
+
+	var (
+		selected []*Exporter
+		fields   Fields
+	)
+	for _, x := range c.exporters {
+		if x.Selected(state.Name(), state.QType(), rw.Msg) {
+			selected = append(selected, x)
+			fields |= x.Fields()
+		}
+	}
+	if len(selected) == 0 {
+		return status, err
+	}
+
+	e := New(ctx, state, rw, status, h.Hostname, fields)
+	server := metrics.WithServer(ctx)
+	// Queue the event, the reply has already been written and is never delayed by a sink.
+	for _, x := range selected {
+		x.Export(server, e)
+	}
+	return status, err
+}
+
+// Name implements the Handler interface.
+func (h Handler) Name() string { return h.Plugin }
@@ -0,0 +1,94 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestHandler(t *testing.T) {
+	all := NewExporter("fake", "all", &fakeSink{}, Config{QueueSize: 10, Fields: Transport})
+	denials := NewExporter("fake", "denials", &fakeSink{}, Config{QueueSize: 10, Fields: Size,
+		Rules: []Rule{{NameScope: ".", Class: map[response.Class]struct{}{response.Denial: {}}, Sample: 1}}})
+
+	// Two plugins in a chain: the event is captured once, by the first.
+	inner := Handler{Next: test.ErrorHandler(), Plugin: "inner", Exporters: []*Exporter{denials}}
+	outer := Handler{Next: inner, Plugin: "outer", Exporters: []*Exporter{all}, Hostname: "ns1"}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if rcode, _ := outer.ServeDNS(context.TODO(), rec, m); rcode != dns.RcodeServerFailure || rec.Msg == nil {
+		t.Fatalf("Expected the reply of the next plugin, got %s", dns.RcodeToString[rcode])
+	}
+
+	if len(all.queue) != 1 || len(denials.queue) != 0 {
+		t.Fatalf("Expected 1 event for all queries and none for denials, got %d and %d", len(all.queue), len(denials.queue))
+	}
+	e := <-all.queue
+	if e.Rcode != "SERVFAIL" || e.Transport != "udp" || e.NSHostname != "ns1" || e.ResponseSize != 0 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
+
+func TestHandlerShared(t *testing.T) {
+	a := NewExporter("fake", "a", &fakeSink{}, Config{QueueSize: 10, Fields: Transport})
+	b := NewExporter("fake", "b", &fakeSink{}, Config{QueueSize: 10, Fields: Size})
+	inner := Handler{Next: test.ErrorHandler(), Plugin: "inner", Exporters: []*Exporter{b}}
+	outer := Handler{Next: inner, Plugin: "outer", Exporters: []*Exporter{a}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	outer.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+
+	ea, eb := <-a.queue, <-b.queue
+	if ea != eb {
+		t.Error("Expected both exporters to get the same event")
+	}
+	// The event has the fields of both exporters.
+	if ea.Transport != "udp" || ea.ResponseSize == 0 {
+		t.Errorf("Expected the transport and the size, got %+v", ea)
+	}
+	if time.Since(ea.Time) > time.Minute {
+		t.Errorf("Unexpected time %s", ea.Time)
+	}
+}
+
+func TestHandlerNested(t *testing.T) {
+	x := NewExporter("fake", "x", &fakeSink{}, Config{QueueSize: 10})
+
+	// The server's chain, used for the query and the lookup a plugin makes while handling it, like
+	// the upstream lookup of the target of a CNAME.
+	var chain Handler
+	lookup := plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		if r.Question[0].Name == "www.example.org." {
+			m := new(dns.Msg)
+			m.SetQuestion("target.example.org.", dns.TypeA)
+			chain.ServeDNS(ctx, dnstest.NewRecorder(&test.ResponseWriter{}), m)
+		}
+		return test.ErrorHandler().ServeDNS(ctx, w, r)
+	})
+	inner := Handler{Next: lookup, Plugin: "inner", Exporters: []*Exporter{x}}
+	chain = Handler{Next: inner, Plugin: "outer", Exporters: []*Exporter{x}}
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	chain.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+
+	if len(x.queue) != 2 {
+		t.Fatalf("Expected an event for the query and one for the lookup, got %d", len(x.queue))
+	}
+	if e := <-x.queue; e.QName != "target.example.org." {
+		t.Errorf("Expected the event of the lookup first, got %s", e.QName)
+	}
+	if e := <-x.queue; e.QName != "www.example.org." {
+		t.Errorf("Expected the event of the query, got %s", e.QName)
+	}
+}
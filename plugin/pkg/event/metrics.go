@@ -0,0 +1,47 @@
+package event
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// queuedCount is the number of events queued for exporting.
+	queuedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "events",
+		Name:      "queued_total",
+		Help:      "Counter of events queued for exporting.",
+	}, []string{"server", "sink", "destination"})
+	// exportedCount is the number of events exported to their sink.
+	exportedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "events",
+		Name:      "exported_total",
+		Help:      "Counter of events exported to their sink.",
+	}, []string{"sink", "destination"})
+	// droppedCount is the number of events that were never exported, by reason.
+	droppedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "events",
+		Name:      "dropped_total",
+		Help:      "Counter of events dropped without being exported, by reason.",
+	}, []string{"sink", "destination", "reason"})
+	// queueLength is the number of events waiting in the queue.
+	queueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "events",
+		Name:      "queue_length",
+		Help:      "Number of events waiting to be exported.",
+	}, []string{"sink", "destination"})
+	// exportDuration is the time exporting a batch takes.
+	exportDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "events",
+		Name:      "export_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time exporting a batch took.",
+	}, []string{"sink", "destination"})
+)
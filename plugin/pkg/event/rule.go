@@ -0,0 +1,146 @@
+package event
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/rand"
+	"github.com/coredns/coredns/plugin/pkg/response"
+
+	"github.com/miekg/dns"
+)
+
+// Rule selects the queries that are exported. A query matches a rule when its name is in the
+// rule's name scope and its type and response class are in the rule's sets. Of the matching
+// queries, a sample is exported.
+type Rule struct {
+	NameScope string
+	Class     map[response.Class]struct{} // Contains response.All when the class doesn't matter.
+	Types     map[uint16]struct{}         // Empty when the type doesn't matter.
+	Sample    float64                     // Fraction of the matching queries that is exported.
+}
+
+// matches returns true when a query for name and qtype, that got a response of class, matches r.
+func (r Rule) matches(name string, qtype uint16, class response.Class) bool {
+	if !plugin.Name(r.NameScope).Matches(name) {
+		return false
+	}
+	if len(r.Types) > 0 {
+		if _, ok := r.Types[qtype]; !ok {
+			return false
+		}
+	}
+	if _, ok := r.Class[response.All]; ok {
+		return true
+	}
+	_, ok := r.Class[class]
+	return ok
+}
+
+// sampled returns true when a matching query is selected by the rule's sample rate.
+func (r Rule) sampled() bool {
+	if r.Sample >= 1 {
+		return true
+	}
+	return float64(rn.Int()%sampleRange) < r.Sample*sampleRange
+}
+
+const sampleRange = 1000000
+
+var rn = rand.New(time.Now().UnixNano())
+
+// Selected returns true when the query for name and qtype, that got response m, is exported. The
+// first rule the query matches decides, queries that match no rule are not exported.
+func Selected(rules []Rule, name string, qtype uint16, m *dns.Msg) bool {
+	class, classified := response.All, false
+	for _, r := range rules {
+		// Classifying the response is only needed when a rule filters on it.
+		if _, ok := r.Class[response.All]; !ok && !classified {
+			tpe, _ := response.Typify(m, time.Now().UTC())
+			class, classified = response.Classify(tpe), true
+		}
+		if r.matches(name, qtype, class) {
+			return r.sampled()
+		}
+	}
+	return false
+}
+
+// DefaultRules returns the rules that select every query.
+func DefaultRules() []Rule {
+	return []Rule{{NameScope: ".", Class: map[response.Class]struct{}{response.All: {}}, Sample: 1}}
+}
+
+// ParseRule parses "rule NAMES... [class CLASSES...] [type TYPES...] [sample RATE]", it returns a
+// rule for every name.
+func ParseRule(c *caddy.Controller) ([]Rule, error) {
+	args := c.RemainingArgs()
+	var names []string
+	for len(args) > 0 && !isRuleKeyword(args[0]) {
+		names = append(names, plugin.Name(args[0]).Normalize())
+		args = args[1:]
+	}
+	if len(names) == 0 {
+		return nil, c.ArgErr()
+	}
+
+	var (
+		classes = make(map[response.Class]struct{})
+		types   = make(map[uint16]struct{})
+		sample  = 1.0
+	)
+	for len(args) > 0 {
+		keyword := args[0]
+		args = args[1:]
+		var values []string
+		for len(args) > 0 && !isRuleKeyword(args[0]) {
+			values = append(values, args[0])
+			args = args[1:]
+		}
+		if len(values) == 0 {
+			return nil, c.ArgErr()
+		}
+
+		switch keyword {
+		case "class":
+			for _, v := range values {
+				cls, err := response.ClassFromString(v)
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				classes[cls] = struct{}{}
+			}
+		case "type":
+			for _, v := range values {
+				qtype, ok := dns.StringToType[strings.ToUpper(v)]
+				if !ok {
+					return nil, c.Errf("unknown query type '%s'", v)
+				}
+				types[qtype] = struct{}{}
+			}
+		case "sample":
+			if len(values) != 1 {
+				return nil, c.ArgErr()
+			}
+			f, err := strconv.ParseFloat(values[0], 64)
+			if err != nil || f <= 0 || f > 1 {
+				return nil, c.Errf("sample rate provided is invalid: %s", values[0])
+			}
+			sample = f
+		}
+	}
+	if len(classes) == 0 {
+		classes[response.All] = struct{}{}
+	}
+
+	rules := make([]Rule, len(names))
+	for i, name := range names {
+		rules[i] = Rule{NameScope: name, Class: classes, Types: types, Sample: sample}
+	}
+	return rules, nil
+}
+
+func isRuleKeyword(s string) bool { return s == "class" || s == "type" || s == "sample" }
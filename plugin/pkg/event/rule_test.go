@@ -0,0 +1,61 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/response"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSelected(t *testing.T) {
+	all := map[response.Class]struct{}{response.All: {}}
+	rules := []Rule{
+		{NameScope: "example.org.", Class: all, Types: map[uint16]struct{}{dns.TypeMX: {}}, Sample: 1},
+		{NameScope: "example.org.", Class: map[response.Class]struct{}{response.Denial: {}}, Sample: 1},
+		{NameScope: "example.net.", Class: all, Sample: 1},
+	}
+
+	nxdomain := new(dns.Msg)
+	nxdomain.SetQuestion("a.example.org.", dns.TypeA)
+	nxdomain.Rcode = dns.RcodeNameError
+	nxdomain.Ns = []dns.RR{test.SOA("example.org. 3600 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 3600 1209600 300")}
+	success := new(dns.Msg)
+	success.SetQuestion("a.example.org.", dns.TypeA)
+	success.Answer = []dns.RR{test.A("a.example.org. 3600 IN A 127.0.0.1")}
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		m        *dns.Msg
+		expected bool
+	}{
+		{"a.example.org.", dns.TypeMX, success, true},
+		{"a.example.org.", dns.TypeA, nxdomain, true},
+		{"a.example.org.", dns.TypeA, success, false},
+		{"a.example.net.", dns.TypeA, success, true},
+		{"a.example.com.", dns.TypeA, success, false},
+		// Without a reply the response is classified as an error.
+		{"a.example.org.", dns.TypeA, nil, false},
+	}
+	for i, tc := range tests {
+		if got := Selected(rules, tc.name, tc.qtype, tc.m); got != tc.expected {
+			t.Errorf("Test %d: expected %t, got %t", i, tc.expected, got)
+		}
+	}
+}
+
+func TestRuleSample(t *testing.T) {
+	r := Rule{NameScope: ".", Class: map[response.Class]struct{}{response.All: {}}, Sample: 0.1}
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if r.sampled() {
+			n++
+		}
+	}
+	// The expected number is 1000, allow for a lot of variance.
+	if n < 700 || n > 1300 {
+		t.Errorf("Expected about 10%% of the queries to be sampled, got %d in 10000", n)
+	}
+}
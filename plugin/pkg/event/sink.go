@@ -0,0 +1,53 @@
+package event
+
+import (
+	"context"
+	"sort"
+)
+
+// Sink exports events to a destination, like a message broker or a database. An Exporter calls
+// Export from a single goroutine with batches of events. The events are shared with other sinks
+// and must not be changed.
+//
+// The context is done when the exporter stops. A sink then still tries to export the batch once,
+// but doesn't wait or retry. When a sink is an io.Closer, it is closed after the last batch.
+type Sink interface {
+	Export(ctx context.Context, events []*Event) error
+}
+
+// Fielder is implemented by sinks that always need some optional fields, whatever fields are
+// configured.
+type Fielder interface {
+	Fields() Fields
+}
+
+// SinkFunc returns the sink for the arguments of its Corefile directive, and the destination of
+// the sink for logs and metrics. The events are encoded with enc, when the sink encodes them.
+type SinkFunc func(args []string, enc Encoding) (sink Sink, destination string, err error)
+
+var sinks = make(map[string]SinkFunc)
+
+// RegisterSink registers the sink named name, which is created with f. It should be called from
+// the init function of the package that implements the sink.
+func RegisterSink(name string, f SinkFunc) {
+	if _, ok := sinks[name]; ok {
+		panic("event: sink " + name + " registered twice")
+	}
+	sinks[name] = f
+}
+
+// LookupSink returns the function that creates the sink named name.
+func LookupSink(name string) (SinkFunc, bool) {
+	f, ok := sinks[name]
+	return f, ok
+}
+
+// SinkNames returns the names of the registered sinks, sorted.
+func SinkNames() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
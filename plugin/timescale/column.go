@@ -0,0 +1,118 @@
+package timescale
+
+import (
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+// Column is a column of the queries table and how its value is taken from an event.
+type Column struct {
+	Name   string
+	Fields event.Fields // Optional event fields the value needs.
+	Value  func(e *event.Event) interface{}
+}
+
+// baseColumns are always written, in this order.
+var baseColumns = []Column{
+	{Name: "ts", Value: func(e *event.Event) interface{} { return e.Time }},
+	{Name: "ip", Value: func(e *event.Event) interface{} { return e.IP }},
+	{Name: "qname", Value: func(e *event.Event) interface{} { return e.QName }},
+	{Name: "qtype", Value: func(e *event.Event) interface{} { return e.QType }},
+	{Name: "rcode", Value: func(e *event.Event) interface{} { return e.Rcode }},
+	{Name: "duration_us", Value: func(e *event.Event) interface{} { return e.DurationUS }},
+	{Name: "host", Value: func(e *event.Event) interface{} { return e.NSHostname }},
+}
+
+// optionalColumns are written when they are selected in the Corefile, by their field name.
+var optionalColumns = map[string]Column{
+	"protocol": {Name: "protocol", Fields: event.Transport, Value: func(e *event.Event) interface{} { return e.Transport }},
+	"size":     {Name: "response_size", Fields: event.Size, Value: func(e *event.Event) interface{} { return e.ResponseSize }},
+	"answers":  {Name: "answer_count", Fields: event.Answers, Value: func(e *event.Event) interface{} { return len(e.Answers) }},
+	"ecs": {Name: "ecs", Fields: event.EDNS0, Value: func(e *event.Event) interface{} {
+		if e.EDNS == nil || e.EDNS.ECS == "" {
+			return nil
+		}
+		return e.EDNS.ECS
+	}},
+	"do": {Name: "dnssec_ok", Fields: event.EDNS0, Value: func(e *event.Event) interface{} { return e.EDNS != nil && e.EDNS.DO }},
+}
+
+// labelColumn returns the column holding the value of the metadata label, like geoip/country/code
+// in the geoip_country_code column. Queries without the label get NULL.
+func labelColumn(label string) Column {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, label)
+	return Column{Name: name, Fields: event.Metadata, Value: func(e *event.Event) interface{} {
+		if v, ok := e.Labels[label]; ok {
+			return v
+		}
+		return nil
+	}}
+}
+
+// columnNames returns the names of columns.
+func columnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// The default prefix lengths of anonymized addresses.
+const (
+	defaultV4Prefix = 24
+	defaultV6Prefix = 48
+)
+
+// Anonymizer truncates the client addresses to a prefix, so single clients can't be identified.
+type Anonymizer struct {
+	V4 int // Prefix length of IPv4 addresses.
+	V6 int // Prefix length of IPv6 addresses.
+}
+
+// Anonymize returns a copy of e with the client address and the client subnet truncated.
+func (a Anonymizer) Anonymize(e *event.Event) *event.Event {
+	c := *e
+	if ip := net.ParseIP(c.IP); ip != nil {
+		c.IP = ip.Mask(a.mask(ip)).String()
+	}
+	if c.EDNS == nil || c.EDNS.ECS == "" {
+		return &c
+	}
+	ip, subnet, err := net.ParseCIDR(c.EDNS.ECS)
+	if err != nil {
+		return &c
+	}
+	// Keep the subnet when it is already shorter than the prefix.
+	mask := a.mask(ip)
+	if n, _ := subnet.Mask.Size(); n < prefixLen(mask) {
+		mask = subnet.Mask
+	}
+	edns := *c.EDNS
+	edns.ECS = (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+	c.EDNS = &edns
+	return &c
+}
+
+// mask returns the mask of the prefix for ip.
+func (a Anonymizer) mask(ip net.IP) net.IPMask {
+	if ip.To4() != nil {
+		return net.CIDRMask(a.V4, 32)
+	}
+	return net.CIDRMask(a.V6, 128)
+}
+
+func prefixLen(m net.IPMask) int {
+	n, _ := m.Size()
+	return n
+}
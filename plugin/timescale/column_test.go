@@ -0,0 +1,44 @@
+package timescale
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func TestAnonymize(t *testing.T) {
+	a := Anonymizer{V4: 24, V6: 48}
+	tests := []struct {
+		ip, ecs                 string
+		expectedIP, expectedECS string
+	}{
+		{"192.0.2.10", "", "192.0.2.0", ""},
+		{"2001:db8:1:2::10", "", "2001:db8:1::", ""},
+		{"192.0.2.10", "198.51.100.7/32", "192.0.2.0", "198.51.100.0/24"},
+		// Subnets that are already shorter are kept.
+		{"192.0.2.10", "198.51.0.0/16", "192.0.2.0", "198.51.0.0/16"},
+		{"2001:db8:1:2::10", "2001:db8:1:2::/64", "2001:db8:1::", "2001:db8:1::/48"},
+	}
+	for i, tc := range tests {
+		e := &event.Event{IP: tc.ip}
+		if tc.ecs != "" {
+			e.EDNS = &event.EDNS{ECS: tc.ecs}
+		}
+		e = a.Anonymize(e)
+		if e.IP != tc.expectedIP {
+			t.Errorf("Test %d: expected IP %s, got %s", i, tc.expectedIP, e.IP)
+		}
+		if e.EDNS != nil && e.EDNS.ECS != tc.expectedECS {
+			t.Errorf("Test %d: expected ECS %s, got %s", i, tc.expectedECS, e.EDNS.ECS)
+		}
+	}
+}
+
+func TestLabelColumn(t *testing.T) {
+	if c := labelColumn("geoip/country/code"); c.Name != "geoip_country_code" {
+		t.Errorf("Expected column geoip_country_code, got %s", c.Name)
+	}
+	if c := labelColumn("Kubernetes/client-namespace"); c.Name != "kubernetes_client_namespace" {
+		t.Errorf("Expected column kubernetes_client_namespace, got %s", c.Name)
+	}
+}
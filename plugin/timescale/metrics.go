@@ -0,0 +1,54 @@
+package timescale
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// failedCount is the number of copies into the database that failed.
+	failedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "failed_flushes_total",
+		Help:      "Counter of failed writes to the database.",
+	}, []string{"database"})
+	// spilledCount is the number of rows spilled to disk.
+	spilledCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "spilled_rows_total",
+		Help:      "Counter of rows spilled to disk.",
+	}, []string{"database"})
+	// replayedCount is the number of spilled rows written to the database.
+	replayedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "replayed_rows_total",
+		Help:      "Counter of spilled rows written to the database.",
+	}, []string{"database"})
+	// droppedCount is the number of rows that were dropped by the spill, by reason.
+	droppedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "dropped_spilled_rows_total",
+		Help:      "Counter of rows dropped from the spill directory, by reason.",
+	}, []string{"database", "reason"})
+	// spillFiles is the number of spill files waiting to be written.
+	spillFiles = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "spill_files",
+		Help:      "Number of spill files waiting to be written.",
+	}, []string{"database"})
+	// flushDuration is the time writing a batch takes.
+	flushDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "timescale",
+		Name:      "flush_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time writing a batch took.",
+	}, []string{"database"})
+)
@@ -0,0 +1,169 @@
+package timescale
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// table is the hypertable the queries are written to.
+const table = "queries"
+
+// migrations are the statements that create and upgrade the schema. Schema version n is reached
+// by applying migrations[n-1], a migration is never changed after it was released.
+var migrations = [][]string{
+	// 1: the raw queries hypertable.
+	{
+		`CREATE TABLE IF NOT EXISTS queries (
+			ts TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+			ip INET NOT NULL,
+			qname TEXT NOT NULL,
+			qtype TEXT NOT NULL,
+			rcode TEXT NOT NULL,
+			duration_us INTEGER NOT NULL,
+			host TEXT NOT NULL
+		)`,
+		`SELECT create_hypertable('queries', 'ts', if_not_exists => TRUE)`,
+	},
+	// 2: per-minute query counts, so dashboards don't have to scan the raw rows.
+	{
+		`CREATE MATERIALIZED VIEW IF NOT EXISTS queries_per_minute
+		WITH (timescaledb.continuous) AS
+		SELECT time_bucket('1 minute', ts) AS bucket, qname, qtype, rcode, host, count(*) AS queries
+		FROM queries
+		GROUP BY bucket, qname, qtype, rcode, host
+		WITH NO DATA`,
+		`SELECT add_continuous_aggregate_policy('queries_per_minute',
+			start_offset => INTERVAL '1 hour',
+			end_offset => INTERVAL '1 minute',
+			schedule_interval => INTERVAL '1 minute',
+			if_not_exists => TRUE)`,
+	},
+	// 3: the optional columns.
+	{
+		`ALTER TABLE queries
+			ADD COLUMN IF NOT EXISTS protocol TEXT,
+			ADD COLUMN IF NOT EXISTS response_size INTEGER,
+			ADD COLUMN IF NOT EXISTS answer_count INTEGER,
+			ADD COLUMN IF NOT EXISTS ecs INET,
+			ADD COLUMN IF NOT EXISTS dnssec_ok BOOLEAN`,
+	},
+}
+
+const (
+	// migrationLock is the key of the advisory lock that serializes migrations of servers that
+	// share a database.
+	migrationLock = 0x636f7265646e73
+
+	createVersionTableSQL = `CREATE TABLE IF NOT EXISTS timescale_schema (
+		version INTEGER PRIMARY KEY,
+		applied TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	)`
+	versionSQL       = `SELECT COALESCE(max(version), 0) FROM timescale_schema`
+	insertVersionSQL = `INSERT INTO timescale_schema (version) VALUES ($1)`
+
+	compressionEnabledSQL = `SELECT compression_enabled FROM timescaledb_information.hypertables WHERE hypertable_name = 'queries'`
+)
+
+// Policies are the TimescaleDB policies of the schema. A zero duration leaves the policy as it is.
+type Policies struct {
+	Retention          time.Duration // Age after which raw rows are dropped.
+	AggregateRetention time.Duration // Age after which per-minute counts are dropped.
+	Compression        time.Duration // Age after which raw rows are compressed.
+}
+
+// beginner starts transactions, it is implemented by *pgxpool.Pool.
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Migrate creates or upgrades the schema to the latest version, adds the columns of the metadata
+// labels and sets the policies, in a single transaction.
+func Migrate(ctx context.Context, db beginner, p Policies, labels []string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(migrationLock)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, createVersionTableSQL); err != nil {
+		return err
+	}
+	var version int
+	if err := tx.QueryRow(ctx, versionSQL).Scan(&version); err != nil {
+		return err
+	}
+
+	for v := version; v < len(migrations); v++ {
+		for _, stmt := range migrations[v] {
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, insertVersionSQL, v+1); err != nil {
+			return err
+		}
+		log.Infof("Migrated the schema to version %d", v+1)
+	}
+
+	// Metadata columns depend on the configuration, they are not part of a schema version.
+	for _, l := range labels {
+		if _, err := tx.Exec(ctx, "ALTER TABLE queries ADD COLUMN IF NOT EXISTS "+pgx.Identifier{l}.Sanitize()+" TEXT"); err != nil {
+			return err
+		}
+	}
+
+	if err := setPolicies(ctx, tx, p); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// setPolicies replaces the policies that are set in p.
+func setPolicies(ctx context.Context, tx pgx.Tx, p Policies) error {
+	if p.Retention > 0 {
+		if err := replacePolicy(ctx, tx,
+			`SELECT remove_retention_policy('queries', if_exists => TRUE)`,
+			`SELECT add_retention_policy('queries', make_interval(secs => $1))`, p.Retention.Seconds()); err != nil {
+			return err
+		}
+	}
+	if p.AggregateRetention > 0 {
+		if err := replacePolicy(ctx, tx,
+			`SELECT remove_retention_policy('queries_per_minute', if_exists => TRUE)`,
+			`SELECT add_retention_policy('queries_per_minute', make_interval(secs => $1))`, p.AggregateRetention.Seconds()); err != nil {
+			return err
+		}
+	}
+	if p.Compression > 0 {
+		// The compression settings can't be changed once chunks are compressed, only set them once.
+		var enabled bool
+		if err := tx.QueryRow(ctx, compressionEnabledSQL).Scan(&enabled); err != nil {
+			return err
+		}
+		if !enabled {
+			if _, err := tx.Exec(ctx, `ALTER TABLE queries SET (timescaledb.compress, timescaledb.compress_segmentby = 'host', timescaledb.compress_orderby = 'ts DESC')`); err != nil {
+				return err
+			}
+		}
+		if err := replacePolicy(ctx, tx,
+			`SELECT remove_compression_policy('queries', if_exists => TRUE)`,
+			`SELECT add_compression_policy('queries', make_interval(secs => $1))`, p.Compression.Seconds()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePolicy removes a policy with remove and adds it again with add and its argument.
+func replacePolicy(ctx context.Context, tx pgx.Tx, remove, add string, arg float64) error {
+	if _, err := tx.Exec(ctx, remove); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, add, arg)
+	return err
+}
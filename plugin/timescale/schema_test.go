@@ -0,0 +1,109 @@
+package timescale
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeTx records the executed statements. Queries return version and enabled.
+type fakeTx struct {
+	pgx.Tx
+	stmts     []string
+	version   int
+	enabled   bool
+	committed bool
+}
+
+func (f *fakeTx) Exec(_ context.Context, sql string, _ ...interface{}) (pgconn.CommandTag, error) {
+	f.stmts = append(f.stmts, sql)
+	return nil, nil
+}
+
+func (f *fakeTx) QueryRow(_ context.Context, sql string, _ ...interface{}) pgx.Row {
+	return fakeRow{tx: f, sql: sql}
+}
+
+func (f *fakeTx) Commit(context.Context) error   { f.committed = true; return nil }
+func (f *fakeTx) Rollback(context.Context) error { return nil }
+
+type fakeRow struct {
+	tx  *fakeTx
+	sql string
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	switch d := dest[0].(type) {
+	case *int:
+		*d = r.tx.version
+	case *bool:
+		*d = r.tx.enabled
+	}
+	return nil
+}
+
+type fakeBeginner struct{ tx *fakeTx }
+
+func (f fakeBeginner) Begin(context.Context) (pgx.Tx, error) { return f.tx, nil }
+
+// count returns the number of statements that contain s.
+func (f *fakeTx) count(s string) int {
+	n := 0
+	for _, stmt := range f.stmts {
+		if strings.Contains(stmt, s) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		version     int
+		enabled     bool
+		policies    Policies
+		labels      []string
+		expectedSQL map[string]int
+	}{
+		{0, false, Policies{}, nil, map[string]int{
+			"pg_advisory_xact_lock": 1, "CREATE TABLE IF NOT EXISTS queries": 1, "queries_per_minute": 2,
+			"ADD COLUMN IF NOT EXISTS protocol": 1, "INSERT INTO timescale_schema": 3, "retention_policy": 0, "compress": 0,
+		}},
+		{1, false, Policies{}, nil, map[string]int{
+			"CREATE TABLE IF NOT EXISTS queries": 0, "queries_per_minute": 2, "INSERT INTO timescale_schema": 2,
+		}},
+		{len(migrations), false, Policies{}, []string{"geoip_country_code"}, map[string]int{
+			"INSERT INTO timescale_schema": 0, `ADD COLUMN IF NOT EXISTS "geoip_country_code" TEXT`: 1,
+		}},
+		{len(migrations), false, Policies{Retention: time.Hour, AggregateRetention: 2 * time.Hour}, nil, map[string]int{
+			"INSERT INTO timescale_schema": 0, "remove_retention_policy('queries'": 1, "add_retention_policy('queries'": 1,
+			"remove_retention_policy('queries_per_minute'": 1, "add_retention_policy('queries_per_minute'": 1, "compress": 0,
+		}},
+		{len(migrations), false, Policies{Compression: time.Hour}, nil, map[string]int{
+			"ALTER TABLE queries SET": 1, "add_compression_policy": 1, "retention_policy": 0,
+		}},
+		{len(migrations), true, Policies{Compression: time.Hour}, nil, map[string]int{
+			"ALTER TABLE queries SET": 0, "remove_compression_policy": 1, "add_compression_policy": 1,
+		}},
+	}
+
+	for i, test := range tests {
+		tx := &fakeTx{version: test.version, enabled: test.enabled}
+		if err := Migrate(context.TODO(), fakeBeginner{tx}, test.policies, test.labels); err != nil {
+			t.Errorf("Test %d: expected no error, got %s", i, err)
+			continue
+		}
+		if !tx.committed {
+			t.Errorf("Test %d: expected the transaction to be committed", i)
+		}
+		for sql, n := range test.expectedSQL {
+			if got := tx.count(sql); got != n {
+				t.Errorf("Test %d: expected %d statements with %q, got %d", i, n, sql, got)
+			}
+		}
+	}
+}
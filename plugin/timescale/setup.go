@@ -1,13 +1,18 @@
-package log
+package timescale
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -18,121 +23,291 @@ func init() {
 	})
 }
 
-func setup(c *caddy.Controller) error {
-	logger := Logger{}
-	hostname, _ := os.Hostname()
-	logger.Hostname = hostname
-	c.Next()
+// config holds everything parsed from the Corefile.
+type config struct {
+	pool      *pgxpool.Config
+	exporter  event.Config // Rules, queue and batches.
+	timeout   time.Duration
+	retries   int
+	retryWait time.Duration
+	spill     string           // Spill directory, empty when spilling is disabled.
+	spillMax  int              // Maximum number of spill files, 0 means no limit.
+	spillDrop event.DropPolicy // What is dropped when there are spillMax files.
+	migrate   bool             // Whether the schema is created and migrated on startup.
+	policies  Policies
+	columns   []Column
+	labels    []string    // Columns of the metadata labels.
+	anonymize *Anonymizer // Nil when addresses are not anonymized.
+}
 
-	if !c.NextArg() {
-		return plugin.Error("timescale", c.ArgErr())
+func setup(c *caddy.Controller) error {
+	cfg, err := timescaleParse(c)
+	if err != nil {
+		return plugin.Error("timescale", err)
 	}
-	connString := c.Val()
-	log.Println("[timescale] connecting to", connString)
+	db := database(cfg.pool)
 
-	dbPool, err := pgxpool.Connect(context.Background(), connString)
+	// Don't wait for the database on startup, rows are queued or spilled until it is reachable.
+	cfg.pool.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), cfg.pool)
 	if err != nil {
-		return err
+		return plugin.Error("timescale", err)
 	}
-	logger.Pool = dbPool
 
-	if c.NextArg() {
-		return plugin.Error("timescale", c.ArgErr())
+	w := NewWriter(pool, db, pgx.Identifier{table}, cfg.columns)
+	if cfg.migrate {
+		w.prepare = func(ctx context.Context) error { return Migrate(ctx, pool, cfg.policies, cfg.labels) }
+	}
+	w.anonymize = cfg.anonymize
+	w.timeout = cfg.timeout
+	w.retries = cfg.retries
+	w.retryWait = cfg.retryWait
+	if cfg.spill != "" {
+		if w.spill, err = newSpill(cfg.spill, db); err != nil {
+			pool.Close()
+			return plugin.Error("timescale", err)
+		}
+		w.spill.max, w.spill.drop = cfg.spillMax, cfg.spillDrop
 	}
 
-	dnsserver.
-		GetConfig(c).
-		AddPlugin(func(next plugin.Handler) plugin.Handler {
-			logger.Next = next
-			return logger
-		})
+	x := event.NewExporter("timescale", db, w, cfg.exporter)
+	c.OnStartup(func() error {
+		log.Infof("Writing queries to %s", db)
+		x.Start()
+		return nil
+	})
+	c.OnShutdown(func() error {
+		x.Stop()
+		pool.Close()
+		return nil
+	})
+
+	hostname, _ := os.Hostname()
+	h := event.Handler{Plugin: "timescale", Exporters: []*event.Exporter{x}, Hostname: hostname}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		h.Next = next
+		return h
+	})
 
 	return nil
 }
 
-// func setup(c *caddy.Controller) error {
-// 	rules, err := logParse(c)
-// 	if err != nil {
-// 		return plugin.Error("log", err)
-// 	}
-
-// 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-// 		return Logger{Next: next, Rules: rules, repl: replacer.New()}
-// 	})
-
-// 	return nil
-// }
-
-// func logParse(c *caddy.Controller) ([]Rule, error) {
-// 	var rules []Rule
-
-// 	for c.Next() {
-// 		args := c.RemainingArgs()
-// 		length := len(rules)
-
-// 		switch len(args) {
-// 		case 0:
-// 			// Nothing specified; use defaults
-// 			rules = append(rules, Rule{
-// 				NameScope: ".",
-// 				Format:    DefaultLogFormat,
-// 				Class:     make(map[response.Class]struct{}),
-// 			})
-// 		case 1:
-// 			rules = append(rules, Rule{
-// 				NameScope: dns.Fqdn(args[0]),
-// 				Format:    DefaultLogFormat,
-// 				Class:     make(map[response.Class]struct{}),
-// 			})
-// 		default:
-// 			// Name scopes, and maybe a format specified
-// 			format := DefaultLogFormat
-
-// 			if strings.Contains(args[len(args)-1], "{") {
-// 				format = args[len(args)-1]
-// 				format = strings.Replace(format, "{common}", CommonLogFormat, -1)
-// 				format = strings.Replace(format, "{combined}", CombinedLogFormat, -1)
-// 				args = args[:len(args)-1]
-// 			}
-
-// 			for _, str := range args {
-// 				rules = append(rules, Rule{
-// 					NameScope: dns.Fqdn(str),
-// 					Format:    format,
-// 					Class:     make(map[response.Class]struct{}),
-// 				})
-// 			}
-// 		}
-
-// 		// Class refinements in an extra block.
-// 		classes := make(map[response.Class]struct{})
-// 		for c.NextBlock() {
-// 			switch c.Val() {
-// 			// class followed by combinations of all, denial, error and success.
-// 			case "class":
-// 				classesArgs := c.RemainingArgs()
-// 				if len(classesArgs) == 0 {
-// 					return nil, c.ArgErr()
-// 				}
-// 				for _, c := range classesArgs {
-// 					cls, err := response.ClassFromString(c)
-// 					if err != nil {
-// 						return nil, err
-// 					}
-// 					classes[cls] = struct{}{}
-// 				}
-// 			default:
-// 				return nil, c.ArgErr()
-// 			}
-// 		}
-// 		if len(classes) == 0 {
-// 			classes[response.All] = struct{}{}
-// 		}
-
-// 		for i := len(rules) - 1; i >= length; i-- {
-// 			rules[i].Class = classes
-// 		}
-// 	}
-
-// 	return rules, nil
-// }
+func timescaleParse(c *caddy.Controller) (*config, error) {
+	cfg := &config{
+		exporter: event.Config{
+			QueueSize:     defaultQueueSize,
+			BatchSize:     defaultBatchSize,
+			FlushInterval: defaultFlushInterval,
+		},
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+		retryWait: defaultRetryWait,
+		spillMax:  defaultSpillFiles,
+		migrate:   true,
+		columns:   append([]Column{}, baseColumns...),
+	}
+
+	i := 0
+	for c.Next() {
+		if i > 0 {
+			return nil, plugin.ErrOnce
+		}
+		i++
+
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		var err error
+		if cfg.pool, err = pgxpool.ParseConfig(args[0]); err != nil {
+			return nil, err
+		}
+
+		for c.NextBlock() {
+			if ok, err := cfg.exporter.Parse(c); ok {
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			switch c.Val() {
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if d <= 0 {
+					return nil, c.Errf("timeout provided is invalid: %s", d)
+				}
+				cfg.timeout = d
+			case "retry":
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, err
+				}
+				if n < 0 {
+					return nil, c.Errf("retry count provided is invalid: %d", n)
+				}
+				cfg.retries = n
+				if len(args) == 2 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil {
+						return nil, err
+					}
+					if d <= 0 {
+						return nil, c.Errf("retry wait provided is invalid: %s", d)
+					}
+					cfg.retryWait = d
+				}
+			case "schema":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				switch args[0] {
+				case "auto":
+					cfg.migrate = true
+				case "manual":
+					cfg.migrate = false
+				default:
+					return nil, c.Errf("unknown schema mode '%s'", args[0])
+				}
+			case "retention":
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				d, err := parseInterval(args[0])
+				if err != nil {
+					return nil, c.Errf("retention provided is invalid: %s", args[0])
+				}
+				cfg.policies.Retention = d
+				if len(args) == 2 {
+					d, err := parseInterval(args[1])
+					if err != nil {
+						return nil, c.Errf("aggregate retention provided is invalid: %s", args[1])
+					}
+					cfg.policies.AggregateRetention = d
+				}
+			case "compression":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := parseInterval(args[0])
+				if err != nil {
+					return nil, c.Errf("compression provided is invalid: %s", args[0])
+				}
+				cfg.policies.Compression = d
+			case "columns":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, a := range args {
+					col, ok := optionalColumns[a]
+					if !ok {
+						// Metadata labels are named like plugin/label.
+						if !strings.Contains(a, "/") {
+							return nil, c.Errf("unknown column '%s'", a)
+						}
+						col = labelColumn(a)
+						cfg.labels = append(cfg.labels, col.Name)
+					}
+					for _, existing := range cfg.columns {
+						if existing.Name == col.Name {
+							return nil, c.Errf("duplicate column '%s'", col.Name)
+						}
+					}
+					cfg.columns = append(cfg.columns, col)
+				}
+			case "anonymize":
+				args := c.RemainingArgs()
+				if len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				a := &Anonymizer{V4: defaultV4Prefix, V6: defaultV6Prefix}
+				if len(args) > 0 {
+					n, err := strconv.Atoi(args[0])
+					if err != nil || n < 0 || n > 32 {
+						return nil, c.Errf("IPv4 prefix length provided is invalid: %s", args[0])
+					}
+					a.V4 = n
+				}
+				if len(args) > 1 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n < 0 || n > 128 {
+						return nil, c.Errf("IPv6 prefix length provided is invalid: %s", args[1])
+					}
+					a.V6 = n
+				}
+				cfg.anonymize = a
+			case "spill":
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				cfg.spill = args[0]
+				if len(args) > 1 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n < 0 {
+						return nil, c.Errf("spill file limit provided is invalid: %s", args[1])
+					}
+					cfg.spillMax = n
+				}
+				if len(args) > 2 {
+					switch args[2] {
+					case "newest":
+						cfg.spillDrop = event.DropNewest
+					case "oldest":
+						cfg.spillDrop = event.DropOldest
+					default:
+						return nil, c.Errf("unknown spill drop policy '%s'", args[2])
+					}
+				}
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+
+		if !cfg.migrate && cfg.policies != (Policies{}) {
+			return nil, c.Err("retention and compression require the automatic schema")
+		}
+	}
+	return cfg, nil
+}
+
+// parseInterval parses a duration that can also be given in days, like 30d.
+func parseInterval(s string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("interval must be positive: %s", s)
+	}
+	return d, nil
+}
+
+// database returns the database p connects to, for logging and metrics.
+func database(p *pgxpool.Config) string {
+	return fmt.Sprintf("%s:%d/%s", p.ConnConfig.Host, p.ConnConfig.Port, p.ConnConfig.Database)
+}
@@ -0,0 +1,231 @@
+package timescale
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+func TestTimescaleParse(t *testing.T) {
+	tests := []struct {
+		input         string
+		shouldErr     bool
+		expectedErr   string
+		expectedQueue int
+		expectedDrop  event.DropPolicy
+		expectedBatch int
+		expectedFlush time.Duration
+	}{
+		{`timescale postgres://localhost/dns`, false, "", defaultQueueSize, event.DropNewest, defaultBatchSize, defaultFlushInterval},
+		{`timescale postgres://localhost/dns {
+			queue 500
+			drop block 50ms
+			batch 100 5s
+			timeout 2s
+			retry 5 100ms
+			spill /var/lib/coredns/spill
+		}`, false, "", 500, event.Block, 100, 5 * time.Second},
+		{`timescale postgres://localhost/dns {
+			drop oldest
+		}`, false, "", defaultQueueSize, event.DropOldest, defaultBatchSize, defaultFlushInterval},
+		// fails
+		{`timescale`, true, "Wrong argument count", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			queue -1
+		}`, true, "queue size provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			drop random
+		}`, true, "unknown drop policy", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			drop oldest 1s
+		}`, true, "only the block drop policy", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			batch 0
+		}`, true, "batch size provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			retry -1
+		}`, true, "retry count provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			spill
+		}`, true, "Wrong argument count", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			spill /var/lib/coredns/spill -1
+		}`, true, "spill file limit provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			spill /var/lib/coredns/spill 100 random
+		}`, true, "unknown spill drop policy", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			schema bogus
+		}`, true, "unknown schema mode", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			retention 0d
+		}`, true, "retention provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			retention 30d 1y
+		}`, true, "aggregate retention provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			compression
+		}`, true, "Wrong argument count", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			schema manual
+			retention 30d
+		}`, true, "require the automatic schema", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			columns
+		}`, true, "Wrong argument count", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			columns bogus
+		}`, true, "unknown column", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			columns size size
+		}`, true, "duplicate column", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			rule example.org. class bogus
+		}`, true, "invalid Class", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			anonymize 33
+		}`, true, "IPv4 prefix length provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			anonymize 24 129
+		}`, true, "IPv6 prefix length provided is invalid", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns {
+			bogus
+		}`, true, "unknown property", 0, 0, 0, 0},
+		{`timescale postgres://localhost/dns
+		timescale postgres://localhost/dns`, true, "this plugin", 0, 0, 0, 0},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := timescaleParse(c)
+
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+			} else if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if cfg.exporter.QueueSize != test.expectedQueue {
+			t.Errorf("Test %d: expected queue size %d, got %d", i, test.expectedQueue, cfg.exporter.QueueSize)
+		}
+		if cfg.exporter.Drop != test.expectedDrop {
+			t.Errorf("Test %d: expected drop policy %d, got %d", i, test.expectedDrop, cfg.exporter.Drop)
+		}
+		if cfg.exporter.BatchSize != test.expectedBatch || cfg.exporter.FlushInterval != test.expectedFlush {
+			t.Errorf("Test %d: expected batch %d %s, got %d %s", i, test.expectedBatch, test.expectedFlush, cfg.exporter.BatchSize, cfg.exporter.FlushInterval)
+		}
+	}
+}
+
+func TestTimescaleParseSchema(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedMigrate  bool
+		expectedPolicies Policies
+	}{
+		{`timescale postgres://localhost/dns`, true, Policies{}},
+		{`timescale postgres://localhost/dns {
+			schema manual
+		}`, false, Policies{}},
+		{`timescale postgres://localhost/dns {
+			schema auto
+			retention 30d 365d
+			compression 12h
+		}`, true, Policies{Retention: 30 * 24 * time.Hour, AggregateRetention: 365 * 24 * time.Hour, Compression: 12 * time.Hour}},
+		{`timescale postgres://localhost/dns {
+			retention 72h
+		}`, true, Policies{Retention: 72 * time.Hour}},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := timescaleParse(c)
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if cfg.migrate != test.expectedMigrate {
+			t.Errorf("Test %d: expected migrate %t, got %t", i, test.expectedMigrate, cfg.migrate)
+		}
+		if cfg.policies != test.expectedPolicies {
+			t.Errorf("Test %d: expected policies %+v, got %+v", i, test.expectedPolicies, cfg.policies)
+		}
+	}
+}
+
+func TestTimescaleParseSpill(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedMax  int
+		expectedDrop event.DropPolicy
+	}{
+		{"spill /var/lib/coredns/spill", defaultSpillFiles, event.DropNewest},
+		{"spill /var/lib/coredns/spill 100", 100, event.DropNewest},
+		{"spill /var/lib/coredns/spill 100 oldest", 100, event.DropOldest},
+		{"spill /var/lib/coredns/spill 0", 0, event.DropNewest},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", "timescale postgres://localhost/dns {\n"+test.input+"\n}")
+		cfg, err := timescaleParse(c)
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if cfg.spillMax != test.expectedMax || cfg.spillDrop != test.expectedDrop {
+			t.Errorf("Test %d: expected a limit of %d files dropping %d, got %d dropping %d", i, test.expectedMax, test.expectedDrop, cfg.spillMax, cfg.spillDrop)
+		}
+	}
+}
+
+func TestTimescaleParseColumns(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedColumns   []string
+		expectedLabels    []string
+		expectedRules     int
+		expectedAnonymize *Anonymizer
+	}{
+		{`timescale postgres://localhost/dns`, columnNames(baseColumns), nil, 0, nil},
+		{`timescale postgres://localhost/dns {
+			columns protocol size
+			columns answers ecs do geoip/country/code
+			rule example.org. example.net. class denial sample 0.5
+			anonymize
+		}`, append(columnNames(baseColumns), "protocol", "response_size", "answer_count", "ecs", "dnssec_ok", "geoip_country_code"),
+			[]string{"geoip_country_code"}, 2, &Anonymizer{V4: 24, V6: 48}},
+		{`timescale postgres://localhost/dns {
+			anonymize 16 32
+		}`, columnNames(baseColumns), nil, 0, &Anonymizer{V4: 16, V6: 32}},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := timescaleParse(c)
+		if err != nil {
+			t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if got := columnNames(cfg.columns); !equal(got, test.expectedColumns) {
+			t.Errorf("Test %d: expected columns %v, got %v", i, test.expectedColumns, got)
+		}
+		if !equal(cfg.labels, test.expectedLabels) {
+			t.Errorf("Test %d: expected label columns %v, got %v", i, test.expectedLabels, cfg.labels)
+		}
+		if len(cfg.exporter.Rules) != test.expectedRules {
+			t.Errorf("Test %d: expected %d rules, got %d", i, test.expectedRules, len(cfg.exporter.Rules))
+		}
+		if (cfg.anonymize == nil) != (test.expectedAnonymize == nil) || (cfg.anonymize != nil && *cfg.anonymize != *test.expectedAnonymize) {
+			t.Errorf("Test %d: expected anonymizer %v, got %v", i, test.expectedAnonymize, cfg.anonymize)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package timescale
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+)
+
+// The types of the values in a row must be registered to encode them in an interface{}.
+func init() { gob.Register(time.Time{}) }
+
+const (
+	spillSuffix       = ".spill"
+	defaultSpillFiles = 10000
+)
+
+// errSpillFull is returned when a batch is not spilled because the spill directory is full.
+var errSpillFull = errors.New("spill directory is full")
+
+// spill stores the batches that could not be written in files in a directory, so they can be
+// written when the database is back.
+type spill struct {
+	dir      string
+	database string // Database name for the metrics.
+	seq      uint64
+
+	max  int              // Maximum number of files, 0 means no limit.
+	drop event.DropPolicy // Which batch is dropped when there are max files, DropNewest or DropOldest.
+}
+
+// spilled is the content of a spill file.
+type spilled struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// newSpill returns a spill that stores batches in dir, which is created when it doesn't exist.
+func newSpill(dir, database string) (*spill, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &spill{dir: dir, database: database, max: defaultSpillFiles, drop: event.DropNewest}
+	names, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	spillFiles.WithLabelValues(database).Set(float64(len(names)))
+	return s, nil
+}
+
+// write stores rows with columns in a new file. The file is written under a temporary name and
+// renamed, so a partially written file is never read. When there are s.max files already, either
+// rows are dropped and errSpillFull is returned, or the oldest files are removed to make room,
+// depending on s.drop.
+func (s *spill) write(columns []string, rows [][]interface{}) error {
+	if err := s.makeRoom(len(rows)); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), atomic.AddUint64(&s.seq, 1)%1000000, spillSuffix)
+	tmp, err := os.CreateTemp(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(spilled{Columns: columns, Rows: rows}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	spillFiles.WithLabelValues(s.database).Inc()
+	return nil
+}
+
+// makeRoom makes room for a new file with n rows when there are s.max files, the rows that are
+// dropped to do so are counted.
+func (s *spill) makeRoom(n int) error {
+	if s.max == 0 {
+		return nil
+	}
+	names, err := s.files()
+	if err != nil {
+		return err
+	}
+	if len(names) < s.max {
+		return nil
+	}
+	if s.drop != event.DropOldest {
+		droppedCount.WithLabelValues(s.database, "spill_full").Add(float64(n))
+		return errSpillFull
+	}
+	for _, name := range names[:len(names)-s.max+1] {
+		// The rows are only read to count them, a file that can't be read is removed all the same.
+		_, rows, _ := s.read(name)
+		if err := s.remove(name); err != nil {
+			return err
+		}
+		droppedCount.WithLabelValues(s.database, "spill_full").Add(float64(len(rows)))
+	}
+	return nil
+}
+
+// next returns the oldest spilled batch and the name of its file. The name is empty when there are
+// no spilled batches.
+func (s *spill) next() (string, []string, [][]interface{}, error) {
+	names, err := s.files()
+	if err != nil || len(names) == 0 {
+		return "", nil, nil, err
+	}
+	name := names[0]
+	columns, rows, err := s.read(name)
+	return name, columns, rows, err
+}
+
+// read returns the batch in the spill file name.
+func (s *spill) read(name string) ([]string, [][]interface{}, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var sp spilled
+	if err := gob.NewDecoder(f).Decode(&sp); err != nil {
+		return nil, nil, err
+	}
+	return sp.Columns, sp.Rows, nil
+}
+
+// remove removes the spill file name.
+func (s *spill) remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	spillFiles.WithLabelValues(s.database).Dec()
+	return nil
+}
+
+// files returns the names of the spill files, oldest first.
+func (s *spill) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), spillSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
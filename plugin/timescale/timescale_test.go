@@ -0,0 +1,75 @@
+package timescale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/event"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/miekg/dns"
+)
+
+func TestWriterRows(t *testing.T) {
+	columns := append([]Column{}, baseColumns...)
+	for _, f := range []string{"protocol", "size", "answers", "ecs", "do"} {
+		columns = append(columns, optionalColumns[f])
+	}
+	columns = append(columns, labelColumn("geoip/country/code"), labelColumn("geoip/city/name"))
+
+	f := &fakeCopier{}
+	w := NewWriter(f, "localhost:5432/dns", pgx.Identifier{"queries"}, columns)
+	w.anonymize = &Anonymizer{V4: 24, V6: 48}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(4096, true)
+	m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 32, Address: []byte{192, 0, 2, 10}})
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "geoip/country/code", func() string { return "NL" })
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	test.ErrorHandler().ServeDNS(ctx, rec, m)
+	e := event.New(ctx, request.Request{W: rec, Req: m}, rec, dns.RcodeServerFailure, "ns1", w.Fields())
+	if err := w.Export(context.TODO(), []*event.Event{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.rows) != 1 || len(f.rows[0]) != len(columns) {
+		t.Fatalf("Expected a row with a value for each of the %d columns, got %v", len(columns), f.rows)
+	}
+	row := f.rows[0]
+	if _, ok := row[0].(time.Time); !ok {
+		t.Errorf("Expected a time in the ts column, got %T", row[0])
+	}
+	expected := map[string]interface{}{
+		"ip":                 "10.240.0.0",
+		"qname":              "example.org.",
+		"qtype":              "A",
+		"rcode":              "SERVFAIL",
+		"host":               "ns1",
+		"protocol":           "udp",
+		"answer_count":       0,
+		"ecs":                "192.0.2.0/24",
+		"dnssec_ok":          true,
+		"geoip_country_code": "NL",
+		"geoip_city_name":    nil,
+	}
+	for i, c := range w.names {
+		if v, ok := expected[c]; ok && row[i] != v {
+			t.Errorf("Expected %v in the %s column, got %v", v, c, row[i])
+		}
+	}
+	if size, ok := row[8].(int); !ok || size == 0 {
+		t.Errorf("Expected the response size in the response_size column, got %v", row[8])
+	}
+	// The event itself is shared with other sinks, and is not anonymized.
+	if e.IP != "10.240.0.1" || e.EDNS.ECS != "192.0.2.10/32" {
+		t.Errorf("Expected the event to be unchanged, got %s and %s", e.IP, e.EDNS.ECS)
+	}
+}
@@ -0,0 +1,184 @@
+package timescale
+
+import (
+	"context"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	defaultQueueSize     = 100000
+	defaultBatchSize     = 1000
+	defaultFlushInterval = time.Second
+	defaultTimeout       = 10 * time.Second
+	defaultRetries       = 3
+	defaultRetryWait     = time.Second
+)
+
+// copier copies rows into a table, it is implemented by *pgxpool.Pool.
+type copier interface {
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, rows pgx.CopyFromSource) (int64, error)
+}
+
+// Writer is the event.Sink that writes events to the database. A batch of events is copied into
+// the table as rows. A batch that can't be written is retried, and spilled to disk when it still
+// fails. Spilled batches are written once the database is back.
+type Writer struct {
+	db        copier
+	database  string // Database name for logs and metrics.
+	table     pgx.Identifier
+	columns   []Column
+	names     []string    // Names of the columns.
+	anonymize *Anonymizer // Nil when the client addresses are written as they are.
+
+	timeout   time.Duration // Timeout of a single copy.
+	retries   int           // Number of times a failed copy is retried.
+	retryWait time.Duration // Wait before the first retry, it doubles on every retry.
+	spill     *spill        // Nil when spilling is disabled.
+
+	// prepare is called before the first copy, until it succeeds. It is used to migrate the schema.
+	prepare  func(context.Context) error
+	prepared bool
+}
+
+// NewWriter returns a writer that copies the events into table, with a row of columns for every
+// event. Until it is used the other settings can be changed.
+func NewWriter(db copier, database string, table pgx.Identifier, columns []Column) *Writer {
+	return &Writer{
+		db:        db,
+		database:  database,
+		table:     table,
+		columns:   columns,
+		names:     columnNames(columns),
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+		retryWait: defaultRetryWait,
+	}
+}
+
+// Fields implements event.Fielder, it returns the optional fields the columns need.
+func (w *Writer) Fields() event.Fields {
+	var f event.Fields
+	for _, c := range w.columns {
+		f |= c.Fields
+	}
+	return f
+}
+
+// Export implements event.Sink. It returns an error only when the batch was neither written nor
+// spilled.
+func (w *Writer) Export(ctx context.Context, events []*event.Event) error {
+	batch := make([][]interface{}, len(events))
+	for i, e := range events {
+		if w.anonymize != nil {
+			e = w.anonymize.Anonymize(e)
+		}
+		row := make([]interface{}, len(w.columns))
+		for j, c := range w.columns {
+			row[j] = c.Value(e)
+		}
+		batch[i] = row
+	}
+
+	err := w.write(ctx, batch)
+	if err == nil {
+		w.replay()
+		return nil
+	}
+	if w.spill == nil {
+		return err
+	}
+	log.Warningf("Failed to write %d rows to %s, spilling them: %s", len(batch), w.database, err)
+	if err := w.spill.write(w.names, batch); err != nil {
+		return err
+	}
+	spilledCount.WithLabelValues(w.database).Add(float64(len(batch)))
+	return nil
+}
+
+// write copies batch into the table, retrying until ctx is done.
+func (w *Writer) write(ctx context.Context, batch [][]interface{}) error {
+	err := w.copy(batch)
+retry:
+	for i, wait := 0, w.retryWait; err != nil && i < w.retries; i, wait = i+1, wait*2 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			// Shutting down, don't keep the queue waiting.
+			break retry
+		}
+		err = w.copy(batch)
+	}
+	return err
+}
+
+// copy copies batch into the table.
+func (w *Writer) copy(batch [][]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	start := time.Now()
+	if w.prepare != nil && !w.prepared {
+		if err := w.prepare(ctx); err != nil {
+			failedCount.WithLabelValues(w.database).Inc()
+			return err
+		}
+		w.prepared = true
+	}
+	_, err := w.db.CopyFrom(ctx, w.table, w.names, pgx.CopyFromRows(batch))
+	flushDuration.WithLabelValues(w.database).Observe(time.Since(start).Seconds())
+	if err != nil {
+		failedCount.WithLabelValues(w.database).Inc()
+		return err
+	}
+	return nil
+}
+
+// replay writes the spilled batches, oldest first, until one fails.
+func (w *Writer) replay() {
+	if w.spill == nil {
+		return
+	}
+	for {
+		name, columns, batch, err := w.spill.next()
+		if name == "" {
+			if err != nil {
+				log.Errorf("Failed to read spilled rows: %s", err)
+			}
+			return
+		}
+
+		switch {
+		case err != nil:
+			log.Errorf("Failed to read spilled rows from %s, removing it: %s", name, err)
+		case !equal(columns, w.names):
+			log.Warningf("Spilled rows in %s have other columns, removing it", name)
+			droppedCount.WithLabelValues(w.database, "columns").Add(float64(len(batch)))
+		default:
+			if err := w.copy(batch); err != nil {
+				log.Warningf("Failed to write spilled rows to %s: %s", w.database, err)
+				return
+			}
+			replayedCount.WithLabelValues(w.database).Add(float64(len(batch)))
+		}
+		if err := w.spill.remove(name); err != nil {
+			log.Errorf("Failed to remove spill file: %s", err)
+			return
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package timescale
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/event"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeCopier records the copied rows. The first fail copies fail.
+type fakeCopier struct {
+	sync.Mutex
+	rows   [][]interface{}
+	copies int
+	fail   int
+}
+
+func (f *fakeCopier) CopyFrom(_ context.Context, _ pgx.Identifier, _ []string, src pgx.CopyFromSource) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.copies++
+	if f.fail > 0 {
+		f.fail--
+		return 0, errors.New("connection refused")
+	}
+	n := int64(0)
+	for src.Next() {
+		row, _ := src.Values()
+		f.rows = append(f.rows, row)
+		n++
+	}
+	return n, nil
+}
+
+func (f *fakeCopier) written() []string {
+	f.Lock()
+	defer f.Unlock()
+	var s []string
+	for _, r := range f.rows {
+		s = append(s, r[0].(string))
+	}
+	return s
+}
+
+// qnameColumns writes only the query name.
+var qnameColumns = []Column{{Name: "qname", Value: func(e *event.Event) interface{} { return e.QName }}}
+
+func newTestWriter(db copier) *Writer {
+	w := NewWriter(db, "localhost:5432/dns", pgx.Identifier{"queries"}, qnameColumns)
+	w.retryWait = time.Millisecond
+	return w
+}
+
+func events(names ...string) []*event.Event {
+	var e []*event.Event
+	for _, n := range names {
+		e = append(e, &event.Event{QName: n})
+	}
+	return e
+}
+
+func TestWriterExport(t *testing.T) {
+	f := &fakeCopier{}
+	w := newTestWriter(f)
+	if err := w.Export(context.TODO(), events("a.", "b.")); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.written(); len(got) != 2 || f.copies != 1 {
+		t.Errorf("Expected 2 rows in 1 copy, got %v in %d copies", got, f.copies)
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	f := &fakeCopier{fail: 2}
+	w := newTestWriter(f)
+	w.retries = 2
+	if err := w.Export(context.TODO(), events("a.")); err != nil {
+		t.Fatalf("Expected the batch to be written after 2 retries, got %s", err)
+	}
+	if f.copies != 3 || len(f.written()) != 1 {
+		t.Errorf("Expected 3 copies writing 1 row, got %d copies writing %v", f.copies, f.written())
+	}
+
+	f = &fakeCopier{fail: 2}
+	w = newTestWriter(f)
+	w.retries = 1
+	if err := w.Export(context.TODO(), events("a.")); err == nil {
+		t.Error("Expected the batch to fail after 1 retry")
+	}
+
+	// When the exporter stops, the batch is written once without retries.
+	f = &fakeCopier{fail: 1}
+	w = newTestWriter(f)
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if err := w.Export(ctx, events("a.")); err == nil || f.copies != 1 {
+		t.Errorf("Expected 1 failed copy, got %d copies: %v", f.copies, err)
+	}
+}
+
+func TestWriterPrepare(t *testing.T) {
+	f := &fakeCopier{}
+	w := newTestWriter(f)
+	w.retries = 1
+	prepared := 0
+	w.prepare = func(context.Context) error {
+		prepared++
+		if prepared == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if err := w.Export(context.TODO(), events("a.")); err != nil {
+		t.Fatalf("Expected the batch to be written after 1 retry, got %s", err)
+	}
+	if err := w.Export(context.TODO(), events("b.")); err != nil {
+		t.Fatalf("Expected the batch to be written, got %s", err)
+	}
+	if prepared != 2 {
+		t.Errorf("Expected prepare to be called 2 times, got %d", prepared)
+	}
+	if f.copies != 2 || len(f.written()) != 2 {
+		t.Errorf("Expected 2 copies writing 2 rows, got %d copies writing %v", f.copies, f.written())
+	}
+}
+
+func TestWriterSpill(t *testing.T) {
+	f := &fakeCopier{fail: 2}
+	w := newTestWriter(f)
+	w.retries = 1
+	var err error
+	if w.spill, err = newSpill(t.TempDir(), w.database); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first batch fails and is spilled.
+	if err := w.Export(context.TODO(), events("a.", "b.")); err != nil {
+		t.Fatalf("Expected the batch to be spilled, got %s", err)
+	}
+	if names, _ := w.spill.files(); len(names) != 1 {
+		t.Fatalf("Expected 1 spill file, got %v", names)
+	}
+
+	// Once the database is back, the spilled rows are written after the new ones.
+	if err := w.Export(context.TODO(), events("c.")); err != nil {
+		t.Fatalf("Expected the batch to be written, got %s", err)
+	}
+	if got := f.written(); len(got) != 3 || got[0] != "c." || got[1] != "a." || got[2] != "b." {
+		t.Errorf("Expected rows c., a. and b., got %v", got)
+	}
+	if names, _ := w.spill.files(); len(names) != 0 {
+		t.Errorf("Expected the spill file to be removed, got %v", names)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	s, err := newSpill(t.TempDir(), "localhost:5432/dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1654070400, 0).UTC()
+	if err := s.write([]string{"ts", "qname", "duration_us"}, [][]interface{}{{ts, "a.", int64(12)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write([]string{"qname"}, [][]interface{}{{"b."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	name, columns, rows, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 3 || len(rows) != 1 || rows[0][0] != ts || rows[0][1] != "a." || rows[0][2] != int64(12) {
+		t.Errorf("Expected the oldest batch with its types, got %v %v", columns, rows)
+	}
+	if err := s.remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, rows, _ := s.next(); len(rows) != 1 || rows[0][0] != "b." {
+		t.Errorf("Expected the second batch, got %v", rows)
+	}
+}
+
+func TestSpillLimit(t *testing.T) {
+	tests := []struct {
+		drop     event.DropPolicy
+		err      error
+		expected string // first rows of the files that are left
+	}{
+		{event.DropNewest, errSpillFull, "a. b."},
+		{event.DropOldest, nil, "b. c."},
+	}
+
+	for i, tc := range tests {
+		s, err := newSpill(t.TempDir(), "localhost:5432/dns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.max, s.drop = 2, tc.drop
+		dropped := testutil.ToFloat64(droppedCount.WithLabelValues(s.database, "spill_full"))
+
+		for _, qname := range []string{"a.", "b."} {
+			if err := s.write([]string{"qname"}, [][]interface{}{{qname}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.write([]string{"qname"}, [][]interface{}{{"c."}}); err != tc.err {
+			t.Errorf("Test %d: expected error %v, got %v", i, tc.err, err)
+		}
+
+		names, _ := s.files()
+		var got []string
+		for _, name := range names {
+			_, rows, _ := s.read(name)
+			got = append(got, rows[0][0].(string))
+		}
+		if strings.Join(got, " ") != tc.expected {
+			t.Errorf("Test %d: expected files with %s, got %v", i, tc.expected, got)
+		}
+		if x := testutil.ToFloat64(droppedCount.WithLabelValues(s.database, "spill_full")) - dropped; x != 1 {
+			t.Errorf("Test %d: expected 1 dropped row, got %v", i, x)
+		}
+	}
+}